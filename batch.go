@@ -2,9 +2,12 @@ package bitcask_db
 
 import (
 	"bitcask-db/data"
+	"bitcask-db/index"
+	"context"
 	"encoding/binary"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
 // 原子性
@@ -18,7 +21,7 @@ type WriteBatch struct {
 	options       WriteBatchOptions
 	mu            *sync.Mutex
 	db            *DB
-	pendingWrites map[string]*data.LogRecord // 暂存用户写入的数据
+	pendingWrites map[string]*data.LogRecord // 暂存用户写入的数据，key 为 keyspace id 和 key 的编码
 }
 
 // NewWriteBatch 初始化 WriteBatch
@@ -37,41 +40,62 @@ func (db *DB) NewWriteBatch(opts WriteBatchOptions) *WriteBatch {
 
 // Put 批量写数据
 func (wb *WriteBatch) Put(key, value []byte) error {
+	return wb.put(0, key, value)
+}
+
+// PutIn 批量写数据到 keyspace 中，和其他 keyspace 的写入在同一个事务中提交
+func (wb *WriteBatch) PutIn(ks *Keyspace, key, value []byte) error {
+	return wb.put(ks.id, key, value)
+}
+
+func (wb *WriteBatch) put(keyspace uint32, key, value []byte) error {
 	if len(key) == 0 {
 		return ErrKeyIsEmpty
 	}
 	wb.mu.Lock()
 	defer wb.mu.Unlock()
 	// 暂存
-	logRecord := &data.LogRecord{Key: key, Value: value}
-	wb.pendingWrites[string(key)] = logRecord
+	logRecord := &data.LogRecord{Key: key, Value: value, Keyspace: keyspace}
+	wb.pendingWrites[pendingKey(keyspace, key)] = logRecord
 	return nil
 }
 
 // Delete 删除数据
 func (wb *WriteBatch) Delete(key []byte) error {
+	return wb.delete(0, wb.db.index, key)
+}
+
+// DeleteIn 删除 keyspace 中的数据
+func (wb *WriteBatch) DeleteIn(ks *Keyspace, key []byte) error {
+	return wb.delete(ks.id, ks.index, key)
+}
+
+func (wb *WriteBatch) delete(keyspace uint32, keyspaceIndex index.Index, key []byte) error {
 	if len(key) == 0 {
 		return ErrKeyIsEmpty
 	}
 	wb.mu.Lock()
 	defer wb.mu.Unlock()
 	// 对不存在的数据直接返回
-	logRecordPos := wb.db.index.Get(key)
+	logRecordPos := keyspaceIndex.Get(key)
 	if logRecordPos == nil {
-		if wb.pendingWrites[string(key)] != nil {
-			delete(wb.pendingWrites, string(key))
-		}
+		delete(wb.pendingWrites, pendingKey(keyspace, key))
 		return nil
 	}
 
 	// 暂存
-	logRecord := &data.LogRecord{Key: key, Type: data.LogRecordDeleted}
-	wb.pendingWrites[string(key)] = logRecord
+	logRecord := &data.LogRecord{Key: key, Type: data.LogRecordDeleted, Keyspace: keyspace}
+	wb.pendingWrites[pendingKey(keyspace, key)] = logRecord
 	return nil
 }
 
 // Commit 提交事务，将暂存的数据写到数据文件，并更新内存索引
 func (wb *WriteBatch) Commit() error {
+	return wb.CommitContext(context.Background())
+}
+
+// CommitContext 提交事务，等待 db.mu 时 ctx 取消则返回 ctx.Err()，暂存的数据保留，可以再次提交
+func (wb *WriteBatch) CommitContext(ctx context.Context) error {
 	wb.mu.Lock()
 	defer wb.mu.Unlock()
 
@@ -85,24 +109,63 @@ func (wb *WriteBatch) Commit() error {
 		return ErrExceedMaxBatchNum
 	}
 	// 加锁保证事务提交串行化
-	wb.db.mu.Lock()
+	if err := wb.db.lockContext(ctx); err != nil {
+		return err
+	}
 	defer wb.db.mu.Unlock()
+	// 暂存数据所在的 keyspace 已经被删除时不能提交
+	for _, record := range wb.pendingWrites {
+		if _, ok := wb.db.keyspaceIds[record.Keyspace]; record.Keyspace != 0 && !ok {
+			return ErrKeyspaceNotFound
+		}
+	}
 	// 获取当前最新的事务序列号
 	seqNo := atomic.AddUint64(&wb.db.seqNo, 1)
 	// 开始写数据到数据文件中
 	positions := make(map[string]*data.LogRecordPos)
+	// 事务中写入的所有版本使用同一个版本号
+	var versionSeq uint64
 	for _, record := range wb.pendingWrites {
-		logRecordPos, err := wb.db.appendLogRecord(
-			&data.LogRecord{
-				Key:   logRecordKeyWithSeqNo(record.Key, seqNo),
-				Value: record.Value,
-				Type:  record.Type,
-			},
-		)
+		logRecord := &data.LogRecord{
+			Key:      logRecordKeyWithSeqNo(record.Key, seqNo),
+			Value:    record.Value,
+			Type:     record.Type,
+			Keyspace: record.Keyspace,
+		}
+		// 默认 keyspace 保留历史版本时，写入和删除都写入一个新的版本
+		if wb.db.historyEnabled() && record.Keyspace == 0 {
+			prev, err := wb.db.historyPrev(record.Key)
+			if err != nil {
+				return err
+			}
+			if versionSeq == 0 {
+				versionSeq = atomic.AddUint64(&wb.db.versionSeq, 1)
+			}
+			deleted := record.Type == data.LogRecordDeleted
+			logRecord.Value = data.EncodeVersionedValue(&data.VersionedValue{
+				Seq:       versionSeq,
+				Timestamp: time.Now().UnixNano(),
+				Deleted:   deleted,
+				Prev:      prev,
+				Value:     record.Value,
+			})
+			logRecord.Type = data.LogRecordTxnVersioned
+			if deleted {
+				logRecord.Type = data.LogRecordVersionedDeleted
+			}
+		} else if record.Type == data.LogRecordNormal && wb.db.isBlobValue(record.Value) {
+			blobRecord, err := wb.db.blobLogRecord(record.Keyspace, record.Key, record.Value)
+			if err != nil {
+				return err
+			}
+			blobRecord.Key = logRecord.Key
+			logRecord = blobRecord
+		}
+		logRecordPos, err := wb.db.appendLogRecord(logRecord)
 		if err != nil {
 			return err
 		}
-		positions[string(record.Key)] = logRecordPos
+		positions[pendingKey(record.Keyspace, record.Key)] = logRecordPos
 	}
 	// 事务提交成功标识
 	finishedRecord := &data.LogRecord{
@@ -115,23 +178,43 @@ func (wb *WriteBatch) Commit() error {
 
 	// 根据配置决定是否持久化
 	if wb.options.SyncWrites && wb.db.activeFile != nil {
-		if err := wb.db.activeFile.Sync(); err != nil {
+		if err := wb.db.syncActiveFile(); err != nil {
 			return err
 		}
 	}
 
-	// 更新内存索引
+	// 批量更新内存索引，其他 keyspace 的数据按照 keyspace 分组更新
 
+	entries := make([]*index.BatchEntry, 0, len(wb.pendingWrites))
+	values := make([][]byte, 0, len(wb.pendingWrites))
+	keyspaceEntries := make(map[uint32][]*index.BatchEntry)
 	for _, record := range wb.pendingWrites {
-		pos := positions[string(record.Key)]
-		var oldPos *data.LogRecordPos
+		entry := &index.BatchEntry{Key: record.Key}
+		pos := positions[pendingKey(record.Keyspace, record.Key)]
 		if record.Type == data.LogRecordNormal {
-			oldPos = wb.db.index.Put(record.Key, pos)
+			entry.Pos = pos
+		}
+		if record.Keyspace == 0 {
+			entries = append(entries, entry)
+			values = append(values, record.Value)
+			if wb.db.historyEnabled() {
+				if record.Type == data.LogRecordDeleted {
+					wb.db.deletedVersions.Put(record.Key, pos)
+				} else {
+					wb.db.deletedVersions.Delete(record.Key)
+				}
+			}
+			continue
 		}
 		if record.Type == data.LogRecordDeleted {
-			oldPos, _ = wb.db.index.Delete(record.Key)
+			wb.db.keyspaceIds[record.Keyspace].addReclaimable(int64(pos.Size))
 		}
-
+		keyspaceEntries[record.Keyspace] = append(keyspaceEntries[record.Keyspace], entry)
+	}
+	for keyspace, ksEntries := range keyspaceEntries {
+		wb.db.keyspaceIds[keyspace].applyIndex(ksEntries)
+	}
+	for _, oldPos := range wb.db.applyIndex(entries, values) {
 		if oldPos != nil {
 			wb.db.reclaimableSize += int64(oldPos.Size)
 		}
@@ -143,6 +226,11 @@ func (wb *WriteBatch) Commit() error {
 	return nil
 }
 
+// pendingKey 暂存数据的 key，由 keyspace id 和 key 编码而成
+func pendingKey(keyspace uint32, key []byte) string {
+	return string(binary.AppendUvarint(nil, uint64(keyspace))) + string(key)
+}
+
 // key+seq number 编码
 func logRecordKeyWithSeqNo(key []byte, seqNo uint64) []byte {
 	seq := make([]byte, binary.MaxVarintLen64)
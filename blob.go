@@ -0,0 +1,325 @@
+package bitcask_db
+
+import (
+	"bitcask-db/data"
+	"bitcask-db/index"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// 大于等于 Options.BlobThreshold 的 value 单独写入 blob 文件，数据文件中只写入一条记录 value 位置的记录
+// blob 文件中的记录保留 key 和 keyspace，回收时根据索引判断记录是否有效
+// 每次打开数据库时都写入新的 blob 文件，不会在上次异常退出时可能不完整的文件之后继续写入
+
+// isBlobValue value 是否需要写入 blob 文件
+func (db *DB) isBlobValue(value []byte) bool {
+	return db.options.BlobThreshold > 0 && len(value) >= db.options.BlobThreshold
+}
+
+// putBlob 将 value 写入 blob 文件，数据文件中只写入 value 的位置
+// 在访问此方法前必须持有互斥锁
+func (db *DB) putBlob(key, value []byte) error {
+	logRecord, err := db.blobLogRecord(0, key, value)
+	if err != nil {
+		return err
+	}
+	logRecord.Key = logRecordKeyWithSeqNo(key, nonTransactionSeqNo)
+	pos, err := db.appendLogRecord(logRecord)
+	if err != nil {
+		return err
+	}
+	if oldPos := db.applyIndex([]*index.BatchEntry{{Key: key, Pos: pos}}, [][]byte{value})[0]; oldPos != nil {
+		db.reclaimableSize += int64(oldPos.Size)
+	}
+	return nil
+}
+
+// blobLogRecord 将 value 写入 blob 文件，返回需要写入数据文件的记录，调用方设置记录的 key
+// 没有开启 SyncWrites 时也先持久化 blob 文件，异常退出之后数据文件中的位置不会指向不完整的 value
+// 在访问此方法前必须持有互斥锁
+func (db *DB) blobLogRecord(keyspace uint32, key, value []byte) (*data.LogRecord, error) {
+	blobPos, err := db.appendBlob(&data.LogRecord{Key: key, Value: value, Keyspace: keyspace})
+	if err != nil {
+		return nil, err
+	}
+	if err := db.activeBlobFile.Sync(); err != nil {
+		// 持久化失败的文件中的数据不再可靠，之后的 value 写入新的文件
+		db.activeBlobFile = nil
+		return nil, err
+	}
+	return &data.LogRecord{
+		Value:    data.EncodeLogRecordPos(blobPos),
+		Type:     data.LogRecordBlob,
+		Keyspace: keyspace,
+	}, nil
+}
+
+// appendBlob 追加写入记录到当前的 blob 文件，写满之后切换新的文件
+// 在访问此方法前必须持有互斥锁
+func (db *DB) appendBlob(logRecord *data.LogRecord) (*data.LogRecordPos, error) {
+	encRecord, size := data.EncodeLogRecord(logRecord)
+	if db.activeBlobFile != nil && db.activeBlobFile.WriteOffset+size > db.options.BlobFileSize {
+		if err := db.activeBlobFile.Sync(); err != nil {
+			return nil, err
+		}
+		db.activeBlobFile = nil
+	}
+	if db.activeBlobFile == nil {
+		blobFile, err := data.OpenBlobFile(db.fs, db.options.DirPath, db.nextBlobFileId)
+		if err != nil {
+			return nil, err
+		}
+		db.blobFiles[blobFile.FileId] = blobFile
+		db.activeBlobFile = blobFile
+		db.nextBlobFileId++
+	}
+	writeOffset := db.activeBlobFile.WriteOffset
+	if err := db.activeBlobFile.Write(encRecord); err != nil {
+		// 不在可能不完整的记录之后继续写入
+		db.activeBlobFile = nil
+		return nil, err
+	}
+	return &data.LogRecordPos{Fid: db.activeBlobFile.FileId, Offset: writeOffset, Size: uint32(size)}, nil
+}
+
+// syncActiveBlobFile 持久化当前写入的 blob 文件，数据文件中的位置记录持久化之前，位置指向的 value 需要先持久化
+// 在访问此方法前必须持有互斥锁
+func (db *DB) syncActiveBlobFile() error {
+	if db.activeBlobFile == nil {
+		return nil
+	}
+	return db.activeBlobFile.Sync()
+}
+
+// readBlobValue 根据数据文件中的记录读取 blob 文件中的 value
+func (db *DB) readBlobValue(logRecord *data.LogRecord) ([]byte, error) {
+	blobPos := data.DecodeLogRecordPos(logRecord.Value)
+	blobFile := db.blobFiles[blobPos.Fid]
+	if blobFile == nil {
+		return nil, ErrBlobFileNotFound
+	}
+	blobRecord, _, err := blobFile.ReadLogRecord(blobPos.Offset)
+	if err != nil {
+		return nil, err
+	}
+	return blobRecord.Value, nil
+}
+
+// loadBlobFiles 打开数据目录中所有的 blob 文件
+func (db *DB) loadBlobFiles() error {
+	fileNames, err := db.fs.ReadDir(db.options.DirPath)
+	if err != nil {
+		return err
+	}
+	var fileIds []int
+	for _, fileName := range fileNames {
+		if strings.HasSuffix(fileName, data.BlobFileNameSuffix) {
+			fileId, err := strconv.Atoi(strings.TrimSuffix(fileName, data.BlobFileNameSuffix))
+			if err != nil {
+				return ErrDataDirectoryCorrupted
+			}
+			fileIds = append(fileIds, fileId)
+		}
+	}
+	sort.Ints(fileIds)
+	for _, fileId := range fileIds {
+		blobFile, err := data.OpenBlobFile(db.fs, db.options.DirPath, uint32(fileId))
+		if err != nil {
+			return err
+		}
+		db.blobFiles[uint32(fileId)] = blobFile
+		db.nextBlobFileId = uint32(fileId) + 1
+	}
+	return nil
+}
+
+// blobRef blob 文件中一条有效的记录
+type blobRef struct {
+	keyspace uint32
+	key      []byte
+	offset   int64
+}
+
+// BlobGC 回收无效数据比例达到 Options.BlobGCRatio 的 blob 文件
+// 有效的 value 写入新的 blob 文件，并在数据文件中写入新的位置，之后删除原来的 blob 文件
+func (db *DB) BlobGC() error {
+	db.mu.Lock()
+	if db.isBlobGC {
+		db.mu.Unlock()
+		return ErrBlobGCIsProgress
+	}
+	db.isBlobGC = true
+	defer func() {
+		db.mu.Lock()
+		db.isBlobGC = false
+		db.mu.Unlock()
+	}()
+	// 之后写入的 value 使用新的 blob 文件，当前的所有文件都可以回收
+	if db.activeBlobFile != nil {
+		if err := db.activeBlobFile.Sync(); err != nil {
+			db.mu.Unlock()
+			return err
+		}
+		db.activeBlobFile = nil
+	}
+	var blobFiles []*data.DataFile
+	for _, blobFile := range db.blobFiles {
+		blobFiles = append(blobFiles, blobFile)
+	}
+	db.mu.Unlock()
+	sort.Slice(blobFiles, func(i, j int) bool {
+		return blobFiles[i].FileId < blobFiles[j].FileId
+	})
+
+	for _, blobFile := range blobFiles {
+		refs, liveSize, totalSize, err := db.scanBlobFile(blobFile)
+		if err != nil {
+			return err
+		}
+		if totalSize == 0 || float32(totalSize-liveSize)/float32(totalSize) < db.options.BlobGCRatio {
+			continue
+		}
+		if err := db.rewriteBlobFile(blobFile, refs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scanBlobFile 读取 blob 文件中所有的记录，返回有效的记录、有效数据的大小和文件中数据的总大小
+func (db *DB) scanBlobFile(blobFile *data.DataFile) ([]*blobRef, int64, int64, error) {
+	var refs []*blobRef
+	var liveSize, offset int64
+	for {
+		blobRecord, size, err := blobFile.ReadLogRecord(offset)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, 0, 0, err
+		}
+		ref := &blobRef{keyspace: blobRecord.Keyspace, key: blobRecord.Key, offset: offset}
+		db.mu.RLock()
+		pos, _ := db.blobLivePos(blobFile.FileId, ref)
+		live := pos != nil
+		db.mu.RUnlock()
+		if live {
+			refs = append(refs, ref)
+			liveSize += size
+		}
+		offset += size
+	}
+	return refs, liveSize, offset, nil
+}
+
+// blobLivePos blob 文件中的记录有效时，返回索引中 key 的位置
+// key 当前的值是以这条记录为基础的操作数链时同样有效，第二个返回值表示索引指向操作数记录
+// 在访问此方法前必须持有互斥锁
+func (db *DB) blobLivePos(fileId uint32, ref *blobRef) (*data.LogRecordPos, bool) {
+	keyspaceIndex := db.index
+	if ref.keyspace != 0 {
+		ks, ok := db.keyspaceIds[ref.keyspace]
+		if !ok {
+			return nil, false
+		}
+		keyspaceIndex = ks.index
+	}
+	pos := keyspaceIndex.Get(ref.key)
+	if pos == nil {
+		return nil, false
+	}
+	logRecord, err := db.readLogRecord(pos)
+	// 沿着操作数链找到最初的值
+	operands := false
+	for err == nil && logRecord.Type == data.LogRecordMerge {
+		_, prev, _ := data.DecodeMergeOperand(logRecord.Value)
+		if prev == nil {
+			return nil, false
+		}
+		operands = true
+		logRecord, err = db.readLogRecord(prev)
+	}
+	if err != nil || logRecord.Type != data.LogRecordBlob {
+		return nil, false
+	}
+	blobPos := data.DecodeLogRecordPos(logRecord.Value)
+	if blobPos.Fid != fileId || blobPos.Offset != ref.offset {
+		return nil, false
+	}
+	return pos, operands
+}
+
+// rewriteBlobFile 将 blob 文件中有效的 value 写入新的 blob 文件，持久化之后删除原来的文件
+func (db *DB) rewriteBlobFile(blobFile *data.DataFile, refs []*blobRef) error {
+	for _, ref := range refs {
+		blobRecord, _, err := blobFile.ReadLogRecord(ref.offset)
+		if err != nil {
+			return err
+		}
+		if err := db.rewriteBlob(blobFile.FileId, ref, blobRecord.Value); err != nil {
+			return err
+		}
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	// 新的位置持久化之后才能删除原来的文件
+	if err := db.syncActiveBlobFile(); err != nil {
+		return err
+	}
+	if db.activeFile != nil {
+		if err := db.syncActiveFile(); err != nil {
+			return err
+		}
+	}
+	delete(db.blobFiles, blobFile.FileId)
+	if err := blobFile.Close(); err != nil {
+		return err
+	}
+	return db.fs.Remove(data.GetBlobFileName(db.options.DirPath, blobFile.FileId))
+}
+
+// rewriteBlob 将一条仍然有效的 value 写入新的 blob 文件，并写入新的位置
+func (db *DB) rewriteBlob(fileId uint32, ref *blobRef, value []byte) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	// 扫描之后 key 可能已经被更新或者删除
+	pos, operands := db.blobLivePos(fileId, ref)
+	if pos == nil {
+		return nil
+	}
+	// 重写之后的记录保留原来的版本号
+	version, err := db.versionAt(pos)
+	if err != nil {
+		return err
+	}
+	var logRecord *data.LogRecord
+	if operands {
+		// 操作数链和这条 value 合并为一条普通记录，合并之后的 value 按照大小决定是否写入新的 blob 文件
+		if value, err = db.getValueByPosition(pos); err != nil {
+			return err
+		}
+		logRecord = &data.LogRecord{Value: value, Type: data.LogRecordNormal, Keyspace: ref.keyspace}
+	}
+	if !operands || db.isBlobValue(value) {
+		if logRecord, err = db.blobLogRecord(ref.keyspace, ref.key, value); err != nil {
+			return err
+		}
+	}
+	logRecord.Version = version
+	logRecord.Key = logRecordKeyWithSeqNo(ref.key, nonTransactionSeqNo)
+	if pos, err = db.appendLogRecord(logRecord); err != nil {
+		return err
+	}
+	entries := []*index.BatchEntry{{Key: ref.key, Pos: pos}}
+	if ref.keyspace != 0 {
+		db.keyspaceIds[ref.keyspace].applyIndex(entries)
+		return nil
+	}
+	if oldPos := db.applyIndex(entries, [][]byte{value})[0]; oldPos != nil {
+		db.reclaimableSize += int64(oldPos.Size)
+	}
+	return nil
+}
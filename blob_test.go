@@ -0,0 +1,242 @@
+package bitcask_db
+
+import (
+	"bitcask-db/data"
+	"bitcask-db/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"sync"
+	"testing"
+)
+
+func TestDB_PutBlob(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-db-blob")
+	opts.DirPath = dir
+	opts.DataFileSize = 1024
+	opts.BlobThreshold = 1024
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	assert.Nil(t, err)
+
+	large := utils.RandomValue(4 * 1024)
+	small := utils.RandomValue(10)
+	assert.Nil(t, db.Put(utils.GetTestKey(1), large))
+	assert.Nil(t, db.Put(utils.GetTestKey(2), small))
+	assert.Equal(t, 1, len(db.blobFiles))
+	// 数据文件中只有 value 的位置
+	assert.True(t, db.index.Get(utils.GetTestKey(1)).Size < 1024)
+
+	val, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, large, val)
+	val, err = db.Get(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.Equal(t, small, val)
+
+	iterator := db.NewIterator(DefaultIteratorOptions)
+	assert.Equal(t, utils.GetTestKey(1), iterator.Key())
+	val, err = iterator.Value()
+	assert.Nil(t, err)
+	assert.Equal(t, large, val)
+	iterator.Close()
+
+	values := make(map[string][]byte)
+	assert.Nil(t, db.Fold(func(key []byte, value []byte) bool {
+		values[string(key)] = value
+		return true
+	}))
+	assert.Equal(t, large, values[string(utils.GetTestKey(1))])
+	assert.Equal(t, small, values[string(utils.GetTestKey(2))])
+
+	// 事务和 keyspace 中写入的 value
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put(utils.GetTestKey(3), large))
+	assert.Nil(t, wb.Commit())
+	ks, err := db.CreateKeyspace("blobs")
+	assert.Nil(t, err)
+	assert.Nil(t, ks.Put(utils.GetTestKey(4), large))
+	assert.Nil(t, db.Close())
+
+	// 重启和 merge 之后仍然可以读取
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	assert.Nil(t, db2.Merge())
+	assert.Nil(t, db2.Close())
+	db3, err := Open(opts)
+	defer destroyDB(db3)
+	assert.Nil(t, err)
+	for _, i := range []int{1, 3} {
+		val, err = db3.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, large, val)
+	}
+	ks, err = db3.Keyspace("blobs")
+	assert.Nil(t, err)
+	val, err = ks.Get(utils.GetTestKey(4))
+	assert.Nil(t, err)
+	assert.Equal(t, large, val)
+	// 重启之后写入新的 blob 文件
+	assert.Nil(t, db3.Put(utils.GetTestKey(5), large))
+	assert.Equal(t, 2, len(db3.blobFiles))
+}
+
+func TestDB_BlobGC(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-db-blob-gc")
+	opts.DirPath = dir
+	opts.BlobThreshold = 1024
+	opts.BlobFileSize = 64 * 1024
+	db, err := Open(opts)
+	assert.Nil(t, err)
+
+	for i := 0; i < 50; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(4*1024)))
+	}
+	assert.True(t, len(db.blobFiles) > 1)
+	// 覆盖大部分的 key，之前的 blob 文件中大部分是无效数据
+	live := make(map[int][]byte)
+	for i := 0; i < 50; i++ {
+		if i%4 != 0 {
+			assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(10)))
+			continue
+		}
+		live[i], err = db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, db.Delete(utils.GetTestKey(48)))
+	delete(live, 48)
+	fileIds := make(map[uint32]bool)
+	for fileId := range db.blobFiles {
+		fileIds[fileId] = true
+	}
+
+	assert.Nil(t, db.BlobGC())
+	for fileId := range db.blobFiles {
+		assert.False(t, fileIds[fileId])
+	}
+	for fileId := range fileIds {
+		_, err := os.Stat(data.GetBlobFileName(dir, fileId))
+		assert.True(t, os.IsNotExist(err))
+	}
+	for i, value := range live {
+		val, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, value, val)
+	}
+	assert.Nil(t, db.Close())
+
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	for i, value := range live {
+		val, err := db2.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, value, val)
+	}
+	_, err = db2.Get(utils.GetTestKey(48))
+	assert.Equal(t, ErrKeyNotFound, err)
+}
+
+func TestDB_BlobGCConcurrentGet(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-db-blob-gc-get")
+	opts.DirPath = dir
+	opts.BlobThreshold = 1024
+	opts.BlobFileSize = 16 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	live := make(map[int][]byte)
+	for i := 0; i < 40; i++ {
+		live[i] = utils.RandomValue(2 * 1024)
+		assert.Nil(t, db.Put(utils.GetTestKey(i), live[i]))
+	}
+
+	// BlobGC 删除文件的同时读取仍然有效的 key
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for round := 0; round < 20; round++ {
+			for i := 40; i < 60; i++ {
+				_ = db.Put(utils.GetTestKey(i), utils.RandomValue(2*1024))
+			}
+			_ = db.BlobGC()
+		}
+		close(done)
+	}()
+	for {
+		select {
+		case <-done:
+			wg.Wait()
+			return
+		default:
+		}
+		for i, value := range live {
+			val, err := db.Get(utils.GetTestKey(i))
+			assert.Nil(t, err)
+			assert.Equal(t, value, val)
+		}
+	}
+}
+
+func TestDB_BlobGCMergeOperands(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-db-blob-operands")
+	opts.DirPath = dir
+	opts.BlobThreshold = 1024
+	opts.MergeOperator = AppendOperator{}
+	db, err := Open(opts)
+	assert.Nil(t, err)
+
+	large := utils.RandomValue(4 * 1024)
+	assert.Nil(t, db.Put(utils.GetTestKey(1), large))
+	assert.Nil(t, db.MergeValue(utils.GetTestKey(1), []byte("-tail")))
+	// 另一个 key 覆盖之后 blob 文件中超过一半是无效数据
+	assert.Nil(t, db.Put(utils.GetTestKey(2), utils.RandomValue(8*1024)))
+	assert.Nil(t, db.Put(utils.GetTestKey(2), []byte("small")))
+
+	assert.Nil(t, db.BlobGC())
+	_, err = os.Stat(data.GetBlobFileName(dir, 0))
+	assert.True(t, os.IsNotExist(err))
+	expected := append(append([]byte{}, large...), []byte("-tail")...)
+	val, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, expected, val)
+	assert.Nil(t, db.Close())
+
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	val, err = db2.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, expected, val)
+}
+
+func TestDB_BlobOptions(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-db-blob-options")
+	defer os.RemoveAll(dir)
+	opts.DirPath = dir
+	opts.BlobThreshold = 1024
+
+	invalid := opts
+	invalid.BlobFileSize = 0
+	_, err := Open(invalid)
+	assert.NotNil(t, err)
+	invalid = opts
+	invalid.BlobGCRatio = 0
+	_, err = Open(invalid)
+	assert.NotNil(t, err)
+	invalid.BlobGCRatio = 1.5
+	_, err = Open(invalid)
+	assert.NotNil(t, err)
+
+	// 没有开启 blob 时不检查 blob 相关的配置
+	db, err := Open(Options{DirPath: dir, DataFileSize: DefaultOptions.DataFileSize, IndexType: DefaultOptions.IndexType})
+	assert.Nil(t, err)
+	assert.Nil(t, db.Close())
+}
@@ -0,0 +1,110 @@
+package bitcask_db
+
+import (
+	"bitcask-db/data"
+	"bitcask-db/index"
+	"bitcask-db/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+// crashDB 模拟进程崩溃，不执行 Close 中的持久化逻辑，直接释放文件
+func crashDB(t *testing.T, db *DB) {
+	assert.Nil(t, db.index.Close())
+	assert.Nil(t, db.activeFile.Close())
+	for _, file := range db.olderFiles {
+		assert.Nil(t, file.Close())
+	}
+	assert.Nil(t, db.fileLock.Unlock())
+}
+
+func TestDB_BPlusTreeCheckpoint(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-db-bptree-checkpoint")
+	opts.DirPath = dir
+	opts.DataFileSize = 1024 * 1024
+	opts.IndexType = BPlusTree
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 100; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+	checkpoint := db.index.(index.CheckpointIndex).Checkpoint()
+	assert.Equal(t, db.activeFile.FileId, checkpoint.Fid)
+	assert.Equal(t, db.activeFile.WriteOffset, checkpoint.Offset)
+
+	// 数据已经写入数据文件，但是还没有更新索引时崩溃
+	db.mu.Lock()
+	_, err = db.appendLogRecord(&data.LogRecord{
+		Key:   logRecordKeyWithSeqNo(utils.GetTestKey(100), nonTransactionSeqNo),
+		Value: []byte("not in index"),
+	})
+	assert.Nil(t, err)
+	_, err = db.appendLogRecord(&data.LogRecord{
+		Key:  logRecordKeyWithSeqNo(utils.GetTestKey(1), nonTransactionSeqNo),
+		Type: data.LogRecordDeleted,
+	})
+	assert.Nil(t, err)
+	db.mu.Unlock()
+	assert.Nil(t, db.activeFile.Sync())
+	crashDB(t, db)
+
+	// 重启后从记录的位置重放数据文件
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	val, err := db2.Get(utils.GetTestKey(100))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("not in index"), val)
+	_, err = db2.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+	assert.Equal(t, uint(100), db2.Stat().KeyNum)
+	checkpoint = db2.index.(index.CheckpointIndex).Checkpoint()
+	assert.Equal(t, db2.activeFile.WriteOffset, checkpoint.Offset)
+
+	// 记录的位置超出了数据文件的范围，重建整个索引
+	assert.Nil(t, db2.Put(utils.GetTestKey(200), []byte("lost value")))
+	lostPos := db2.index.Get(utils.GetTestKey(200))
+	crashDB(t, db2)
+	assert.Nil(t, os.Truncate(data.GetDataFileName(dir, lostPos.Fid), lostPos.Offset))
+
+	db3, err := Open(opts)
+	assert.Nil(t, err)
+	_, err = db3.Get(utils.GetTestKey(200))
+	assert.Equal(t, ErrKeyNotFound, err)
+	val, err = db3.Get(utils.GetTestKey(100))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("not in index"), val)
+	assert.Equal(t, uint(100), db3.Stat().KeyNum)
+	assert.Nil(t, db3.Close())
+}
+
+func TestDB_BPlusTreeCheckpointBatch(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-db-bptree-checkpoint-batch")
+	opts.DirPath = dir
+	opts.IndexType = BPlusTree
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	// 关闭之后保存了事务序列号才能使用事务
+	assert.Nil(t, db.Put(utils.GetTestKey(100), utils.RandomValue(128)))
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, wb.Put(utils.GetTestKey(i), utils.RandomValue(128)))
+	}
+	assert.Nil(t, wb.Commit())
+	// 事务提交之后记录的位置同样前进到数据文件的末尾
+	checkpoint := db.index.(index.CheckpointIndex).Checkpoint()
+	assert.NotNil(t, checkpoint)
+	assert.Equal(t, db.activeFile.FileId, checkpoint.Fid)
+	assert.Equal(t, db.activeFile.WriteOffset, checkpoint.Offset)
+}
@@ -0,0 +1,94 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+)
+
+// Key 缓存的 key，即数据在磁盘上的位置
+// 数据文件只会追加写入，同一个位置上的数据不会改变，key 更新之后位置也会随之改变
+type Key struct {
+	Fid    uint32
+	Offset int64
+}
+
+// LRU 按照 value 占用的字节数限制大小的 LRU 缓存，并发安全
+type LRU struct {
+	capacity int64                 // 缓存的最大字节数
+	size     int64                 // 当前缓存的字节数
+	ll       *list.List            // 最近访问的数据在链表头部
+	items    map[Key]*list.Element // key 对应的链表节点
+	lock     *sync.Mutex
+	hits     uint64 // 命中次数
+	misses   uint64 // 未命中次数
+}
+
+type entry struct {
+	key   Key
+	value []byte
+}
+
+// NewLRU 初始化 LRU 缓存
+func NewLRU(capacity int64) *LRU {
+	return &LRU{
+		capacity: capacity,
+		ll:       list.New(),
+		items:    make(map[Key]*list.Element),
+		lock:     new(sync.Mutex),
+	}
+}
+
+// Get 获取缓存的 value，返回的是拷贝，调用方可以随意修改
+func (c *LRU) Get(key Key) ([]byte, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	elem, ok := c.items[key]
+	if !ok {
+		c.misses++
+		return nil, false
+	}
+	c.hits++
+	c.ll.MoveToFront(elem)
+	value := elem.Value.(*entry).value
+	return append(make([]byte, 0, len(value)), value...), true
+}
+
+// Put 写入缓存，超出容量时淘汰最久没有访问的数据
+func (c *LRU) Put(key Key, value []byte) {
+	size := int64(len(value))
+	if size > c.capacity {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if elem, ok := c.items[key]; ok {
+		c.ll.MoveToFront(elem)
+		return
+	}
+	c.items[key] = c.ll.PushFront(&entry{key: key, value: append(make([]byte, 0, size), value...)})
+	c.size += size
+	for c.size > c.capacity {
+		c.removeElement(c.ll.Back())
+	}
+}
+
+// Len 缓存的数据量
+func (c *LRU) Len() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.ll.Len()
+}
+
+// Stats 缓存的命中次数和未命中次数
+func (c *LRU) Stats() (hits uint64, misses uint64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.hits, c.misses
+}
+
+func (c *LRU) removeElement(elem *list.Element) {
+	ent := elem.Value.(*entry)
+	c.ll.Remove(elem)
+	delete(c.items, ent.key)
+	c.size -= int64(len(ent.value))
+}
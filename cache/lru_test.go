@@ -0,0 +1,48 @@
+package cache
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestLRU_PutGet(t *testing.T) {
+	c := NewLRU(10)
+
+	_, ok := c.Get(Key{Fid: 1, Offset: 0})
+	assert.False(t, ok)
+
+	c.Put(Key{Fid: 1, Offset: 0}, []byte("aaaa"))
+	val, ok := c.Get(Key{Fid: 1, Offset: 0})
+	assert.True(t, ok)
+	assert.Equal(t, []byte("aaaa"), val)
+
+	// 修改返回值不影响缓存
+	val[0] = 'b'
+	val, _ = c.Get(Key{Fid: 1, Offset: 0})
+	assert.Equal(t, []byte("aaaa"), val)
+
+	hits, misses := c.Stats()
+	assert.Equal(t, uint64(2), hits)
+	assert.Equal(t, uint64(1), misses)
+
+	// 超过容量的 value 不缓存
+	c.Put(Key{Fid: 1, Offset: 10}, []byte("01234567890"))
+	_, ok = c.Get(Key{Fid: 1, Offset: 10})
+	assert.False(t, ok)
+}
+
+func TestLRU_Evict(t *testing.T) {
+	c := NewLRU(10)
+	c.Put(Key{Fid: 1, Offset: 0}, []byte("aaaa"))
+	c.Put(Key{Fid: 1, Offset: 4}, []byte("bbbb"))
+	// 访问之后变为最近使用
+	_, ok := c.Get(Key{Fid: 1, Offset: 0})
+	assert.True(t, ok)
+
+	c.Put(Key{Fid: 1, Offset: 8}, []byte("cccc"))
+	assert.Equal(t, 2, c.Len())
+	_, ok = c.Get(Key{Fid: 1, Offset: 4})
+	assert.False(t, ok)
+	_, ok = c.Get(Key{Fid: 1, Offset: 0})
+	assert.True(t, ok)
+}
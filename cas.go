@@ -0,0 +1,157 @@
+package bitcask_db
+
+import (
+	"bitcask-db/data"
+	"bytes"
+)
+
+// 条件写入在 db.mu 的保护下读取当前的值并比较，只有条件满足时才追加写入，保证读-改-写的原子性
+
+// versionOffsetBits 版本号中偏移量占用的位数，数据文件的大小不能超过 1<<versionOffsetBits
+const versionOffsetBits = 40
+
+// 版本号不是 key 的写入次数，而是 key 每次写入时记录的位置，同一个 key 每次写入之后版本号都会变大
+// merge 和 blob GC 重写记录时在记录中保存原来的版本号，重写和重启之后之前获取的版本号仍然有效
+
+// positionVersion 由记录在数据文件中的位置得到 key 的版本号，0 表示 key 不存在
+// 数据追加写入，每次写入的位置都比之前的位置大，版本号随着写入单调递增
+func positionVersion(pos *data.LogRecordPos) uint64 {
+	if pos == nil {
+		return 0
+	}
+	return (uint64(pos.Fid)<<versionOffsetBits | uint64(pos.Offset)) + 1
+}
+
+// recordVersion 索引中位置为 pos 的记录的版本号，重写过的记录使用保存的版本号
+func recordVersion(logRecord *data.LogRecord, pos *data.LogRecordPos) uint64 {
+	if logRecord.Version != 0 {
+		return logRecord.Version
+	}
+	return positionVersion(pos)
+}
+
+// versionAt 读取索引中位置为 pos 的记录的版本号，pos 为 nil 表示 key 不存在
+// 在访问此方法前必须持有锁
+func (db *DB) versionAt(pos *data.LogRecordPos) (uint64, error) {
+	if pos == nil {
+		return 0, nil
+	}
+	logRecord, err := db.readLogRecord(pos)
+	if err != nil {
+		return 0, err
+	}
+	return recordVersion(logRecord, pos), nil
+}
+
+// GetWithVersion 读取数据以及当前的版本号，版本号用于 PutIfVersion 和 DeleteIfVersion
+func (db *DB) GetWithVersion(key []byte) ([]byte, uint64, error) {
+	if len(key) == 0 {
+		return nil, 0, ErrKeyIsEmpty
+	}
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	pos := db.index.Get(key)
+	if pos == nil {
+		return nil, 0, ErrKeyNotFound
+	}
+	value, err := db.getValueByPosition(pos)
+	if err != nil {
+		return nil, 0, err
+	}
+	version, err := db.versionAt(pos)
+	if err != nil {
+		return nil, 0, err
+	}
+	return value, version, nil
+}
+
+// CompareAndSwap 当前的值等于 expected 时写入新的值，expected 为 nil 表示 key 不存在
+// 返回是否写入成功
+func (db *DB) CompareAndSwap(key, expected, value []byte) (bool, error) {
+	if len(key) == 0 {
+		return false, ErrKeyIsEmpty
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if ok, err := db.valueEquals(key, expected); err != nil || !ok {
+		return false, err
+	}
+	if err := db.put(key, value); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// PutIfAbsent key 不存在时写入，返回是否写入成功
+func (db *DB) PutIfAbsent(key, value []byte) (bool, error) {
+	return db.CompareAndSwap(key, nil, value)
+}
+
+// DeleteIfEquals 当前的值等于 expected 时删除 key，返回是否删除成功
+func (db *DB) DeleteIfEquals(key, expected []byte) (bool, error) {
+	if len(key) == 0 {
+		return false, ErrKeyIsEmpty
+	}
+	if expected == nil {
+		return false, nil
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if ok, err := db.valueEquals(key, expected); err != nil || !ok {
+		return false, err
+	}
+	if err := db.delete(key); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// PutIfVersion 当前的版本号等于 version 时写入新的值，version 为 0 表示 key 不存在
+// 返回是否写入成功
+func (db *DB) PutIfVersion(key, value []byte, version uint64) (bool, error) {
+	if len(key) == 0 {
+		return false, ErrKeyIsEmpty
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if current, err := db.versionAt(db.index.Get(key)); err != nil || current != version {
+		return false, err
+	}
+	if err := db.put(key, value); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// DeleteIfVersion 当前的版本号等于 version 时删除 key，返回是否删除成功
+func (db *DB) DeleteIfVersion(key []byte, version uint64) (bool, error) {
+	if len(key) == 0 {
+		return false, ErrKeyIsEmpty
+	}
+	if version == 0 {
+		return false, nil
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if current, err := db.versionAt(db.index.Get(key)); err != nil || current != version {
+		return false, err
+	}
+	if err := db.delete(key); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// valueEquals 判断 key 当前的值是否等于 expected，expected 为 nil 表示 key 不存在
+// 在访问此方法前必须持有互斥锁
+func (db *DB) valueEquals(key, expected []byte) (bool, error) {
+	pos := db.index.Get(key)
+	if pos == nil || expected == nil {
+		return pos == nil && expected == nil, nil
+	}
+	value, err := db.getValueByPosition(pos)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(value, expected), nil
+}
@@ -0,0 +1,192 @@
+package bitcask_db
+
+import (
+	"bitcask-db/data"
+	"bitcask-db/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"sync"
+	"testing"
+)
+
+func TestDB_CompareAndSwap(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-db-cas")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	key := utils.GetTestKey(1)
+	ok, err := db.PutIfAbsent(key, []byte("a"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	ok, err = db.PutIfAbsent(key, []byte("b"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	ok, err = db.CompareAndSwap(key, []byte("b"), []byte("c"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	ok, err = db.CompareAndSwap(key, []byte("a"), []byte("c"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	val, err := db.Get(key)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("c"), val)
+
+	ok, err = db.DeleteIfEquals(key, []byte("a"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	ok, err = db.DeleteIfEquals(key, []byte("c"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	_, err = db.Get(key)
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	_, err = db.CompareAndSwap(nil, nil, []byte("a"))
+	assert.Equal(t, ErrKeyIsEmpty, err)
+}
+
+func TestDB_PutIfVersion(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-db-version")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	key := utils.GetTestKey(1)
+	_, version, err := db.GetWithVersion(key)
+	assert.Equal(t, ErrKeyNotFound, err)
+	assert.Equal(t, uint64(0), version)
+
+	// 版本号为 0 表示 key 不存在
+	ok, err := db.PutIfVersion(key, []byte("a"), 0)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	val, v1, err := db.GetWithVersion(key)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("a"), val)
+	assert.NotEqual(t, uint64(0), v1)
+
+	// 写入相同的值版本号也会变化
+	err = db.Put(key, []byte("a"))
+	assert.Nil(t, err)
+	_, v2, err := db.GetWithVersion(key)
+	assert.Nil(t, err)
+	assert.True(t, v2 > v1)
+	ok, err = db.PutIfVersion(key, []byte("b"), v1)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	ok, err = db.PutIfVersion(key, []byte("b"), v2)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	_, v3, err := db.GetWithVersion(key)
+	assert.Nil(t, err)
+	ok, err = db.DeleteIfVersion(key, v2)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	ok, err = db.DeleteIfVersion(key, v3)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	_, err = db.Get(key)
+	assert.Equal(t, ErrKeyNotFound, err)
+}
+
+func TestDB_CompareAndSwapConcurrent(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-db-cas-concurrent")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	// 多个协程通过 CAS 对计数器加一，不会丢失更新
+	key := []byte("counter")
+	err = db.Put(key, []byte{0})
+	assert.Nil(t, err)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				for {
+					val, version, err := db.GetWithVersion(key)
+					assert.Nil(t, err)
+					ok, err := db.PutIfVersion(key, []byte{val[0] + 1}, version)
+					assert.Nil(t, err)
+					if ok {
+						break
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	val, err := db.Get(key)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{200}, val)
+}
+
+func TestDB_VersionAfterRewrite(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-db-version-rewrite")
+	opts.DirPath = dir
+	opts.DataFileSize = 512
+	opts.DataFileMergeRatio = 0
+	opts.BlobThreshold = 1024
+	db, err := Open(opts)
+	assert.Nil(t, err)
+
+	versions := make(map[int]uint64)
+	for i := 0; i < 10; i++ {
+		value := utils.RandomValue(10)
+		if i%2 == 0 {
+			value = utils.RandomValue(4 * 1024)
+		}
+		assert.Nil(t, db.Put(utils.GetTestKey(i), value))
+	}
+	// 覆盖之后 blob 文件中超过一半是无效数据
+	for i := 10; i < 20; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(4*1024)))
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+	for i := 0; i < 10; i++ {
+		_, versions[i], err = db.GetWithVersion(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+
+	// blob GC 重写记录之后版本号不变
+	assert.Nil(t, db.BlobGC())
+	_, err = os.Stat(data.GetBlobFileName(dir, 0))
+	assert.True(t, os.IsNotExist(err))
+	for i := 0; i < 10; i++ {
+		_, version, err := db.GetWithVersion(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, versions[i], version)
+	}
+
+	// merge 之后重启版本号不变
+	assert.Nil(t, db.Merge())
+	assert.Nil(t, db.Close())
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	for i := 0; i < 10; i++ {
+		_, version, err := db2.GetWithVersion(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, versions[i], version)
+	}
+	ok, err := db2.PutIfVersion(utils.GetTestKey(0), []byte("a"), versions[0])
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	_, version, err := db2.GetWithVersion(utils.GetTestKey(0))
+	assert.Nil(t, err)
+	assert.True(t, version > versions[9])
+	ok, err = db2.DeleteIfVersion(utils.GetTestKey(1), versions[1])
+	assert.Nil(t, err)
+	assert.True(t, ok)
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	bitcask "bitcask-db"
+	"flag"
+	"fmt"
+	"os"
+)
+
+// 将数据目录转换为另一种索引类型
+// go run ./cmd/migrate -dir /tmp/bitcask-db -from btree -to bptree
+
+var indexTypes = map[string]bitcask.IndexerType{
+	"btree":  bitcask.BTree,
+	"art":    bitcask.ART,
+	"bptree": bitcask.BPlusTree,
+}
+
+func main() {
+	dir := flag.String("dir", "", "数据目录")
+	from := flag.String("from", "btree", "当前的索引类型：btree、art、bptree")
+	to := flag.String("to", "bptree", "目标索引类型：btree、art、bptree")
+	flag.Parse()
+
+	fromType, ok := indexTypes[*from]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unsupported index type: %s\n", *from)
+		os.Exit(2)
+	}
+	toType, ok := indexTypes[*to]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unsupported index type: %s\n", *to)
+		os.Exit(2)
+	}
+
+	opts := bitcask.DefaultOptions
+	opts.DirPath = *dir
+	opts.IndexType = fromType
+	if err := bitcask.MigrateIndexType(opts, toType); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to migrate index: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("migrated %s from %s to %s\n", *dir, *from, *to)
+}
@@ -0,0 +1,78 @@
+package bitcask_db
+
+import (
+	"context"
+	"time"
+)
+
+// 带 context 的操作只能在等待 db.mu 的过程中被取消，返回 ctx.Err()：
+//   - PutContext、DeleteContext、WriteBatch.CommitContext 获取到锁之后的写入和持久化不会被中断，
+//     否则活跃文件中会留下不完整的记录
+//   - GetContext 获取到读锁之后的读取不会被中断
+//   - 使用 NewIteratorContext 创建的迭代器，Value 在等待 db.mu 时可以被取消，
+//     ctx 取消之后 Valid 返回 false，通过 Err 获取取消的原因
+//
+// sync.RWMutex 的等待无法被取消，等待时按照逐渐增加的间隔重试 TryLock，不会为每次等待创建协程
+
+const (
+	minLockBackoff = 50 * time.Microsecond
+	maxLockBackoff = 10 * time.Millisecond
+)
+
+// GetContext 根据 key 读取数据，等待 db.mu 时 ctx 取消则返回 ctx.Err()
+func (db *DB) GetContext(ctx context.Context, key []byte) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, ErrKeyIsEmpty
+	}
+	// 持有读锁直到读取结束，BlobGC 和 merge 不会在读取期间移除或者关闭文件
+	if err := db.rLockContext(ctx); err != nil {
+		return nil, err
+	}
+	defer db.mu.RUnlock()
+	// 从内存数据结构中取出 key 对应的索引信息
+	logrecordPos := db.index.Get(key)
+	// 如果 key 不存在内存索引中，那么这个key就不存在
+	if logrecordPos == nil {
+		return nil, ErrKeyNotFound
+	}
+	// 根据文件 ID 找到对应的数据文件
+	return db.getValueByPosition(logrecordPos)
+}
+
+// lockContext 获取 db.mu 的写锁，ctx 取消时停止等待
+func (db *DB) lockContext(ctx context.Context) error {
+	return waitLock(ctx, db.mu.TryLock, db.mu.Lock)
+}
+
+// rLockContext 获取 db.mu 的读锁，ctx 取消时停止等待
+func (db *DB) rLockContext(ctx context.Context) error {
+	return waitLock(ctx, db.mu.TryRLock, db.mu.RLock)
+}
+
+// waitLock 等待获取锁，ctx 取消时返回 ctx.Err()，没有获取到锁
+// ctx 不会被取消时直接阻塞等待
+func waitLock(ctx context.Context, tryLock func() bool, lock func()) error {
+	if ctx.Done() == nil {
+		lock()
+		return nil
+	}
+	backoff := minLockBackoff
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if tryLock() {
+			return nil
+		}
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+		if backoff < maxLockBackoff {
+			backoff *= 2
+		}
+	}
+}
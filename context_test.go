@@ -0,0 +1,164 @@
+package bitcask_db
+
+import (
+	"bitcask-db/utils"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestDB_PutContext(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-db-put-context")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	err = db.PutContext(context.Background(), utils.GetTestKey(1), utils.RandomValue(24))
+	assert.Nil(t, err)
+
+	// 已经取消的 ctx 直接返回
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = db.PutContext(ctx, utils.GetTestKey(2), utils.RandomValue(24))
+	assert.Equal(t, context.Canceled, err)
+
+	// 等待 db.mu 时超时
+	db.mu.Lock()
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	err = db.PutContext(ctx, utils.GetTestKey(2), utils.RandomValue(24))
+	assert.Equal(t, context.DeadlineExceeded, err)
+	err = db.DeleteContext(ctx, utils.GetTestKey(1))
+	assert.Equal(t, context.DeadlineExceeded, err)
+	cancel()
+	db.mu.Unlock()
+
+	_, err = db.Get(utils.GetTestKey(2))
+	assert.Equal(t, ErrKeyNotFound, err)
+	val, err := db.GetContext(context.Background(), utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.NotNil(t, val)
+
+	// 等待超时之后锁仍然可以正常获取
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	err = db.PutContext(ctx, utils.GetTestKey(2), utils.RandomValue(24))
+	assert.Nil(t, err)
+	err = db.DeleteContext(ctx, utils.GetTestKey(1))
+	assert.Nil(t, err)
+	_, err = db.GetContext(ctx, utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+	val, err = db.GetContext(ctx, utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.NotNil(t, val)
+}
+
+func TestDB_LockContextNoLeak(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-db-lock-context")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put(utils.GetTestKey(1), utils.RandomValue(24)))
+
+	// 持有锁期间使用很短的超时反复等待，超时之后不会留下等待锁的协程
+	db.mu.Lock()
+	goroutines := runtime.NumGoroutine()
+	for i := 0; i < 100; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+		_, err = db.GetContext(ctx, utils.GetTestKey(1))
+		assert.Equal(t, context.DeadlineExceeded, err)
+		err = db.PutContext(ctx, utils.GetTestKey(2), utils.RandomValue(24))
+		assert.Equal(t, context.DeadlineExceeded, err)
+		cancel()
+	}
+	assert.True(t, runtime.NumGoroutine() <= goroutines)
+	db.mu.Unlock()
+
+	val, err := db.GetContext(context.Background(), utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.NotNil(t, val)
+}
+
+func TestWriteBatch_CommitContext(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-db-commit-context")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	err = wb.Put(utils.GetTestKey(1), utils.RandomValue(24))
+	assert.Nil(t, err)
+
+	db.mu.Lock()
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	err = wb.CommitContext(ctx)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	cancel()
+	db.mu.Unlock()
+	_, err = db.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// 取消之后暂存的数据保留，可以再次提交
+	err = wb.CommitContext(context.Background())
+	assert.Nil(t, err)
+	val, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.NotNil(t, val)
+}
+
+func TestDB_NewIteratorContext(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-db-iterator-context")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 10; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(24))
+		assert.Nil(t, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	iterator, err := db.NewIteratorContext(ctx, DefaultIteratorOptions)
+	assert.Nil(t, err)
+	defer iterator.Close()
+	var count int
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		val, err := iterator.Value()
+		assert.Nil(t, err)
+		assert.NotNil(t, val)
+		count++
+		if count == 5 {
+			cancel()
+		}
+	}
+	assert.Equal(t, 5, count)
+	assert.Equal(t, context.Canceled, iterator.Err())
+	_, err = iterator.Value()
+	assert.Equal(t, context.Canceled, err)
+
+	_, err = db.NewIteratorContext(ctx, DefaultIteratorOptions)
+	assert.Equal(t, context.Canceled, err)
+
+	// 等待 db.mu 时超时
+	ctx2, cancel2 := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel2()
+	iterator2, err := db.NewIteratorContext(ctx2, DefaultIteratorOptions)
+	assert.Nil(t, err)
+	defer iterator2.Close()
+	db.mu.Lock()
+	iterator2.Rewind()
+	_, err = iterator2.Value()
+	assert.Equal(t, context.DeadlineExceeded, err)
+	db.mu.Unlock()
+}
@@ -11,9 +11,13 @@ import (
 
 const (
 	DataFileNameSuffix    = ".data"
+	HintFileNameSuffix    = ".hint"
 	HintFileName          = "hint-index"
 	MergeFinishedFileName = "merge-finished"
 	SeqNoFileName         = "seq-no"
+	IndexSnapshotFileName = "index-snapshot"
+	KeyspaceFileName      = "keyspaces"
+	BlobFileNameSuffix    = ".blob"
 )
 
 var ErrInvalidCRC = errors.New("invalid crc value,log record maybe corrupted")
@@ -23,11 +27,12 @@ type DataFile struct {
 	FileId      uint32        // 文件ID
 	WriteOffset int64         // 文件写入偏移，记录文件写到哪里了
 	IoManager   fio.IOManager // io 读写管理
+	fs          fio.FileSystem
 }
 
-func OpenDataFile(dirPath string, fileId uint32, ioType fio.FileIOType) (*DataFile, error) {
+func OpenDataFile(fs fio.FileSystem, dirPath string, fileId uint32, ioType fio.FileIOType) (*DataFile, error) {
 	fileName := GetDataFileName(dirPath, fileId)
-	return newDataFile(fileName, fileId, ioType)
+	return newDataFile(fs, fileName, fileId, ioType)
 }
 
 // ReadLogRecord 根据 offset 从文件中读取 LogRecord
@@ -85,17 +90,58 @@ func (df *DataFile) ReadLogRecord(offset int64) (*LogRecord, int64, error) {
 	if crc != header.crc {
 		return nil, 0, ErrInvalidCRC
 	}
+	decodeKeyPrefix(logRecord)
 	return logRecord, recordSize, nil
 }
 
-func (df *DataFile) Write(buf []byte) error {
-	nBytes, err := df.IoManager.Write(buf)
+// IsTornRecord 判断 offset 处校验失败的记录是否是异常退出时没有写完整的最后一条记录
+// 预分配的文件中未写入的区域全部为零，没有写完整的记录之后直到文件末尾都应该为零
+func (df *DataFile) IsTornRecord(offset int64) (bool, error) {
+	fileSize, err := df.IoManager.Size()
 	if err != nil {
-		return err
+		return false, err
+	}
+	var headerBytes int64 = maxLogRecordHeaderSize
+	if offset+maxLogRecordHeaderSize > fileSize {
+		headerBytes = fileSize - offset
+	}
+	headerBuf, err := df.readNBytes(headerBytes, offset)
+	if err != nil {
+		return false, err
+	}
+	header, headerSize := decodeLogRecordHeader(headerBuf)
+	if header == nil {
+		return false, nil
 	}
-	// 写入之后要写入偏移
+	end := offset + headerSize + int64(header.keySize) + int64(header.valueSize)
+	// 没有预分配的空白区域，无法判断是否为没有写完整的记录
+	if end >= fileSize {
+		return false, nil
+	}
+	buf := make([]byte, 64*1024)
+	for end < fileSize {
+		n := int64(len(buf))
+		if end+n > fileSize {
+			n = fileSize - end
+		}
+		if _, err := df.IoManager.Read(buf[:n], end); err != nil {
+			return false, err
+		}
+		for _, b := range buf[:n] {
+			if b != 0 {
+				return false, nil
+			}
+		}
+		end += n
+	}
+	return true, nil
+}
+
+func (df *DataFile) Write(buf []byte) error {
+	nBytes, err := df.IoManager.Write(buf)
+	// 写入之后要写入偏移，部分写入失败时偏移也要包含已经写入的数据
 	df.WriteOffset += int64(nBytes)
-	return nil
+	return err
 }
 
 func (df *DataFile) Sync() error {
@@ -103,44 +149,161 @@ func (df *DataFile) Sync() error {
 
 }
 
+// SyncRange 提交给定范围的数据回写，IO 不支持时直接忽略
+func (df *DataFile) SyncRange(offset, n int64) error {
+	if rs, ok := df.IoManager.(fio.RangeSyncer); ok {
+		return rs.SyncRange(offset, n)
+	}
+	return nil
+}
+
 func (df *DataFile) Close() error {
 	return df.IoManager.Close()
 }
 
-func OpenHintFile(dirPath string) (*DataFile, error) {
+func OpenHintFile(fs fio.FileSystem, dirPath string) (*DataFile, error) {
 	fileName := filepath.Join(dirPath, HintFileName)
-	return newDataFile(fileName, 0, fio.StandardFIO)
+	return newDataFile(fs, fileName, 0, fio.StandardFIO)
 }
 
 // OpenMergeFinishedFile 打开标识 merge 完成的文件
-func OpenMergeFinishedFile(dirPath string) (*DataFile, error) {
+func OpenMergeFinishedFile(fs fio.FileSystem, dirPath string) (*DataFile, error) {
 	fileName := filepath.Join(dirPath, MergeFinishedFileName)
-	return newDataFile(fileName, 0, fio.StandardFIO)
+	return newDataFile(fs, fileName, 0, fio.StandardFIO)
 }
 
 // OpenSeqNoFile 打开存储事务序列号的文件
-func OpenSeqNoFile(dirPath string) (*DataFile, error) {
+func OpenSeqNoFile(fs fio.FileSystem, dirPath string) (*DataFile, error) {
 	fileName := filepath.Join(dirPath, SeqNoFileName)
-	return newDataFile(fileName, 0, fio.StandardFIO)
+	return newDataFile(fs, fileName, 0, fio.StandardFIO)
+}
+
+// OpenIndexSnapshotFile 打开索引快照文件
+func OpenIndexSnapshotFile(fs fio.FileSystem, dirPath string) (*DataFile, error) {
+	fileName := filepath.Join(dirPath, IndexSnapshotFileName)
+	return newDataFile(fs, fileName, 0, fio.StandardFIO)
+}
+
+// OpenIndexSnapshotTmpFile 打开写入中的临时索引快照文件，写入完成后重命名为索引快照文件
+func OpenIndexSnapshotTmpFile(fs fio.FileSystem, dirPath string) (*DataFile, error) {
+	fileName := filepath.Join(dirPath, IndexSnapshotFileName+".tmp")
+	if err := fs.RemoveAll(fileName); err != nil {
+		return nil, err
+	}
+	return newDataFile(fs, fileName, 0, fio.StandardFIO)
+}
+
+// OpenKeyspaceFile 打开存储 keyspace 信息的文件
+func OpenKeyspaceFile(fs fio.FileSystem, dirPath string) (*DataFile, error) {
+	fileName := filepath.Join(dirPath, KeyspaceFileName)
+	return newDataFile(fs, fileName, 0, fio.StandardFIO)
+}
+
+// WriteKeyspaceFile 重写存储 keyspace 信息的文件，先写入临时文件再重命名
+func WriteKeyspaceFile(fs fio.FileSystem, dirPath string, records []byte) error {
+	fileName := filepath.Join(dirPath, KeyspaceFileName)
+	tmpFileName := fileName + ".tmp"
+	if err := fs.RemoveAll(tmpFileName); err != nil {
+		return err
+	}
+	keyspaceFile, err := newDataFile(fs, tmpFileName, 0, fio.StandardFIO)
+	if err != nil {
+		return err
+	}
+	if err := keyspaceFile.Write(records); err != nil {
+		_ = keyspaceFile.Close()
+		return err
+	}
+	if err := keyspaceFile.Sync(); err != nil {
+		_ = keyspaceFile.Close()
+		return err
+	}
+	if err := keyspaceFile.Close(); err != nil {
+		return err
+	}
+	return fs.Rename(tmpFileName, fileName)
 }
 
 // WriteHintRecord 写入索引信息到 hint 文件
-func (df *DataFile) WriteHintRecord(key []byte, pos *LogRecordPos) error {
+func (df *DataFile) WriteHintRecord(keyspace uint32, key []byte, typ LogRecordType, pos *LogRecordPos) error {
 	record := &LogRecord{
-		Key:   key,
-		Value: EncodeLogRecordPos(pos),
+		Key:      key,
+		Value:    EncodeLogRecordPos(pos),
+		Type:     typ,
+		Keyspace: keyspace,
 	}
 	encRecord, _ := EncodeLogRecord(record)
 	return df.Write(encRecord)
 }
 
+// OpenDataHintFile 打开数据文件对应的 hint 文件
+func OpenDataHintFile(fs fio.FileSystem, dirPath string, fileId uint32) (*DataFile, error) {
+	return newDataFile(fs, GetHintFileName(dirPath, fileId), fileId, fio.StandardFIO)
+}
+
+// EncodeHintRecord 对数据文件中一条记录的索引信息进行编码，key 保留事务序列号，不包含 value
+func EncodeHintRecord(keyspace uint32, key []byte, typ LogRecordType, pos *LogRecordPos) []byte {
+	encRecord, _ := EncodeLogRecord(&LogRecord{
+		Key:      key,
+		Value:    EncodeLogRecordPos(pos),
+		Type:     typ,
+		Keyspace: keyspace,
+	})
+	return encRecord
+}
+
+// WriteDataHintFile 将编码后的索引记录写入数据文件对应的 hint 文件
+// 最后追加一条 key 为空的记录保存数据文件的大小，加载时用于校验 hint 文件是否完整有效
+// 先写入临时文件再重命名，避免留下写了一半的 hint 文件
+func WriteDataHintFile(fs fio.FileSystem, dirPath string, fileId uint32, hintRecords []byte, dataSize int64) error {
+	fileName := GetHintFileName(dirPath, fileId)
+	tmpFileName := fileName + ".tmp"
+	if err := fs.RemoveAll(tmpFileName); err != nil {
+		return err
+	}
+	hintFile, err := newDataFile(fs, tmpFileName, fileId, fio.StandardFIO)
+	if err != nil {
+		return err
+	}
+	finRecord, _ := EncodeLogRecord(&LogRecord{Value: EncodeLogRecordPos(&LogRecordPos{Fid: fileId, Offset: dataSize})})
+	for _, buf := range [][]byte{hintRecords, finRecord} {
+		if err := hintFile.Write(buf); err != nil {
+			_ = hintFile.Close()
+			return err
+		}
+	}
+	if err := hintFile.Sync(); err != nil {
+		_ = hintFile.Close()
+		return err
+	}
+	if err := hintFile.Close(); err != nil {
+		return err
+	}
+	return fs.Rename(tmpFileName, fileName)
+}
+
 func GetDataFileName(dirPath string, fileId uint32) string {
 	return filepath.Join(dirPath, fmt.Sprintf("%09d", fileId)+DataFileNameSuffix)
 }
 
-func newDataFile(fileName string, fileId uint32, ioType fio.FileIOType) (*DataFile, error) {
+// OpenBlobFile 打开存储大 value 的 blob 文件
+func OpenBlobFile(fs fio.FileSystem, dirPath string, fileId uint32) (*DataFile, error) {
+	return newDataFile(fs, GetBlobFileName(dirPath, fileId), fileId, fio.StandardFIO)
+}
+
+// GetBlobFileName blob 文件名称
+func GetBlobFileName(dirPath string, fileId uint32) string {
+	return filepath.Join(dirPath, fmt.Sprintf("%09d", fileId)+BlobFileNameSuffix)
+}
+
+// GetHintFileName 数据文件对应的 hint 文件名称
+func GetHintFileName(dirPath string, fileId uint32) string {
+	return filepath.Join(dirPath, fmt.Sprintf("%09d", fileId)+HintFileNameSuffix)
+}
+
+func newDataFile(fs fio.FileSystem, fileName string, fileId uint32, ioType fio.FileIOType) (*DataFile, error) {
 	// 初始化 IOManager 管理器接口
-	ioManager, err := fio.NewIOManager(fileName, ioType)
+	ioManager, err := fs.OpenIOManager(fileName, ioType)
 	if err != nil {
 		return nil, err
 	}
@@ -148,15 +311,90 @@ func newDataFile(fileName string, fileId uint32, ioType fio.FileIOType) (*DataFi
 		FileId:      fileId,
 		WriteOffset: 0,
 		IoManager:   ioManager,
+		fs:          fs,
 	}, nil
 }
 
 func (df *DataFile) SetIOManager(dirPath string, ioType fio.FileIOType) error {
+	// 由句柄池管理的文件，关闭之后再次读取时使用新的 IO 类型打开
+	if pio, ok := df.IoManager.(*fio.PooledIO); ok {
+		return pio.Reset(ioType)
+	}
 	// 关闭原来的MMap，重新用标准IO打开
 	if err := df.IoManager.Close(); err != nil {
 		return err
 	}
-	ioManager, err := fio.NewIOManager(GetDataFileName(dirPath, df.FileId), ioType)
+	ioManager, err := df.fs.OpenIOManager(GetDataFileName(dirPath, df.FileId), ioType)
+	if err != nil {
+		return err
+	}
+	df.IoManager = ioManager
+	return nil
+}
+
+// SetMMapRW 将数据文件切换为可读写的 MMap，文件预分配到 capacity 大小
+// 从 WriteOffset 的位置继续追加写入
+func (df *DataFile) SetMMapRW(dirPath string, capacity int64) error {
+	if err := df.IoManager.Close(); err != nil {
+		return err
+	}
+	ioManager, err := df.fs.OpenMMapRW(GetDataFileName(dirPath, df.FileId), capacity)
+	if err != nil {
+		return err
+	}
+	if mm, ok := ioManager.(*fio.MMapRW); ok {
+		if err := mm.SetSize(df.WriteOffset); err != nil {
+			_ = ioManager.Close()
+			return err
+		}
+	}
+	df.IoManager = ioManager
+	return nil
+}
+
+// SetPreallocate 将数据文件切换为预分配空间的文件 IO，文件预分配到 capacity 大小
+// 从 WriteOffset 的位置继续追加写入
+func (df *DataFile) SetPreallocate(dirPath string, capacity int64) error {
+	if err := df.IoManager.Close(); err != nil {
+		return err
+	}
+	ioManager, err := df.fs.OpenPreallocIO(GetDataFileName(dirPath, df.FileId), capacity)
+	if err != nil {
+		return err
+	}
+	if pio, ok := ioManager.(*fio.PreallocIO); ok {
+		if err := pio.SetSize(df.WriteOffset); err != nil {
+			_ = ioManager.Close()
+			return err
+		}
+	}
+	df.IoManager = ioManager
+	return nil
+}
+
+// OpenPooledDataFile 打开由句柄池管理的数据文件，读取时才会真正打开文件
+func OpenPooledDataFile(fs fio.FileSystem, pool *fio.FilePool, dirPath string, fileId uint32, ioType fio.FileIOType) *DataFile {
+	return &DataFile{
+		FileId:    fileId,
+		IoManager: pool.Open(fs, GetDataFileName(dirPath, fileId), ioType),
+		fs:        fs,
+	}
+}
+
+// SetFilePool 将已经打开的数据文件交给句柄池管理，文件被句柄池关闭之后使用 ioType 重新打开
+func (df *DataFile) SetFilePool(dirPath string, pool *fio.FilePool, ioType fio.FileIOType) {
+	if _, ok := df.IoManager.(*fio.PooledIO); ok {
+		return
+	}
+	df.IoManager = pool.Pin(df.IoManager, df.fs, GetDataFileName(dirPath, df.FileId), ioType)
+}
+
+// SetWriteBuffer 为数据文件开启写缓冲，追加写入的数据先写到缓冲区中
+func (df *DataFile) SetWriteBuffer(bufferSize int) error {
+	if _, ok := df.IoManager.(*fio.BufferedIO); ok {
+		return nil
+	}
+	ioManager, err := fio.NewBufferedIO(df.IoManager, bufferSize)
 	if err != nil {
 		return err
 	}
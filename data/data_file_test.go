@@ -8,11 +8,11 @@ import (
 )
 
 func TestOpenDataFile(t *testing.T) {
-	dataFile1, err := OpenDataFile(os.TempDir(), 0, fio.StandardFIO)
+	dataFile1, err := OpenDataFile(fio.DefaultFileSystem, os.TempDir(), 0, fio.StandardFIO)
 	assert.Nil(t, err)
 	assert.NotNil(t, dataFile1)
 
-	dataFile2, err := OpenDataFile(os.TempDir(), 11, fio.StandardFIO)
+	dataFile2, err := OpenDataFile(fio.DefaultFileSystem, os.TempDir(), 11, fio.StandardFIO)
 	assert.Nil(t, err)
 	assert.NotNil(t, dataFile2)
 
@@ -20,7 +20,7 @@ func TestOpenDataFile(t *testing.T) {
 }
 
 func TestDataFile_Write(t *testing.T) {
-	dataFile, err := OpenDataFile(os.TempDir(), 0, fio.StandardFIO)
+	dataFile, err := OpenDataFile(fio.DefaultFileSystem, os.TempDir(), 0, fio.StandardFIO)
 	assert.Nil(t, err)
 	assert.NotNil(t, dataFile)
 
@@ -35,7 +35,7 @@ func TestDataFile_Write(t *testing.T) {
 }
 
 func TestDataFile_Close(t *testing.T) {
-	dataFile, err := OpenDataFile(os.TempDir(), 123, fio.StandardFIO)
+	dataFile, err := OpenDataFile(fio.DefaultFileSystem, os.TempDir(), 123, fio.StandardFIO)
 	assert.Nil(t, err)
 	assert.NotNil(t, dataFile)
 
@@ -48,7 +48,7 @@ func TestDataFile_Close(t *testing.T) {
 }
 
 func TestDataFile_Sync(t *testing.T) {
-	dataFile, err := OpenDataFile(os.TempDir(), 122, fio.StandardFIO)
+	dataFile, err := OpenDataFile(fio.DefaultFileSystem, os.TempDir(), 122, fio.StandardFIO)
 	assert.Nil(t, err)
 	assert.NotNil(t, dataFile)
 
@@ -60,7 +60,7 @@ func TestDataFile_Sync(t *testing.T) {
 }
 
 func TestDataFile_Read(t *testing.T) {
-	dataFile, err := OpenDataFile(os.TempDir(), 444, fio.StandardFIO)
+	dataFile, err := OpenDataFile(fio.DefaultFileSystem, os.TempDir(), 444, fio.StandardFIO)
 	assert.Nil(t, err)
 	assert.NotNil(t, dataFile)
 
@@ -116,3 +116,54 @@ func TestDataFile_Read(t *testing.T) {
 func TestName(t *testing.T) {
 	t.Log(os.TempDir())
 }
+
+func TestDataFile_ReadKeyspaceRecord(t *testing.T) {
+	dataFile, err := OpenDataFile(fio.NewMemFileSystem(), os.TempDir(), 445, fio.StandardFIO)
+	assert.Nil(t, err)
+
+	rec := &LogRecord{
+		Key:      []byte("name"),
+		Value:    []byte("YZ-DB"),
+		Type:     LogRecordDeleted,
+		Keyspace: 300,
+	}
+	res, size := EncodeLogRecord(rec)
+	assert.Nil(t, dataFile.Write(res))
+
+	readRec, readSize, err := dataFile.ReadLogRecord(0)
+	assert.Nil(t, err)
+	assert.Equal(t, rec, readRec)
+	assert.Equal(t, size, readSize)
+}
+
+func TestDataFile_ReadVersionRecord(t *testing.T) {
+	dataFile, err := OpenDataFile(fio.NewMemFileSystem(), os.TempDir(), 446, fio.StandardFIO)
+	assert.Nil(t, err)
+
+	rec1 := &LogRecord{
+		Key:     []byte("name"),
+		Value:   []byte("YZ-DB"),
+		Type:    LogRecordNormal,
+		Version: 1<<45 + 7,
+	}
+	res1, size1 := EncodeLogRecord(rec1)
+	assert.Nil(t, dataFile.Write(res1))
+	rec2 := &LogRecord{
+		Key:      []byte("name"),
+		Value:    []byte("YZ-DB"),
+		Type:     LogRecordBlob,
+		Keyspace: 300,
+		Version:  9,
+	}
+	res2, size2 := EncodeLogRecord(rec2)
+	assert.Nil(t, dataFile.Write(res2))
+
+	readRec1, readSize1, err := dataFile.ReadLogRecord(0)
+	assert.Nil(t, err)
+	assert.Equal(t, rec1, readRec1)
+	assert.Equal(t, size1, readSize1)
+	readRec2, readSize2, err := dataFile.ReadLogRecord(size1)
+	assert.Nil(t, err)
+	assert.Equal(t, rec2, readRec2)
+	assert.Equal(t, size2, readSize2)
+}
@@ -2,7 +2,6 @@ package data
 
 import (
 	"encoding/binary"
-	"fmt"
 	"hash/crc32"
 )
 
@@ -20,8 +19,32 @@ const (
 	LogRecordNormal LogRecordType = iota
 	LogRecordDeleted
 	LogRecordTxnFindShed
+	// LogRecordMerge MergeValue 写入的操作数，读取时和之前的值合并
+	LogRecordMerge
+	// LogRecordRangeDeleted 范围删除标记，key 为 EncodeKeyRange 编码的范围
+	LogRecordRangeDeleted
+	// LogRecordVersioned 保留历史版本时写入的记录，value 中记录版本号和之前版本的位置
+	LogRecordVersioned
+	// LogRecordTxnVersioned 事务中写入的 LogRecordVersioned 记录，事务完成之后才会生效
+	LogRecordTxnVersioned
+	// LogRecordHistory 历史版本的副本，不会更新到索引中，只能通过版本链访问
+	LogRecordHistory
+	// LogRecordChunk PutStream 写入的一个分块，不会更新到索引中，只能通过分块清单访问
+	LogRecordChunk
+	// LogRecordStream PutStream 的分块清单，value 中记录所有分块的位置
+	LogRecordStream
+	// LogRecordBlob value 存储在 blob 文件中，记录中的 value 为在 blob 文件中的位置
+	LogRecordBlob
+	// LogRecordVersionedDeleted 保留历史版本时删除 key 写入的记录，value 和 LogRecordVersioned 相同，之前的版本仍然可以访问
+	LogRecordVersionedDeleted
 )
 
+// keyspaceFlag 记录类型的最高位，表示 key 的前面带有 keyspace id
+const keyspaceFlag LogRecordType = 0x80
+
+// versionFlag 记录类型的次高位，表示 key 的前面带有记录保存的版本号
+const versionFlag LogRecordType = 0x40
+
 // crc type keySize valueSize
 // 4+1+5+5
 const maxLogRecordHeaderSize = binary.MaxVarintLen32*2 + 5
@@ -29,9 +52,11 @@ const maxLogRecordHeaderSize = binary.MaxVarintLen32*2 + 5
 // LogRecord 写入到数据文件的记录
 // 之所以叫日志，是因为数据文件中的数据是追加写入的，类似于日志的格式
 type LogRecord struct {
-	Key   []byte
-	Value []byte
-	Type  LogRecordType
+	Key      []byte
+	Value    []byte
+	Type     LogRecordType
+	Keyspace uint32 // 所属的 keyspace，0 表示默认的 keyspace
+	Version  uint64 // merge 和 blob GC 重写时保留的版本号，0 表示版本号由记录的位置得到
 }
 
 type LogRecordHeader struct {
@@ -53,21 +78,37 @@ func EncodeLogRecord(logRecord *LogRecord) ([]byte, int64) {
 	// 初始化 header 部分的字节数组
 	header := make([]byte, maxLogRecordHeaderSize)
 
+	// 非默认 keyspace 的记录在 key 前面写入 keyspace id，保存了版本号的记录在最前面写入版本号，并在类型中标记
+	key, recordType := logRecord.Key, logRecord.Type
+	if logRecord.Keyspace != 0 || logRecord.Version != 0 {
+		key = make([]byte, binary.MaxVarintLen64+binary.MaxVarintLen32+len(logRecord.Key))
+		var n int
+		if logRecord.Version != 0 {
+			n += binary.PutUvarint(key[n:], logRecord.Version)
+			recordType |= versionFlag
+		}
+		if logRecord.Keyspace != 0 {
+			n += binary.PutUvarint(key[n:], uint64(logRecord.Keyspace))
+			recordType |= keyspaceFlag
+		}
+		key = append(key[:n], logRecord.Key...)
+	}
+
 	// 从第5个字节开始写，
-	header[4] = logRecord.Type
+	header[4] = recordType
 	var index = 5
 	// 5 字节之后，存储的是 key size，value size
-	index += binary.PutVarint(header[index:], int64(len(logRecord.Key)))
+	index += binary.PutVarint(header[index:], int64(len(key)))
 	index += binary.PutVarint(header[index:], int64(len(logRecord.Value)))
-	var size = index + len(logRecord.Key) + len(logRecord.Value)
+	var size = index + len(key) + len(logRecord.Value)
 
 	encBytes := make([]byte, size)
 	// 将 header 部分的内容拷贝过来
 	copy(encBytes[:index], header[:index])
 	// 将 key value 数据拷贝到字节数组中
 
-	copy(encBytes[index:], logRecord.Key)
-	copy(encBytes[index+len(logRecord.Key):], logRecord.Value)
+	copy(encBytes[index:], key)
+	copy(encBytes[index+len(key):], logRecord.Value)
 
 	// 对整个 LogRecord 的数据进行 CRC 校验
 	crc := crc32.ChecksumIEEE(encBytes[4:])
@@ -99,6 +140,23 @@ func decodeLogRecordHeader(buf []byte) (*LogRecordHeader, int64) {
 	return header, int64(index)
 }
 
+// decodeKeyPrefix 从带有版本号和 keyspace 标记的记录中解析出版本号和 keyspace id
+func decodeKeyPrefix(logRecord *LogRecord) {
+	if logRecord.Type&versionFlag != 0 {
+		version, n := binary.Uvarint(logRecord.Key)
+		logRecord.Version = version
+		logRecord.Key = logRecord.Key[n:]
+		logRecord.Type &^= versionFlag
+	}
+	if logRecord.Type&keyspaceFlag == 0 {
+		return
+	}
+	keyspace, n := binary.Uvarint(logRecord.Key)
+	logRecord.Keyspace = uint32(keyspace)
+	logRecord.Key = logRecord.Key[n:]
+	logRecord.Type &^= keyspaceFlag
+}
+
 // EncodeLogRecordPos 对位置信息进行编码
 func EncodeLogRecordPos(pos *LogRecordPos) []byte {
 	buf := make([]byte, binary.MaxVarintLen32+binary.MaxVarintLen64)
@@ -112,10 +170,9 @@ func EncodeLogRecordPos(pos *LogRecordPos) []byte {
 // DecodeLogRecordPos 对位置信息进行接码
 func DecodeLogRecordPos(buf []byte) *LogRecordPos {
 	var index = 0
-	fmt.Println(buf)
 	fileId, n := binary.Varint(buf[index:])
 	index += n
-	offset, _ := binary.Varint(buf[index:])
+	offset, n := binary.Varint(buf[index:])
 	index += n
 	size, _ := binary.Varint(buf[index:])
 	return &LogRecordPos{
@@ -125,6 +182,138 @@ func DecodeLogRecordPos(buf []byte) *LogRecordPos {
 	}
 }
 
+// EncodeMergeOperand 对操作数记录的 value 进行编码
+// depth 为操作数链的长度，prev 为 key 之前的记录位置，key 不存在时为空
+func EncodeMergeOperand(depth uint32, prev *LogRecordPos, operand []byte) []byte {
+	var prevBuf []byte
+	if prev != nil {
+		prevBuf = EncodeLogRecordPos(prev)
+	}
+	buf := make([]byte, binary.MaxVarintLen32*2+len(prevBuf)+len(operand))
+	var index = 0
+	index += binary.PutUvarint(buf[index:], uint64(depth))
+	index += binary.PutUvarint(buf[index:], uint64(len(prevBuf)))
+	index += copy(buf[index:], prevBuf)
+	index += copy(buf[index:], operand)
+	return buf[:index]
+}
+
+// DecodeMergeOperand 对操作数记录的 value 进行解码
+func DecodeMergeOperand(buf []byte) (uint32, *LogRecordPos, []byte) {
+	var index = 0
+	depth, n := binary.Uvarint(buf[index:])
+	index += n
+	prevSize, n := binary.Uvarint(buf[index:])
+	index += n
+	var prev *LogRecordPos
+	if prevSize > 0 {
+		prev = DecodeLogRecordPos(buf[index : index+int(prevSize)])
+		index += int(prevSize)
+	}
+	return uint32(depth), prev, buf[index:]
+}
+
+// VersionedValue 保留历史版本的记录的 value
+type VersionedValue struct {
+	Seq       uint64        // 版本号
+	Timestamp int64         // 写入的时间
+	Deleted   bool          // 是否是删除 key 的版本
+	Prev      *LogRecordPos // 之前版本的位置，没有之前的版本时为空
+	Value     []byte
+}
+
+// EncodeVersionedValue 对保留历史版本的记录的 value 进行编码
+func EncodeVersionedValue(v *VersionedValue) []byte {
+	var prevBuf []byte
+	if v.Prev != nil {
+		prevBuf = EncodeLogRecordPos(v.Prev)
+	}
+	buf := make([]byte, binary.MaxVarintLen64*2+1+binary.MaxVarintLen32+len(prevBuf)+len(v.Value))
+	var index = 0
+	index += binary.PutUvarint(buf[index:], v.Seq)
+	index += binary.PutVarint(buf[index:], v.Timestamp)
+	if v.Deleted {
+		buf[index] = 1
+	}
+	index++
+	index += binary.PutUvarint(buf[index:], uint64(len(prevBuf)))
+	index += copy(buf[index:], prevBuf)
+	index += copy(buf[index:], v.Value)
+	return buf[:index]
+}
+
+// DecodeVersionedValue 对保留历史版本的记录的 value 进行解码
+func DecodeVersionedValue(buf []byte) *VersionedValue {
+	v := &VersionedValue{}
+	var index = 0
+	var n int
+	v.Seq, n = binary.Uvarint(buf[index:])
+	index += n
+	v.Timestamp, n = binary.Varint(buf[index:])
+	index += n
+	v.Deleted = buf[index] == 1
+	index++
+	prevSize, n := binary.Uvarint(buf[index:])
+	index += n
+	if prevSize > 0 {
+		v.Prev = DecodeLogRecordPos(buf[index : index+int(prevSize)])
+		index += int(prevSize)
+	}
+	v.Value = buf[index:]
+	return v
+}
+
+// EncodeStreamManifest 对分块清单进行编码，size 为所有分块的数据总大小
+func EncodeStreamManifest(size int64, chunks []*LogRecordPos) []byte {
+	buf := make([]byte, binary.MaxVarintLen64*2+len(chunks)*(binary.MaxVarintLen32*2+binary.MaxVarintLen64))
+	var index = 0
+	index += binary.PutUvarint(buf[index:], uint64(size))
+	index += binary.PutUvarint(buf[index:], uint64(len(chunks)))
+	for _, chunk := range chunks {
+		index += binary.PutUvarint(buf[index:], uint64(chunk.Fid))
+		index += binary.PutVarint(buf[index:], chunk.Offset)
+		index += binary.PutUvarint(buf[index:], uint64(chunk.Size))
+	}
+	return buf[:index]
+}
+
+// DecodeStreamManifest 对分块清单进行解码
+func DecodeStreamManifest(buf []byte) (int64, []*LogRecordPos) {
+	var index = 0
+	size, n := binary.Uvarint(buf[index:])
+	index += n
+	count, n := binary.Uvarint(buf[index:])
+	index += n
+	chunks := make([]*LogRecordPos, count)
+	for i := range chunks {
+		fid, n := binary.Uvarint(buf[index:])
+		index += n
+		offset, n := binary.Varint(buf[index:])
+		index += n
+		chunkSize, n := binary.Uvarint(buf[index:])
+		index += n
+		chunks[i] = &LogRecordPos{Fid: uint32(fid), Offset: offset, Size: uint32(chunkSize)}
+	}
+	return int64(size), chunks
+}
+
+// EncodeKeyRange 对范围删除标记的 key 范围 [start, end) 进行编码，end 为空表示没有上界
+func EncodeKeyRange(start, end []byte) []byte {
+	buf := make([]byte, binary.MaxVarintLen32+len(start)+len(end))
+	var index = 0
+	index += binary.PutUvarint(buf[index:], uint64(len(start)))
+	index += copy(buf[index:], start)
+	index += copy(buf[index:], end)
+	return buf[:index]
+}
+
+// DecodeKeyRange 对范围删除标记的 key 范围进行解码
+func DecodeKeyRange(buf []byte) ([]byte, []byte) {
+	startSize, n := binary.Uvarint(buf)
+	start := buf[n : n+int(startSize)]
+	return start, buf[n+int(startSize):]
+}
+
 func getLogRecordCRC(lr *LogRecord, header []byte) uint32 {
 	if lr == nil {
 		return 0
@@ -111,3 +111,17 @@ func TestGetLogRecordCRC(t *testing.T) {
 	t.Log(crc3)
 	assert.Equal(t, uint32(679461690), crc3)
 }
+
+func TestEncodeLogRecordPos(t *testing.T) {
+	pos := &LogRecordPos{Fid: 1, Offset: 1024 * 1024 * 300, Size: 128}
+	res := DecodeLogRecordPos(EncodeLogRecordPos(pos))
+	assert.Equal(t, pos, res)
+}
+
+func TestEncodeVersionedValue(t *testing.T) {
+	v1 := &VersionedValue{Seq: 300, Timestamp: 1700000000, Value: []byte("YZ-DB")}
+	assert.Equal(t, v1, DecodeVersionedValue(EncodeVersionedValue(v1)))
+
+	v2 := &VersionedValue{Seq: 301, Timestamp: 1700000001, Deleted: true, Prev: &LogRecordPos{Fid: 2, Offset: 1024}, Value: []byte{}}
+	assert.Equal(t, v2, DecodeVersionedValue(EncodeVersionedValue(v2)))
+}
@@ -1,20 +1,23 @@
 package bitcask_db
 
 import (
+	"bitcask-db/cache"
 	"bitcask-db/data"
 	"bitcask-db/fio"
 	"bitcask-db/index"
-	"bitcask-db/utils"
+	"context"
 	"errors"
 	"fmt"
-	"github.com/gofrs/flock"
 	"io"
+	"math"
 	"os"
 	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // 面向用户的操作接口
@@ -28,53 +31,82 @@ const (
 type DB struct {
 	options         Options
 	mu              *sync.RWMutex
-	fileIds         []int                     // 只能用于加载索引的时候使用
-	activeFile      *data.DataFile            // 当前活跃数据文件，可以用于写入
-	olderFiles      map[uint32]*data.DataFile // 旧的数据文件，只能用于读
-	index           index.Index               // 内存索引
-	seqNo           uint64                    // 事务序列号，全局递增
-	isMerging       bool                      // 是否正在merge
-	seqNoFileExists bool                      // 存储事务序列号的文件是否存在
-	isInitial       bool                      // 是否是第一次初始化此数据目录
-	fileLock        *flock.Flock              // 文件锁，保证多进程之间的互斥
-	bytesWrite      uint                      // 记录写入多少字节数
-	reclaimableSize int64                     // 表示有多少数据是无效的
+	fileIds         []int                      // 只能用于加载索引的时候使用
+	activeFile      *data.DataFile             // 当前活跃数据文件，可以用于写入
+	olderFiles      map[uint32]*data.DataFile  // 旧的数据文件，只能用于读
+	index           index.Index                // 内存索引
+	seqNo           uint64                     // 事务序列号，全局递增
+	versionSeq      uint64                     // 保留历史版本时的版本号，全局递增
+	deletedVersions index.Index                // 删除之后仍然保留历史版本的 key，位置为删除版本的位置
+	isMerging       bool                       // 是否正在merge
+	seqNoFileExists bool                       // 存储事务序列号的文件是否存在
+	isInitial       bool                       // 是否是第一次初始化此数据目录
+	fileLock        fio.FileLock               // 文件锁，保证多进程之间的互斥
+	bytesWrite      uint                       // 记录写入多少字节数
+	bytesRangeSync  uint                       // 上次提交回写之后写入的字节数
+	reclaimableSize int64                      // 表示有多少数据是无效的
+	mergeLoaded     bool                       // 启动时是否加载了 merge 完成的数据文件
+	valueCache      *cache.LRU                 // value 缓存，为空时表示不使用缓存
+	startupTime     time.Duration              // 打开数据库（加载索引）的耗时
+	activeHints     []byte                     // 活跃文件中记录的索引信息，用于写入 hint 文件
+	activeHintValid bool                       // activeHints 是否完整覆盖了活跃文件
+	snapshotLock    *sync.Mutex                // 保证同一时间只有一个索引快照在写入
+	fs              fio.FileSystem             // 数据目录所在的文件系统
+	activeFileFault bool                       // 活跃文件写入或持久化失败，末尾可能有不完整的记录，需要切换新的活跃文件
+	filePool        *fio.FilePool              // 旧数据文件的句柄池，为空时旧数据文件一直保持打开
+	operandFloor    uint32                     // 操作数记录只能引用这个文件及之后的记录，之前的文件会被 merge 重写
+	keyspaces       map[string]*Keyspace       // 默认 keyspace 之外的 keyspace，按名称查找
+	keyspaceIds     map[uint32]*Keyspace       // 默认 keyspace 之外的 keyspace，按 id 查找
+	nextKeyspaceId  uint32                     // 下一个创建的 keyspace 使用的 id，删除的 id 不会被复用
+	blobFiles       map[uint32]*data.DataFile  // 所有的 blob 文件，包括当前写入的文件
+	activeBlobFile  *data.DataFile             // 当前写入的 blob 文件，为空时在写入 blob 时创建
+	nextBlobFileId  uint32                     // 下一个 blob 文件的 id
+	isBlobGC        bool                       // 是否正在回收 blob 文件
+	secondaries     map[string]*secondaryIndex // 按照名称查找二级索引
 }
 
 type Stat struct {
-	KeyNum          uint  // key 的总数量
-	DataFileNum     uint  // 磁盘上数据文件的总数量
-	ReclaimableSize int64 // 可以进行 merge 回收的数据量，以字节为单位
-	DiskSize        int64 // 数据目录占用磁盘空间大小
+	KeyNum          uint          // key 的总数量
+	DataFileNum     uint          // 磁盘上数据文件的总数量
+	ReclaimableSize int64         // 可以进行 merge 回收的数据量，以字节为单位
+	DiskSize        int64         // 数据目录占用磁盘空间大小
+	StartupTime     time.Duration // 打开数据库（加载索引）的耗时
+	CacheHits       uint64        // value 缓存命中次数
+	CacheMisses     uint64        // value 缓存未命中次数
 }
 
 // Open 打开 bitcask 存储引擎实例
 
 func Open(options Options) (*DB, error) {
+	startTime := time.Now()
 	// 校验用户配置
 	err := checkOptions(options)
 	if err != nil {
 		return nil, err
 	}
+	if options.FileSystem == nil {
+		options.FileSystem = fio.DefaultFileSystem
+	}
+	fs := options.FileSystem
 	var isInitial bool
 	// 对用户传递过来的目录进行校验
-	if _, err := os.Stat(options.DirPath); os.IsNotExist(err) {
+	if _, err := fs.Stat(options.DirPath); os.IsNotExist(err) {
 		isInitial = true
-		if err := os.MkdirAll(options.DirPath, os.ModePerm); err != nil {
+		if err := fs.MkdirAll(options.DirPath); err != nil {
 			return nil, err
 		}
 	}
 	// 判断当前文件是否被其他进程持有
-	fileLock := flock.New(filepath.Join(options.DirPath, fileLockName))
-	hold, err := fileLock.TryLock()
+	fileLock, hold, err := fs.TryLock(filepath.Join(options.DirPath, fileLockName))
 	if err != nil {
 		return nil, err
 	}
 	if !hold {
 		return nil, ErrDatabaseIsUsing
 	}
-	entries, err := os.ReadDir(options.DirPath)
+	entries, err := fs.ReadDir(options.DirPath)
 	if err != nil {
+		_ = fileLock.Unlock()
 		return nil, err
 	}
 	if len(entries) == 0 {
@@ -82,13 +114,34 @@ func Open(options Options) (*DB, error) {
 	}
 	// 初始化 db 结构体
 	db := &DB{
-		options:    options,
-		mu:         new(sync.RWMutex),
-		olderFiles: make(map[uint32]*data.DataFile),
-		index:      index.NewIndexer(options.IndexType, options.DirPath, options.SyncWrites),
-		isInitial:  isInitial,
-		fileLock:   fileLock,
-	}
+		options:         options,
+		mu:              new(sync.RWMutex),
+		snapshotLock:    new(sync.Mutex),
+		keyspaces:       make(map[string]*Keyspace),
+		keyspaceIds:     make(map[uint32]*Keyspace),
+		olderFiles:      make(map[uint32]*data.DataFile),
+		blobFiles:       make(map[uint32]*data.DataFile),
+		secondaries:     make(map[string]*secondaryIndex),
+		index:           index.NewIndexer(options.IndexType, options.DirPath, options.SyncWrites),
+		deletedVersions: index.NewBTree(),
+		isInitial:       isInitial,
+		fileLock:        fileLock,
+		fs:              fs,
+	}
+	// 缓存按照记录的位置查找，merge 的数据文件在下次启动时才会替换旧的文件，运行期间位置上的记录不会改变
+	if options.ValueCacheSize > 0 {
+		db.valueCache = cache.NewLRU(options.ValueCacheSize)
+	}
+	if options.MaxOpenFiles > 0 {
+		db.filePool = fio.NewFilePool(options.MaxOpenFiles)
+	}
+	// 启动失败时关闭已经打开的文件并释放文件锁，之后可以重新打开
+	var opened bool
+	defer func() {
+		if !opened {
+			db.abortOpen()
+		}
+	}()
 	// 加载 merge 数据目录
 	if err := db.loadMergeFiles(); err != nil {
 		return nil, err
@@ -97,15 +150,34 @@ func Open(options Options) (*DB, error) {
 	if err := db.loadDataFiles(); err != nil {
 		return nil, err
 	}
+	// 加载 blob 文件
+	if err := db.loadBlobFiles(); err != nil {
+		return nil, err
+	}
+	// 加载 keyspace 信息，之后加载索引时按照 keyspace 分别更新
+	if err := db.loadKeyspaces(); err != nil {
+		return nil, err
+	}
 	// B+ 树索引不需要从数据文件中加载索引
 	if options.IndexType != BPlusTree {
+		// 优先从索引快照中加载，只需要再加载快照之后写入的数据
+		// 快照只包含默认 keyspace 的索引，存在其他 keyspace 或者保留历史版本时从头加载
+		var snapshotPos *data.LogRecordPos
+		if len(db.keyspaces) == 0 && !db.historyEnabled() {
+			if snapshotPos, err = db.loadIndexFromSnapshot(); err != nil {
+				return nil, err
+			}
+		}
+
 		// 加载 Hint 文件中的索引
-		if err := db.loadIndexFromHintFile(); err != nil {
-			return nil, err
+		if snapshotPos == nil {
+			if err := db.loadIndexFromHintFile(); err != nil {
+				return nil, err
+			}
 		}
 
 		// 从数据文件中加载索引
-		if err := db.loadIndexFromDataFiles(); err != nil {
+		if err := db.loadIndexFromDataFiles(snapshotPos); err != nil {
 			return nil, err
 		}
 
@@ -116,21 +188,33 @@ func Open(options Options) (*DB, error) {
 		if err := db.loadSeqNo(); err != nil {
 			return nil, err
 		}
-		if db.activeFile != nil {
-			size, err := db.activeFile.IoManager.Size()
-			if err != nil {
-				return nil, err
-			}
-			db.activeFile.WriteOffset = size
+		// 从 B+ 树索引记录的位置开始重放数据文件
+		if err := db.loadIndexFromCheckpoint(); err != nil {
+			return nil, err
 		}
 	}
 
-	// 重置 IO 类型为标准文件 IO
-	if db.options.MMapAtStartup {
-		if err := db.resetIOType(); err != nil {
+	// 索引加载完成之后构建二级索引
+	if err := db.loadSecondaryIndexes(); err != nil {
+		return nil, err
+	}
+
+	// 重置 IO 类型
+	if err := db.resetIOType(); err != nil {
+		return nil, err
+	}
+
+	// 活跃文件开启写缓冲
+	if db.options.WriteBufferSize > 0 && db.activeFile != nil {
+		if err := db.activeFile.SetWriteBuffer(db.options.WriteBufferSize); err != nil {
 			return nil, err
 		}
 	}
+	if db.filePool != nil && db.activeFile != nil {
+		db.activeFile.SetFilePool(db.options.DirPath, db.filePool, db.olderFileIOType())
+	}
+	db.startupTime = time.Since(startTime)
+	opened = true
 
 	return db, nil
 
@@ -138,10 +222,30 @@ func Open(options Options) (*DB, error) {
 
 // Put 写入 key value 数据，key 不能为空
 func (db *DB) Put(key, value []byte) error {
+	return db.PutContext(context.Background(), key, value)
+}
+
+// PutContext 写入 key value 数据，等待 db.mu 时 ctx 取消则返回 ctx.Err()
+func (db *DB) PutContext(ctx context.Context, key, value []byte) error {
 	if len(key) == 0 {
 		return ErrKeyIsEmpty
 	}
+	if err := db.lockContext(ctx); err != nil {
+		return err
+	}
+	defer db.mu.Unlock()
+	return db.put(key, value)
+}
 
+// put 追加写入数据并更新内存索引
+// 在访问此方法前必须持有互斥锁
+func (db *DB) put(key, value []byte) error {
+	if db.historyEnabled() {
+		return db.putVersion(key, value)
+	}
+	if db.isBlobValue(value) {
+		return db.putBlob(key, value)
+	}
 	// 构造 LogRecord 结构体
 
 	logRecord := &data.LogRecord{
@@ -150,12 +254,12 @@ func (db *DB) Put(key, value []byte) error {
 		Type:  data.LogRecordNormal,
 	}
 	// 追加写入到当前活跃数据文件当中
-	pos, err := db.appendLogRecordWitchLock(logRecord)
+	pos, err := db.appendLogRecord(logRecord)
 	if err != nil {
 		return err
 	}
 	// 拿到内存信息之后，更新内存索引
-	if oldPos := db.index.Put(key, pos); oldPos != nil {
+	if oldPos := db.applyIndex([]*index.BatchEntry{{Key: key, Pos: pos}}, [][]byte{value})[0]; oldPos != nil {
 		db.reclaimableSize += int64(oldPos.Size)
 	}
 	return nil
@@ -163,22 +267,16 @@ func (db *DB) Put(key, value []byte) error {
 
 // Get 根据 key 读取数据
 func (db *DB) Get(key []byte) ([]byte, error) {
-	if len(key) == 0 {
-		return nil, ErrKeyIsEmpty
-	}
-	// 从内存数据结构中取出 key 对应的索引信息
-	logrecordPos := db.index.Get(key)
-	// 如果 key 不存在内存索引中，那么这个key就不存在
-	if logrecordPos == nil {
-		return nil, ErrKeyNotFound
-	}
-	// 根据文件 ID 找到对应的数据文件
-	return db.getValueByPosition(logrecordPos)
-
+	return db.GetContext(context.Background(), key)
 }
 
 // Delete 根据 key 删除数据
 func (db *DB) Delete(key []byte) error {
+	return db.DeleteContext(context.Background(), key)
+}
+
+// DeleteContext 根据 key 删除数据，等待 db.mu 时 ctx 取消则返回 ctx.Err()
+func (db *DB) DeleteContext(ctx context.Context, key []byte) error {
 	if len(key) == 0 {
 		return ErrKeyIsEmpty
 	}
@@ -188,12 +286,25 @@ func (db *DB) Delete(key []byte) error {
 		return nil
 	}
 
+	if err := db.lockContext(ctx); err != nil {
+		return err
+	}
+	defer db.mu.Unlock()
+	return db.delete(key)
+}
+
+// delete 追加写入删除标记并从内存索引中删除 key
+// 在访问此方法前必须持有互斥锁
+func (db *DB) delete(key []byte) error {
+	if db.historyEnabled() {
+		return db.deleteVersion(key, atomic.AddUint64(&db.versionSeq, 1))
+	}
 	logRecord := &data.LogRecord{
 		Key:  logRecordKeyWithSeqNo(key, nonTransactionSeqNo),
 		Type: data.LogRecordDeleted,
 	}
 
-	pos, err := db.appendLogRecordWitchLock(logRecord)
+	pos, err := db.appendLogRecord(logRecord)
 	if err != nil {
 		return err
 	}
@@ -201,13 +312,11 @@ func (db *DB) Delete(key []byte) error {
 
 	// 从内存索引中删除对应的 key
 
-	oldPos, ok := db.index.Delete(key)
-	if !ok {
+	oldPos := db.applyIndex([]*index.BatchEntry{{Key: key}}, nil)[0]
+	if oldPos == nil {
 		return ErrIndexUpdateFailed
 	}
-	if oldPos != nil {
-		db.reclaimableSize += int64(oldPos.Size)
-	}
+	db.reclaimableSize += int64(oldPos.Size)
 
 	return nil
 }
@@ -225,25 +334,25 @@ func (db *DB) Close() error {
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
+	// 保存索引快照
+	if db.options.IndexSnapshotOnClose {
+		if err := db.writeIndexSnapshot(db.newIndexSnapshot()); err != nil {
+			return err
+		}
+	}
+
 	// 关闭索引
 	if err := db.index.Close(); err != nil {
 		return err
 	}
 
 	// 保存当前事务序列号
-	seqNoFile, err := data.OpenSeqNoFile(db.options.DirPath)
-	if err != nil {
-		return err
-	}
-	record := &data.LogRecord{
-		Key:   []byte(seqNokey),
-		Value: []byte(strconv.FormatUint(db.seqNo, 10)),
-	}
-	encRecord, _ := data.EncodeLogRecord(record)
-	if err := seqNoFile.Write(encRecord); err != nil {
+	if err := db.saveSeqNo(); err != nil {
 		return err
 	}
-	if err := seqNoFile.Sync(); err != nil {
+
+	// 写入活跃文件对应的 hint 文件
+	if err := db.writeActiveFileHint(); err != nil {
 		return err
 	}
 
@@ -258,9 +367,56 @@ func (db *DB) Close() error {
 			return err
 		}
 	}
+
+	// 持久化并关闭 blob 文件
+	if db.activeBlobFile != nil {
+		if err := db.activeBlobFile.Sync(); err != nil {
+			return err
+		}
+	}
+	for _, file := range db.blobFiles {
+		if err := file.Close(); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+// abortOpen 启动失败时关闭已经打开的索引和数据文件，并释放文件锁
+func (db *DB) abortOpen() {
+	_ = db.index.Close()
+	if db.activeFile != nil {
+		_ = db.activeFile.Close()
+	}
+	for _, file := range db.olderFiles {
+		_ = file.Close()
+	}
+	for _, file := range db.blobFiles {
+		_ = file.Close()
+	}
+	_ = db.fileLock.Unlock()
+}
+
+// saveSeqNo 将当前事务序列号保存到文件中
+func (db *DB) saveSeqNo() error {
+	seqNoFile, err := data.OpenSeqNoFile(db.fs, db.options.DirPath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = seqNoFile.Close()
+	}()
+	record := &data.LogRecord{
+		Key:   []byte(seqNokey),
+		Value: []byte(strconv.FormatUint(db.seqNo, 10)),
+	}
+	encRecord, _ := data.EncodeLogRecord(record)
+	if err := seqNoFile.Write(encRecord); err != nil {
+		return err
+	}
+	return seqNoFile.Sync()
+}
+
 // 持久化数据文件
 func (db *DB) Sync() error {
 	if db.activeFile == nil {
@@ -268,7 +424,7 @@ func (db *DB) Sync() error {
 	}
 	db.mu.Lock()
 	defer db.mu.Unlock()
-	return db.activeFile.Sync()
+	return db.syncActiveFile()
 }
 
 // Stat 返回数据库的相关统计信息
@@ -279,16 +435,21 @@ func (db *DB) Stat() *Stat {
 	if db.activeFile != nil {
 		dataFiles += 1
 	}
-	dirSize, err := utils.DirSize(db.options.DirPath)
+	dirSize, err := db.fs.DirSize(db.options.DirPath)
 	if err != nil {
 		panic(fmt.Sprintf("failed to get dir size : %v", err))
 	}
-	return &Stat{
+	stat := &Stat{
 		KeyNum:          uint(db.index.Size()),
 		DataFileNum:     dataFiles,
 		ReclaimableSize: db.reclaimableSize,
 		DiskSize:        dirSize, // TODO 等待补全
+		StartupTime:     db.startupTime,
 	}
+	if db.valueCache != nil {
+		stat.CacheHits, stat.CacheMisses = db.valueCache.Stats()
+	}
+	return stat
 }
 
 // Backup 备份数据库，将数据文件拷贝到新的目录中
@@ -296,12 +457,20 @@ func (db *DB) BackUp(dir string) error {
 	db.mu.RLock()
 	defer db.mu.RUnlock()
 
-	return utils.CopyDir(db.options.DirPath, dir, []string{fileLockName})
+	// 写缓冲中的数据需要先写入文件
+	if db.activeFile != nil {
+		if err := db.activeFile.Sync(); err != nil {
+			return err
+		}
+	}
+
+	return db.fs.CopyDir(db.options.DirPath, dir, []string{fileLockName})
 }
 
 // ListKeys 获取数据库中所有的 key
 func (db *DB) ListKeys() [][]byte {
 	iterator := db.index.Iterator(false)
+	defer iterator.Close()
 	keys := make([][]byte, db.index.Size())
 	var idx int
 	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
@@ -317,7 +486,7 @@ func (db *DB) Fold(fn func(key, value []byte) bool) error {
 	defer db.mu.RUnlock()
 	iterator := db.index.Iterator(false)
 	// 使用完如果不关闭将会阻塞
-	defer db.index.Close()
+	defer iterator.Close()
 	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
 		value, err := db.getValueByPosition(iterator.Value())
 		if err != nil {
@@ -332,6 +501,48 @@ func (db *DB) Fold(fn func(key, value []byte) bool) error {
 
 // getValueByPosition 根据索引信息获取对应的value
 func (db *DB) getValueByPosition(logRecordPos *data.LogRecordPos) ([]byte, error) {
+	// 先从缓存中查找
+	cacheKey := cache.Key{Fid: logRecordPos.Fid, Offset: logRecordPos.Offset}
+	if db.valueCache != nil {
+		if value, ok := db.valueCache.Get(cacheKey); ok {
+			return value, nil
+		}
+	}
+
+	logRecord, err := db.readLogRecord(logRecordPos)
+	if err != nil {
+		return nil, err
+	}
+	if logRecord.Type == data.LogRecordDeleted || logRecord.Type == data.LogRecordVersionedDeleted {
+		return nil, ErrKeyNotFound
+	}
+	value := recordValue(logRecord)
+	// 操作数记录需要和之前的值合并
+	if logRecord.Type == data.LogRecordMerge {
+		if value, err = db.foldOperands(logRecord); err != nil {
+			return nil, err
+		}
+	}
+	// value 存储在 blob 文件中
+	if logRecord.Type == data.LogRecordBlob {
+		if value, err = db.readBlobValue(logRecord); err != nil {
+			return nil, err
+		}
+	}
+	// 分块写入的 value 需要读取所有的分块
+	if logRecord.Type == data.LogRecordStream {
+		if value, err = db.readStreamValue(logRecord); err != nil {
+			return nil, err
+		}
+	}
+	if db.valueCache != nil {
+		db.valueCache.Put(cacheKey, value)
+	}
+	return value, nil
+}
+
+// readLogRecord 根据索引信息读取数据文件中的记录
+func (db *DB) readLogRecord(logRecordPos *data.LogRecordPos) (*data.LogRecord, error) {
 	var dataFile *data.DataFile
 	// 如果是在当前活跃文件就在当前活跃文件去找
 	// 不在当前文件，就去旧文件去找
@@ -349,16 +560,55 @@ func (db *DB) getValueByPosition(logRecordPos *data.LogRecordPos) ([]byte, error
 	if err != nil {
 		return nil, err
 	}
-	if logRecord.Type == data.LogRecordDeleted {
-		return nil, ErrKeyNotFound
+	return logRecord, nil
+}
+
+// applyIndex 更新索引，持久化的索引会在同一个事务中记录已经应用到的数据位置
+// values 为每个 entry 写入的 value，用于更新二级索引，没有配置二级索引或者只有删除时可以为空
+// 调用时需要持有 db.mu，保证索引按照数据写入的顺序更新
+func (db *DB) applyIndex(entries []*index.BatchEntry, values [][]byte) []*data.LogRecordPos {
+	var oldPositions []*data.LogRecordPos
+	if cpIndex, ok := db.index.(index.CheckpointIndex); ok && db.activeFile != nil {
+		checkpoint := &data.LogRecordPos{Fid: db.activeFile.FileId, Offset: db.activeFile.WriteOffset}
+		oldPositions = cpIndex.ApplyBatchWithCheckpoint(entries, checkpoint)
+	} else {
+		oldPositions = db.index.ApplyBatch(entries)
 	}
-	return logRecord.Value, nil
+	db.applySecondary(entries, values)
+	return oldPositions
 }
 
-func (db *DB) appendLogRecordWitchLock(logRecord *data.LogRecord) (*data.LogRecordPos, error) {
-	db.mu.Lock()
-	defer db.mu.Unlock()
-	return db.appendLogRecord(logRecord)
+// dataPosValid 位置是否在现有的数据文件范围之内
+func (db *DB) dataPosValid(pos *data.LogRecordPos) bool {
+	var dataFile *data.DataFile
+	if db.activeFile != nil && db.activeFile.FileId == pos.Fid {
+		dataFile = db.activeFile
+	} else {
+		dataFile = db.olderFiles[pos.Fid]
+	}
+	if dataFile == nil {
+		return false
+	}
+	size, err := dataFile.IoManager.Size()
+	return err == nil && pos.Offset <= size
+}
+
+// logRecordPosSize 记录在索引中的位置大小，只用于统计无效数据，size 为记录本身的大小
+// 分块清单包含所有分块的大小，操作数记录包含之前整个操作数链的大小，覆盖或者删除之后这些记录一起成为无效数据
+// 超过 uint32 的范围时取最大值
+func logRecordPosSize(logRecord *data.LogRecord, size int64) uint32 {
+	switch logRecord.Type {
+	case data.LogRecordStream:
+		size += streamChunksSize(logRecord.Value)
+	case data.LogRecordMerge:
+		if _, prev, _ := data.DecodeMergeOperand(logRecord.Value); prev != nil {
+			size += int64(prev.Size)
+		}
+	}
+	if size > math.MaxUint32 {
+		return math.MaxUint32
+	}
+	return uint32(size)
 }
 
 // appendLogRecord 追加写数据到活跃文件中
@@ -375,16 +625,9 @@ func (db *DB) appendLogRecord(logRecord *data.LogRecord) (*data.LogRecordPos, er
 	// 写入数据编码
 	encRecord, size := data.EncodeLogRecord(logRecord)
 	// 如果写入的数据已经达到了活跃文件的阀值，则关闭活跃文件，并打开新的文件
-	if db.activeFile.WriteOffset+size > db.options.DataFileSize {
-		// 先持久化数据文件，保证已有文件能持久化到磁盘当中
-		if err := db.activeFile.Sync(); err != nil {
-			return nil, err
-		}
-		// 持久化完成之后把当前活跃文件转换为旧的活跃文件
-		db.olderFiles[db.activeFile.FileId] = db.activeFile
-
-		// 打开新的文件
-		if err := db.setActiveDataFile(); err != nil {
+	// 活跃文件之前写入失败过，不能在不完整的记录之后继续写入，同样切换新的文件
+	if db.activeFile.WriteOffset+size > db.options.DataFileSize || db.activeFileFault {
+		if err := db.rotateActiveFile(); err != nil {
 			return nil, err
 		}
 	}
@@ -392,8 +635,12 @@ func (db *DB) appendLogRecord(logRecord *data.LogRecord) (*data.LogRecordPos, er
 
 	writeOffset := db.activeFile.WriteOffset
 	if err := db.activeFile.Write(encRecord); err != nil {
+		db.activeFileFault = true
+		db.resetActiveHints(false)
 		return nil, err
 	}
+	db.bytesWrite += uint(size)
+	db.bytesRangeSync += uint(size)
 
 	// 检查是否需要对数据进行持久化
 
@@ -403,19 +650,85 @@ func (db *DB) appendLogRecord(logRecord *data.LogRecord) (*data.LogRecordPos, er
 	}
 
 	if needSync {
-		if err := db.activeFile.Sync(); err != nil {
+		if err := db.syncActiveFile(); err != nil {
 			return nil, err
 		}
 		// 清空累计值
 		if db.bytesWrite > 0 {
 			db.bytesWrite = 0
 		}
+	} else if db.options.BytesPerRangeSync > 0 && db.bytesRangeSync >= db.options.BytesPerRangeSync {
+		// 提交还没有回写的数据，之后持久化时需要等待的数据更少
+		n := int64(db.bytesRangeSync)
+		if err := db.activeFile.SyncRange(db.activeFile.WriteOffset-n, n); err != nil {
+			return nil, err
+		}
+		db.bytesRangeSync = 0
 	}
 	// 构造内存存储信息
-	pos := &data.LogRecordPos{Fid: db.activeFile.FileId, Offset: writeOffset, Size: uint32(size)}
+	pos := &data.LogRecordPos{Fid: db.activeFile.FileId, Offset: writeOffset, Size: logRecordPosSize(logRecord, size)}
+	db.appendHintRecord(logRecord, pos)
 	return pos, nil
 }
 
+// syncActiveFile 持久化活跃文件，失败时标记活跃文件需要切换
+// 在访问此方法前必须持有互斥锁
+func (db *DB) syncActiveFile() error {
+	if err := db.syncActiveBlobFile(); err != nil {
+		return err
+	}
+	if err := db.activeFile.Sync(); err != nil {
+		db.activeFileFault = true
+		return err
+	}
+	db.bytesRangeSync = 0
+	return nil
+}
+
+// rotateActiveFile 将当前活跃文件转换为旧的数据文件，并打开新的活跃文件
+// 在访问此方法前必须持有互斥锁
+func (db *DB) rotateActiveFile() error {
+	// 先持久化数据文件，保证已有文件能持久化到磁盘当中
+	if err := db.syncActiveBlobFile(); err != nil {
+		return err
+	}
+	if err := db.activeFile.Sync(); err != nil {
+		return err
+	}
+	// 写入对应的 hint 文件，下次启动时可以直接从 hint 文件加载索引
+	if err := db.writeActiveFileHint(); err != nil {
+		return err
+	}
+	// 截断预分配的空间，可读写的 MMap 映射的内存继续用于读取
+	ioManager := db.activeFile.IoManager
+	pio, pooled := ioManager.(*fio.PooledIO)
+	if pooled {
+		ioManager = pio.Unwrap()
+	}
+	// 写缓冲在持久化时已经写入文件
+	if bio, ok := ioManager.(*fio.BufferedIO); ok {
+		ioManager = bio.Unwrap()
+	}
+	switch iom := ioManager.(type) {
+	case *fio.MMapRW:
+		if err := iom.Shrink(); err != nil {
+			return err
+		}
+	case *fio.PreallocIO:
+		if err := iom.Shrink(); err != nil {
+			return err
+		}
+	}
+	// 持久化完成之后把当前活跃文件转换为旧的活跃文件，之后可以被句柄池关闭
+	db.olderFiles[db.activeFile.FileId] = db.activeFile
+	if pooled {
+		pio.Unpin()
+	}
+
+	// 打开新的文件
+	return db.setActiveDataFile()
+}
+
 // setActiveDataFile 设备当前活跃文件
 // 在访问此方法钱必须持有互斥锁
 func (db *DB) setActiveDataFile() error {
@@ -425,26 +738,51 @@ func (db *DB) setActiveDataFile() error {
 		initialFileId = db.activeFile.FileId + 1
 	}
 	// 打开新的数据文件
-	dataFile, err := data.OpenDataFile(db.options.DirPath, initialFileId, fio.StandardFIO)
+	ioType := fio.StandardFIO
+	if db.options.DirectIO {
+		ioType = fio.DirectFIO
+	}
+	dataFile, err := data.OpenDataFile(db.fs, db.options.DirPath, initialFileId, ioType)
 	if err != nil {
 		return err
 	}
+	if db.options.MMapReadWrite {
+		if err := dataFile.SetMMapRW(db.options.DirPath, db.options.DataFileSize); err != nil {
+			return err
+		}
+	}
+	if db.options.Preallocate {
+		if err := dataFile.SetPreallocate(db.options.DirPath, db.options.DataFileSize); err != nil {
+			return err
+		}
+	}
+	if db.options.WriteBufferSize > 0 {
+		if err := dataFile.SetWriteBuffer(db.options.WriteBufferSize); err != nil {
+			return err
+		}
+	}
+	if db.filePool != nil {
+		dataFile.SetFilePool(db.options.DirPath, db.filePool, db.olderFileIOType())
+	}
 	db.activeFile = dataFile
+	db.activeFileFault = false
+	db.bytesRangeSync = 0
+	db.resetActiveHints(true)
 	return nil
 }
 
 // loadDataFiles 从磁盘中加载数据文件
 func (db *DB) loadDataFiles() error {
-	dirEntries, err := os.ReadDir(db.options.DirPath)
+	fileNames, err := db.fs.ReadDir(db.options.DirPath)
 	if err != nil {
 		return err
 	}
 	var fileIds []int
 	// 从目录中遍历以 data 结尾的文件
-	for _, entry := range dirEntries {
-		if strings.HasSuffix(entry.Name(), data.DataFileNameSuffix) {
+	for _, fileName := range fileNames {
+		if strings.HasSuffix(fileName, data.DataFileNameSuffix) {
 			// 对文件进行分割，000001.data
-			splitNames := strings.Split(entry.Name(), ".")
+			splitNames := strings.Split(fileName, ".")
 			fileId, err := strconv.Atoi(splitNames[0])
 			if err != nil {
 				return ErrDataDirectoryCorrupted
@@ -458,10 +796,15 @@ func (db *DB) loadDataFiles() error {
 
 	for i, fileId := range fileIds {
 		ioType := fio.StandardFIO
-		if db.options.MMapAtStartup {
+		if db.options.MMapAtStartup || db.options.MMapReadWrite {
 			ioType = fio.MemoryMap
 		}
-		dataFile, err := data.OpenDataFile(db.options.DirPath, uint32(fileId), ioType)
+		// 使用句柄池时，旧数据文件在读取时才会打开
+		if db.filePool != nil && i < len(fileIds)-1 {
+			db.olderFiles[uint32(fileId)] = data.OpenPooledDataFile(db.fs, db.filePool, db.options.DirPath, uint32(fileId), ioType)
+			continue
+		}
+		dataFile, err := data.OpenDataFile(db.fs, db.options.DirPath, uint32(fileId), ioType)
 		if err != nil {
 			return err
 		}
@@ -477,9 +820,67 @@ func (db *DB) loadDataFiles() error {
 	return nil
 }
 
+// indexRecord 从数据文件中解析出的用于构建索引的记录，不包含 value
+type indexRecord struct {
+	key      []byte
+	seqNo    uint64
+	typ      data.LogRecordType
+	pos      *data.LogRecordPos
+	keyspace uint32
+}
+
+// dataFileRecords 单个数据文件的解析结果
+type dataFileRecords struct {
+	records []*indexRecord
+	offset  int64 // 读取结束的位置，即文件中有效数据的末尾
+	err     error
+}
+
+// readIndexRecords 从 offset 开始读取数据文件中的所有记录，只保留构建索引需要的信息
+func readIndexRecords(dataFile *data.DataFile, offset int64) *dataFileRecords {
+	result := &dataFileRecords{}
+	for {
+		logRecord, size, err := dataFile.ReadLogRecord(offset)
+		if err != nil {
+			if err == io.EOF { // 文件读完了
+				break
+			}
+			result.err = err
+			result.offset = offset
+			return result
+		}
+		// 解析 key，拿到事务序列号
+		realKey, seqNo := parseLogRecordKey(logRecord.Key)
+		pos := &data.LogRecordPos{Fid: dataFile.FileId, Offset: offset, Size: logRecordPosSize(logRecord, size)}
+		result.records = append(result.records, &indexRecord{
+			key:      realKey,
+			seqNo:    seqNo,
+			typ:      logRecord.Type,
+			pos:      pos,
+			keyspace: logRecord.Keyspace,
+		})
+		//  递增 offset ，下一次从新的位置开始读取
+		offset += size
+	}
+	result.offset = offset
+	return result
+}
+
+// filterIndexRecords 过滤出 offset 及之后位置的记录
+func filterIndexRecords(records []*indexRecord, offset int64) []*indexRecord {
+	var filtered []*indexRecord
+	for _, record := range records {
+		if record.pos.Offset >= offset {
+			filtered = append(filtered, record)
+		}
+	}
+	return filtered
+}
+
 // loadIndexFromFiles 从数据文件中加载索引
-// 遍历文件中的所有记录，并更新到内存索引中
-func (db *DB) loadIndexFromDataFiles() error {
+// 多个数据文件并发解析，解析结果按照文件 id 从小到大依次更新到内存索引中
+// from 不为空时只加载该位置之后的数据，之前的数据已经从其他地方（例如索引快照）加载过了
+func (db *DB) loadIndexFromDataFiles(from *data.LogRecordPos) error {
 	// db.fileIds ===0 数据库为空
 	if len(db.fileIds) == 0 {
 		return nil
@@ -487,102 +888,230 @@ func (db *DB) loadIndexFromDataFiles() error {
 	// 查看是否发生过 merge
 	hasMerge, nonMergeFileId := false, uint32(0)
 	mergeFinFileName := filepath.Join(db.options.DirPath, data.MergeFinishedFileName)
-	if _, err := os.Stat(mergeFinFileName); err == nil {
+	if _, err := db.fs.Stat(mergeFinFileName); err == nil {
 		fid, err := db.getNonMergeFileId(db.options.DirPath)
 		if err != nil {
 			return err
 		}
 		hasMerge = true
 		nonMergeFileId = fid
+		// 参与 merge 的记录中的序列号已经不在索引记录中了
+		mergeSeqNo, mergeVersionSeq, err := db.getMergeSeqNo(db.options.DirPath)
+		if err != nil {
+			return err
+		}
+		if mergeSeqNo > db.seqNo {
+			db.seqNo = mergeSeqNo
+		}
+		if mergeVersionSeq > db.versionSeq {
+			db.versionSeq = mergeVersionSeq
+		}
 
 	}
 
-	updateIndex := func(key []byte, typ data.LogRecordType, logRecordPos *data.LogRecordPos) {
-		// 检查数据类型，如果存在就插入，如果被删除就从内存中删除
-		var oldPos *data.LogRecordPos
+	// 如果比最近未参与 merge 的文件 ID 还小，就说明已经从 Hint 文件中加载过了
+	var dataFiles []*data.DataFile
+	var startOffsets []int64
+	for _, fid := range db.fileIds {
+		var fileId = uint32(fid)
+		if hasMerge && fileId < nonMergeFileId {
+			continue
+		}
+		var startOffset int64 = 0
+		if from != nil {
+			if fileId < from.Fid {
+				continue
+			}
+			if fileId == from.Fid {
+				startOffset = from.Offset
+			}
+		}
+		startOffsets = append(startOffsets, startOffset)
+		if fileId == db.activeFile.FileId {
+			dataFiles = append(dataFiles, db.activeFile)
+		} else {
+			dataFiles = append(dataFiles, db.olderFiles[fileId])
+		}
+	}
 
-		if typ == data.LogRecordDeleted {
-			oldPos, _ = db.index.Delete(key)
+	// 索引分批更新
+	entries := make([]*index.BatchEntry, 0, indexBatchSize)
+	// 操作数记录的位置大小已经包含之前的记录，替换时之前的记录不计入无效数据
+	operands := make([]bool, 0, indexBatchSize)
+	flushIndex := func(checkpoint *data.LogRecordPos) {
+		var oldPositions []*data.LogRecordPos
+		if cpIndex, ok := db.index.(index.CheckpointIndex); ok && checkpoint != nil {
+			oldPositions = cpIndex.ApplyBatchWithCheckpoint(entries, checkpoint)
+		} else {
+			oldPositions = db.index.ApplyBatch(entries)
+		}
+		for i, oldPos := range oldPositions {
+			if oldPos != nil && !operands[i] {
+				db.reclaimableSize += int64(oldPos.Size)
+			}
+		}
+		entries = entries[:0]
+		operands = operands[:0]
+	}
+	var lastVersionPos *data.LogRecordPos
+	updateIndex := func(keyspace uint32, key []byte, typ data.LogRecordType, logRecordPos *data.LogRecordPos) {
+		// 其他 keyspace 的记录更新到各自的索引中
+		if keyspace != 0 {
+			db.loadKeyspaceRecord(keyspace, key, typ, logRecordPos)
+			return
+		}
+		// 历史版本的副本只能通过版本链访问，分块只能通过分块清单访问
+		if typ == data.LogRecordHistory || typ == data.LogRecordChunk {
+			return
+		}
+		// 范围删除需要在之前的数据都更新到索引之后再查找范围内的 key
+		if typ == data.LogRecordRangeDeleted {
+			flushIndex(nil)
+			entries = append(entries, db.rangeEntries(data.DecodeKeyRange(key))...)
+			operands = append(operands, make([]bool, len(entries))...)
+			db.reclaimableSize += int64(logRecordPos.Size)
+			return
+		}
+		// 检查数据类型，如果存在就插入，如果被删除就从内存中删除
+		if typ == data.LogRecordDeleted || typ == data.LogRecordVersionedDeleted {
+			entries = append(entries, &index.BatchEntry{Key: key})
 			db.reclaimableSize += int64(logRecordPos.Size)
 		} else {
-			oldPos = db.index.Put(key, logRecordPos)
+			entries = append(entries, &index.BatchEntry{Key: key, Pos: logRecordPos})
 		}
-
-		if oldPos != nil {
-			db.reclaimableSize += int64(oldPos.Size)
+		operands = append(operands, typ == data.LogRecordMerge)
+		// 删除之后保留的历史版本
+		if db.historyEnabled() {
+			if typ == data.LogRecordVersionedDeleted {
+				db.deletedVersions.Put(key, logRecordPos)
+			} else if db.deletedVersions.Size() > 0 {
+				db.deletedVersions.Delete(key)
+			}
+		}
+		// 版本号按照写入的顺序递增，最后写入的版本的版本号最大
+		if isVersionedRecord(typ) {
+			lastVersionPos = logRecordPos
+		}
+		if len(entries) >= indexBatchSize {
+			flushIndex(nil)
 		}
 	}
 
+	// 并发解析数据文件，同时最多只有 workers 个文件处于已解析但未应用的状态，避免占用过多内存
+	workers := db.options.IndexLoadWorkers
+	if workers < 1 {
+		workers = 1
+	}
+	results := make([]chan *dataFileRecords, len(dataFiles))
+	for i := range results {
+		results[i] = make(chan *dataFileRecords, 1)
+	}
+	tokens := make(chan struct{}, workers)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for i, dataFile := range dataFiles {
+			select {
+			case tokens <- struct{}{}:
+			case <-done:
+				return
+			}
+			go func(i int, dataFile *data.DataFile, startOffset int64) {
+				// 优先使用数据文件对应的 hint 文件
+				if result := db.readIndexRecordsFromHint(dataFile); result != nil {
+					if startOffset > 0 {
+						result.records = filterIndexRecords(result.records, startOffset)
+					}
+					results[i] <- result
+					return
+				}
+				results[i] <- readIndexRecords(dataFile, startOffset)
+			}(i, dataFile, startOffsets[i])
+		}
+	}()
+
 	// 暂存事务数据
-	var currentSeqNo uint64 = nonTransactionSeqNo
+	var currentSeqNo = db.seqNo
 	transactionRecords := make(map[uint64][]*data.TransactionRecord)
 
-	// 遍历所有的文件id，处理文件中的记录
-	for i, fid := range db.fileIds {
-		var fileId = uint32(fid)
-		// 如果比最近未参与 merge 的文件 ID 还小，就说明已经从 Hint 文件中加载过了
-		if hasMerge && fileId < nonMergeFileId {
-			continue
-		}
-
-		var dataFile *data.DataFile
-		if fileId == db.activeFile.FileId {
-			dataFile = db.activeFile
-		} else {
-			dataFile = db.olderFiles[fileId]
-		}
-		var offset int64 = 0
-		for {
-			logRecord, size, err := dataFile.ReadLogRecord(offset)
+	// 按文件 id 顺序处理文件中的记录
+	for i, dataFile := range dataFiles {
+		result := <-results[i]
+		<-tokens
+		isActiveFile := dataFile == db.activeFile
+		if result.err != nil {
+			// 预分配的活跃文件异常退出时，最后一条记录可能没有写完整，之后从这条记录的位置继续写入
+			if !isActiveFile || result.err != data.ErrInvalidCRC {
+				return result.err
+			}
+			torn, err := dataFile.IsTornRecord(result.offset)
 			if err != nil {
-				if err == io.EOF { // 文件读完了
-					break
-				}
 				return err
 			}
-			// 把读出来的数据保存到内存索引当中
-			pos := &data.LogRecordPos{Fid: fileId, Offset: offset, Size: uint32(size)}
-
-			// 解析 key，拿到事务序列号
-			realKey, seqNo := parseLogRecordKey(logRecord.Key)
-			if seqNo == nonTransactionSeqNo {
+			if !torn {
+				return result.err
+			}
+		}
+		if isActiveFile {
+			// 只加载了部分数据时无法得到完整的索引信息，这个文件不再写入 hint 文件
+			db.resetActiveHints(startOffsets[i] == 0)
+		}
+		for _, record := range result.records {
+			// 重建活跃文件的索引信息，后续写入 hint 文件时使用
+			if isActiveFile && db.activeHintValid {
+				db.activeHints = append(db.activeHints, data.EncodeHintRecord(record.keyspace,
+					logRecordKeyWithSeqNo(record.key, record.seqNo), record.typ, record.pos)...)
+			}
+			// 版本号保存在保留历史版本的记录的 value 中，key 中的序列号只用于区分事务
+			if record.seqNo == nonTransactionSeqNo {
 				// 非事务操作，直接更新内存索引
-				updateIndex(realKey, logRecord.Type, pos)
+				updateIndex(record.keyspace, record.key, record.typ, record.pos)
 			} else {
 				// 事务完成，对应的 seq no的数据可以更新到内存当中
-
-				if logRecord.Type == data.LogRecordTxnFindShed {
-					for _, txnRecord := range transactionRecords[seqNo] {
-						updateIndex(txnRecord.Record.Key, txnRecord.Record.Type, txnRecord.Pos)
+				if record.typ == data.LogRecordTxnFindShed {
+					for _, txnRecord := range transactionRecords[record.seqNo] {
+						updateIndex(txnRecord.Record.Keyspace, txnRecord.Record.Key, txnRecord.Record.Type, txnRecord.Pos)
 					}
-					delete(transactionRecords, seqNo)
+					delete(transactionRecords, record.seqNo)
 				} else {
 					// 暂存
-					logRecord.Key = realKey
-					transactionRecords[seqNo] = append(transactionRecords[seqNo], &data.TransactionRecord{
-						Record: logRecord,
-						Pos:    pos,
+					transactionRecords[record.seqNo] = append(transactionRecords[record.seqNo], &data.TransactionRecord{
+						Record: &data.LogRecord{Key: record.key, Type: record.typ, Keyspace: record.keyspace},
+						Pos:    record.pos,
 					})
 				}
-
 			}
 
 			// 更新事务序列号
-			if seqNo > currentSeqNo {
-				currentSeqNo = seqNo
+			if record.seqNo > currentSeqNo {
+				currentSeqNo = record.seqNo
 			}
-
-			//  递增 offset ，下一次从新的位置开始读取
-			offset += size
 		}
 
 		// 如果是当前活跃文件，更新这个文件的WriteOffset
-		if i == len(db.fileIds)-1 {
-			dataFile.WriteOffset = offset
+		if isActiveFile {
+			dataFile.WriteOffset = result.offset
+		}
+
+		// 没有未完成的事务时，这个文件之前的数据都已经应用到索引中了
+		var checkpoint *data.LogRecordPos
+		if len(transactionRecords) == 0 {
+			checkpoint = &data.LogRecordPos{Fid: dataFile.FileId, Offset: result.offset}
 		}
+		flushIndex(checkpoint)
 	}
 	// 更新事务序列号
 	db.seqNo = currentSeqNo
+	// 更新版本号
+	if lastVersionPos != nil {
+		logRecord, err := db.readLogRecord(lastVersionPos)
+		if err != nil {
+			return err
+		}
+		if seq := data.DecodeVersionedValue(logRecord.Value).Seq; seq > db.versionSeq {
+			db.versionSeq = seq
+		}
+	}
 	return nil
 }
 
@@ -596,19 +1125,72 @@ func checkOptions(options Options) error {
 		return errors.New("database data file size must be greater than 0")
 	}
 
+	if options.DataFileSize > 1<<versionOffsetBits {
+		return errors.New("database data file size must not be greater than 1TB")
+	}
+
 	if options.DataFileMergeRatio < 0 || options.DataFileMergeRatio > 1 {
 		return errors.New("database data file merge rotio must be between 0 and 1")
 	}
 
+	if options.ValueCacheSize < 0 {
+		return errors.New("database value cache size must not be negative")
+	}
+
+	if options.WriteBufferSize < 0 {
+		return errors.New("database write buffer size must not be negative")
+	}
+
+	if _, ok := options.FileSystem.(*fio.MemFileSystem); ok && options.IndexType == BPlusTree {
+		return errors.New("database bptree index can not be used with memory file system")
+	}
+
+	if options.MMapReadWrite && options.DirectIO {
+		return errors.New("database direct io can not be used with read-write mmap")
+	}
+
+	if options.MMapReadWrite && options.WriteBufferSize > 0 {
+		return errors.New("database write buffer can not be used with read-write mmap")
+	}
+
+	if options.Preallocate && (options.MMapReadWrite || options.DirectIO) {
+		return errors.New("database preallocate can not be used with read-write mmap or direct io")
+	}
+
+	if options.IndexType == BPlusTree && (options.HistoryVersions > 0 || options.HistoryRetention > 0) {
+		return errors.New("database history versions can not be used with bptree index")
+	}
+
+	if options.MaxOpenFiles < 0 {
+		return errors.New("database max open files must not be negative")
+	}
+
+	if options.IndexLoadWorkers < 0 {
+		return errors.New("database index load workers must not be negative")
+	}
+
+	if options.BlobThreshold < 0 {
+		return errors.New("database blob threshold must not be negative")
+	}
+
+	// 没有开启 blob 时不使用 blob 相关的配置，零值的配置同样可以打开数据库
+	if options.BlobThreshold > 0 && options.BlobFileSize <= 0 {
+		return errors.New("database blob file size must be greater than 0")
+	}
+
+	if options.BlobThreshold > 0 && (options.BlobGCRatio <= 0 || options.BlobGCRatio > 1) {
+		return errors.New("database blob gc ratio must be greater than 0 and not greater than 1")
+	}
+
 	return nil
 }
 
 func (db *DB) loadSeqNo() error {
 	fileName := filepath.Join(db.options.DirPath, data.SeqNoFileName)
-	if _, err := os.Stat(fileName); os.IsNotExist(err) {
+	if _, err := db.fs.Stat(fileName); os.IsNotExist(err) {
 		return nil
 	}
-	seqNoFile, err := data.OpenSeqNoFile(db.options.DirPath)
+	seqNoFile, err := data.OpenSeqNoFile(db.fs, db.options.DirPath)
 	if err != nil {
 		return err
 	}
@@ -624,24 +1206,94 @@ func (db *DB) loadSeqNo() error {
 	db.seqNo = seqNo
 	db.seqNoFileExists = true
 	// 加载完后删除文件防止 seqNo 一直追加
-	return os.Remove(fileName)
+	return db.fs.Remove(fileName)
+}
+
+// loadIndexFromCheckpoint 从 B+ 树索引记录的位置开始重放数据文件，保证索引和数据文件一致
+// 没有记录位置、记录的位置超出了数据文件的范围，或者启动时加载了 merge 后的数据文件，需要重建整个索引
+func (db *DB) loadIndexFromCheckpoint() error {
+	cpIndex, ok := db.index.(index.CheckpointIndex)
+	if !ok {
+		return nil
+	}
+	checkpoint := cpIndex.Checkpoint()
+	if checkpoint != nil && (db.mergeLoaded || !db.dataPosValid(checkpoint)) {
+		if err := db.index.Close(); err != nil {
+			return err
+		}
+		if err := db.fs.Remove(filepath.Join(db.options.DirPath, index.BPTreeIndexFileName)); err != nil {
+			return err
+		}
+		db.index = index.NewIndexer(db.options.IndexType, db.options.DirPath, db.options.SyncWrites)
+		checkpoint = nil
+	}
+
+	if checkpoint == nil {
+		if err := db.loadIndexFromHintFile(); err != nil {
+			return err
+		}
+	}
+	if err := db.loadIndexFromDataFiles(checkpoint); err != nil {
+		return err
+	}
+	// 从头重放了所有数据，事务序列号是完整的
+	if checkpoint == nil && len(db.fileIds) > 0 {
+		db.seqNoFileExists = true
+	}
+	return nil
+}
+
+// olderFileIOType 启动之后旧数据文件使用的 IO 类型
+func (db *DB) olderFileIOType() fio.FileIOType {
+	if db.options.MMapReadWrite {
+		return fio.MemoryMap
+	}
+	if db.options.DirectIO {
+		return fio.DirectFIO
+	}
+	return fio.StandardFIO
 }
 
-// resetIOType 将数据文件的 IO 类重置为标准文件 IO
+// resetIOType 重置数据文件启动之后使用的 IO 类型
+// 使用可读写的 MMap 时，活跃文件切换为可读写的 MMap，旧的数据文件继续使用 MMap 读取
+// 否则全部重置为标准文件 IO 或者 Direct IO
 func (db *DB) resetIOType() error {
 	if db.activeFile == nil {
 		return nil
 	}
 
-	if err := db.activeFile.SetIOManager(db.options.DirPath, fio.StandardFIO); err != nil {
-		return err
+	if db.options.MMapReadWrite {
+		return db.activeFile.SetMMapRW(db.options.DirPath, db.options.DataFileSize)
 	}
 
-	for _, dataFile := range db.olderFiles {
-		if err := dataFile.SetIOManager(db.options.DirPath, fio.StandardFIO); err != nil {
+	// 预分配的活跃文件异常退出后末尾会有空白区域，截断之后才能追加写入
+	size, err := db.activeFile.IoManager.Size()
+	if err != nil {
+		return err
+	}
+	if size > db.activeFile.WriteOffset {
+		fileName := data.GetDataFileName(db.options.DirPath, db.activeFile.FileId)
+		if err := db.fs.Truncate(fileName, db.activeFile.WriteOffset); err != nil {
 			return err
 		}
 	}
-	return nil
 
+	ioType := db.olderFileIOType()
+	resetIO := db.options.MMapAtStartup || ioType != fio.StandardFIO
+	if resetIO {
+		for _, dataFile := range db.olderFiles {
+			if err := dataFile.SetIOManager(db.options.DirPath, ioType); err != nil {
+				return err
+			}
+		}
+	}
+
+	// 活跃文件重新预分配空间，从 WriteOffset 的位置继续追加写入
+	if db.options.Preallocate {
+		return db.activeFile.SetPreallocate(db.options.DirPath, db.options.DataFileSize)
+	}
+	if resetIO {
+		return db.activeFile.SetIOManager(db.options.DirPath, ioType)
+	}
+	return nil
 }
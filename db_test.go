@@ -1,6 +1,8 @@
 package bitcask_db
 
 import (
+	"bitcask-db/data"
+	"bitcask-db/fio"
 	"bitcask-db/utils"
 	"github.com/stretchr/testify/assert"
 	"os"
@@ -15,7 +17,8 @@ func destroyDB(db *DB) {
 			_ = db.Close()
 
 		}
-		err := os.RemoveAll(db.options.DirPath)
+		// 使用数据库的文件系统删除，内存文件系统上的目录不会删除本机上的同名目录
+		err := db.fs.RemoveAll(db.options.DirPath)
 		if err != nil {
 			panic(err)
 		}
@@ -392,3 +395,435 @@ func TestDB_BackUp(t *testing.T) {
 	assert.Nil(t, err)
 	assert.NotNil(t, db2)
 }
+
+func TestDB_OpenParallelLoadIndex(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-db-parallel-load")
+	opts.DirPath = dir
+	opts.DataFileSize = 1024 * 1024
+	opts.IndexLoadWorkers = 4
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 20000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 5000; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	// 事务数据跨越多个数据文件
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	for i := 20000; i < 30000; i++ {
+		err := wb.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+	err = wb.Commit()
+	assert.Nil(t, err)
+	assert.True(t, len(db.olderFiles) > 2)
+
+	val, err := db.Get(utils.GetTestKey(25000))
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+
+	// 并发加载和顺序加载的结果一致
+	for _, workers := range []int{1, 4} {
+		opts.IndexLoadWorkers = workers
+		db2, err := Open(opts)
+		assert.Nil(t, err)
+		assert.Equal(t, 25000, len(db2.ListKeys()))
+		_, err = db2.Get(utils.GetTestKey(100))
+		assert.Equal(t, ErrKeyNotFound, err)
+		val2, err := db2.Get(utils.GetTestKey(25000))
+		assert.Nil(t, err)
+		assert.Equal(t, val, val2)
+		assert.True(t, db2.Stat().StartupTime > 0)
+		err = db2.Close()
+		assert.Nil(t, err)
+	}
+}
+
+func TestDB_ValueCache(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-db-value-cache")
+	opts.DirPath = dir
+	opts.ValueCacheSize = 1024 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	err = db.Put(utils.GetTestKey(1), []byte("value-1"))
+	assert.Nil(t, err)
+
+	// 第一次读取未命中，之后命中缓存
+	for i := 0; i < 3; i++ {
+		val, err := db.Get(utils.GetTestKey(1))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("value-1"), val)
+	}
+	stat := db.Stat()
+	assert.Equal(t, uint64(2), stat.CacheHits)
+	assert.Equal(t, uint64(1), stat.CacheMisses)
+
+	// 更新之后位置改变，读取到新的值
+	err = db.Put(utils.GetTestKey(1), []byte("value-2"))
+	assert.Nil(t, err)
+	val, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value-2"), val)
+
+	// 删除之后读取不到
+	err = db.Delete(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	_, err = db.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+}
+
+func TestDB_WriteBuffer(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-db-write-buffer")
+	opts.DirPath = dir
+	opts.DataFileSize = 1024 * 1024
+	opts.WriteBufferSize = 64 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// 缓冲区中的数据也可以读取
+	err = db.Put(utils.GetTestKey(1), []byte("buffered value"))
+	assert.Nil(t, err)
+	fileSize, err := utils.DirSize(dir)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), fileSize)
+	val, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("buffered value"), val)
+
+	for i := 0; i < 20000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+	assert.True(t, len(db.olderFiles) > 0)
+	val, err = db.Get(utils.GetTestKey(19999))
+	assert.Nil(t, err)
+
+	// 关闭时写入文件
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 20000, len(db2.ListKeys()))
+	val2, err := db2.Get(utils.GetTestKey(19999))
+	assert.Nil(t, err)
+	assert.Equal(t, val, val2)
+	err = db2.Close()
+	assert.Nil(t, err)
+}
+
+func TestDB_MMapReadWrite(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-db-mmap-rw")
+	opts.DirPath = dir
+	opts.DataFileSize = 1024 * 1024
+	opts.MMapReadWrite = true
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 20000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+	assert.True(t, len(db.olderFiles) > 0)
+	val, err := db.Get(utils.GetTestKey(10))
+	assert.Nil(t, err)
+	assert.NotNil(t, val)
+
+	// 旧的数据文件截断为实际的数据大小
+	for _, dataFile := range db.olderFiles {
+		stat, err := os.Stat(data.GetDataFileName(dir, dataFile.FileId))
+		assert.Nil(t, err)
+		assert.Equal(t, dataFile.WriteOffset, stat.Size())
+	}
+
+	// 模拟异常退出，活跃文件末尾保留预分配的空白区域
+	assert.Nil(t, db.activeFile.Sync())
+	activeFileName := data.GetDataFileName(dir, db.activeFile.FileId)
+	crashDB(t, db)
+	assert.Nil(t, os.Truncate(activeFileName, opts.DataFileSize))
+
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 20000, len(db2.ListKeys()))
+	err = db2.Put(utils.GetTestKey(20000), []byte("after crash"))
+	assert.Nil(t, err)
+	err = db2.Close()
+	assert.Nil(t, err)
+
+	// 不使用 MMap 写入时，截断空白区域后追加写入
+	opts.MMapReadWrite = false
+	db3, err := Open(opts)
+	assert.Nil(t, err)
+	val3, err := db3.Get(utils.GetTestKey(20000))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("after crash"), val3)
+	err = db3.Close()
+	assert.Nil(t, err)
+	assert.Nil(t, os.Truncate(activeFileName, opts.DataFileSize))
+
+	db4, err := Open(opts)
+	assert.Nil(t, err)
+	err = db4.Put(utils.GetTestKey(20001), []byte("standard io"))
+	assert.Nil(t, err)
+	err = db4.Close()
+	assert.Nil(t, err)
+
+	db5, err := Open(opts)
+	assert.Nil(t, err)
+	val5, err := db5.Get(utils.GetTestKey(20001))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("standard io"), val5)
+	err = db5.Close()
+	assert.Nil(t, err)
+}
+
+func TestDB_MemFileSystem(t *testing.T) {
+	opts := DefaultOptions
+	opts.DirPath = "/bitcask-db-memory"
+	opts.DataFileSize = 1024 * 1024
+	opts.DataFileMergeRatio = 0
+	opts.FileSystem = fio.NewMemFileSystem()
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 20000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 5000; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	assert.True(t, len(db.olderFiles) > 0)
+	err = db.Merge()
+	assert.Nil(t, err)
+
+	backupDir := "/bitcask-db-memory-backup"
+	err = db.BackUp(backupDir)
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+
+	// 数据只保存在内存文件系统中
+	_, err = os.Stat(opts.DirPath)
+	assert.True(t, os.IsNotExist(err))
+
+	// 重启之后加载 merge 的数据
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 15000, len(db2.ListKeys()))
+	_, err = db2.Get(utils.GetTestKey(100))
+	assert.Equal(t, ErrKeyNotFound, err)
+	val, err := db2.Get(utils.GetTestKey(10000))
+	assert.Nil(t, err)
+	assert.NotNil(t, val)
+	err = db2.Close()
+	assert.Nil(t, err)
+
+	opts.DirPath = backupDir
+	db3, err := Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 15000, len(db3.ListKeys()))
+	err = db3.Close()
+	assert.Nil(t, err)
+
+	// 内存文件系统不支持 B+ 树索引
+	opts.IndexType = BPlusTree
+	_, err = Open(opts)
+	assert.NotNil(t, err)
+}
+
+func TestDB_MaxOpenFiles(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-db-max-open-files")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	opts.MaxOpenFiles = 3
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 5000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(64))
+		assert.Nil(t, err)
+	}
+	assert.True(t, len(db.olderFiles) > 3)
+	assert.True(t, db.filePool.Len() <= 3)
+	for i := 0; i < 5000; i += 7 {
+		val, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.NotNil(t, val)
+	}
+	assert.True(t, db.filePool.Len() <= 3)
+
+	err = db.Close()
+	assert.Nil(t, err)
+
+	// 启动时按需打开旧数据文件
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	assert.True(t, db2.filePool.Len() <= 3)
+	assert.Equal(t, 5000, len(db2.ListKeys()))
+	var count int
+	err = db2.Fold(func(key, value []byte) bool {
+		count++
+		return true
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 5000, count)
+	assert.True(t, db2.filePool.Len() <= 3)
+	err = db2.Close()
+	assert.Nil(t, err)
+}
+
+func TestDB_DirectIO(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-db-direct-io")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	opts.DirectIO = true
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 2000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(64))
+		assert.Nil(t, err)
+	}
+	assert.True(t, len(db.olderFiles) > 0)
+	for i := 0; i < 2000; i += 7 {
+		val, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.NotNil(t, val)
+	}
+	err = db.Close()
+	assert.Nil(t, err)
+
+	// 重启之后继续读写
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 2000, len(db2.ListKeys()))
+	err = db2.Put(utils.GetTestKey(2000), utils.RandomValue(64))
+	assert.Nil(t, err)
+	val, err := db2.Get(utils.GetTestKey(1999))
+	assert.Nil(t, err)
+	assert.NotNil(t, val)
+	err = db2.Close()
+	assert.Nil(t, err)
+}
+
+func TestDB_PreallocateWithWriteBuffer(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-db-preallocate-buffer")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	opts.Preallocate = true
+	opts.WriteBufferSize = 4 * 1024
+	opts.BytesPerRangeSync = 4 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(64)))
+	}
+	assert.True(t, len(db.olderFiles) > 0)
+	// 开启写缓冲时旧的数据文件同样截断为实际的数据大小
+	for fileId, dataFile := range db.olderFiles {
+		stat, err := os.Stat(data.GetDataFileName(dir, fileId))
+		assert.Nil(t, err)
+		assert.Equal(t, dataFile.WriteOffset, stat.Size())
+	}
+}
+
+func TestDB_Preallocate(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-db-preallocate")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	opts.Preallocate = true
+	opts.BytesPerSync = 16 * 1024
+	opts.BytesPerRangeSync = 4 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(64))
+		assert.Nil(t, err)
+	}
+	assert.True(t, len(db.olderFiles) > 0)
+
+	// 活跃文件预分配到 DataFileSize 大小，旧的数据文件截断为实际的数据大小
+	stat, err := os.Stat(data.GetDataFileName(dir, db.activeFile.FileId))
+	assert.Nil(t, err)
+	assert.Equal(t, opts.DataFileSize, stat.Size())
+	stat, err = os.Stat(data.GetDataFileName(dir, 0))
+	assert.Nil(t, err)
+	assert.Equal(t, db.olderFiles[0].WriteOffset, stat.Size())
+
+	// 模拟异常退出：拷贝的活跃文件末尾有预分配的空白区域，最后一条记录没有写完整
+	err = db.Sync()
+	assert.Nil(t, err)
+	backupDir, _ := os.MkdirTemp("", "bitcask-db-preallocate-backup")
+	err = db.BackUp(backupDir)
+	assert.Nil(t, err)
+	activeFileName := data.GetDataFileName(backupDir, db.activeFile.FileId)
+	writeOffset := db.activeFile.WriteOffset
+	encRecord, _ := data.EncodeLogRecord(&data.LogRecord{Key: []byte("torn-key"), Value: utils.RandomValue(64)})
+	f, err := os.OpenFile(activeFileName, os.O_WRONLY, 0644)
+	assert.Nil(t, err)
+	_, err = f.WriteAt(encRecord[:len(encRecord)/2], writeOffset)
+	assert.Nil(t, err)
+	assert.Nil(t, f.Close())
+
+	backupOpts := opts
+	backupOpts.DirPath = backupDir
+	db2, err := Open(backupOpts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	assert.Equal(t, 1000, len(db2.ListKeys()))
+	assert.Equal(t, writeOffset, db2.activeFile.WriteOffset)
+	err = db2.Put([]byte("torn-key"), utils.RandomValue(64))
+	assert.Nil(t, err)
+	val, err := db2.Get(utils.GetTestKey(999))
+	assert.Nil(t, err)
+	assert.NotNil(t, val)
+	err = db2.Close()
+	assert.Nil(t, err)
+
+	// 关闭之后活跃文件截断为实际的数据大小
+	err = db.Close()
+	assert.Nil(t, err)
+	stat, err = os.Stat(data.GetDataFileName(dir, db.activeFile.FileId))
+	assert.Nil(t, err)
+	assert.Equal(t, db.activeFile.WriteOffset, stat.Size())
+
+	db3, err := Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 1000, len(db3.ListKeys()))
+	err = db3.Close()
+	assert.Nil(t, err)
+}
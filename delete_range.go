@@ -0,0 +1,96 @@
+package bitcask_db
+
+import (
+	"bitcask-db/data"
+	"bitcask-db/index"
+	"bytes"
+	"sync/atomic"
+)
+
+// DeleteRange 删除 [start, end) 范围内所有的 key，end 为空时删除 start 之后所有的 key
+// start 和 end 不能同时为空，避免误删除所有的 key
+// 只写入一条范围删除标记，范围内没有 key 时不写入
+// 保留历史版本时为范围内的每个 key 写入删除版本，所有的删除版本使用同一个版本号
+func (db *DB) DeleteRange(start, end []byte) error {
+	if len(start) == 0 && len(end) == 0 {
+		return ErrKeyIsEmpty
+	}
+	if len(end) > 0 && bytes.Compare(start, end) >= 0 {
+		return nil
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.deleteRange(start, end)
+}
+
+// DeletePrefix 删除所有以 prefix 开头的 key
+func (db *DB) DeletePrefix(prefix []byte) error {
+	if len(prefix) == 0 {
+		return ErrKeyIsEmpty
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.deleteRange(prefix, prefixEnd(prefix))
+}
+
+// deleteRange 追加写入范围删除标记并从内存索引中删除范围内的 key
+// 在访问此方法前必须持有互斥锁
+func (db *DB) deleteRange(start, end []byte) error {
+	entries := db.rangeEntries(start, end)
+	if len(entries) == 0 {
+		return nil
+	}
+	// 范围删除标记不在版本链中，逐个写入删除版本
+	if db.historyEnabled() {
+		seq := atomic.AddUint64(&db.versionSeq, 1)
+		for _, entry := range entries {
+			if err := db.deleteVersion(entry.Key, seq); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	logRecord := &data.LogRecord{
+		Key:  logRecordKeyWithSeqNo(data.EncodeKeyRange(start, end), nonTransactionSeqNo),
+		Type: data.LogRecordRangeDeleted,
+	}
+	pos, err := db.appendLogRecord(logRecord)
+	if err != nil {
+		return err
+	}
+	db.reclaimableSize += int64(pos.Size)
+	for _, oldPos := range db.applyIndex(entries, nil) {
+		if oldPos != nil {
+			db.reclaimableSize += int64(oldPos.Size)
+		}
+	}
+	return nil
+}
+
+// rangeEntries 查找索引中 [start, end) 范围内的 key，构造删除这些 key 的索引更新
+func (db *DB) rangeEntries(start, end []byte) []*index.BatchEntry {
+	var entries []*index.BatchEntry
+	iterator := db.index.Iterator(false)
+	defer iterator.Close()
+	for iterator.Seek(start); iterator.Valid(); iterator.Next() {
+		key := iterator.Key()
+		if len(end) > 0 && bytes.Compare(key, end) >= 0 {
+			break
+		}
+		entries = append(entries, &index.BatchEntry{Key: key})
+	}
+	return entries
+}
+
+// prefixEnd 获取大于所有以 prefix 开头的 key 的最小值，prefix 全部为 0xff 时返回空
+func prefixEnd(prefix []byte) []byte {
+	end := make([]byte, len(prefix))
+	copy(end, prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package bitcask_db
+
+import (
+	"bitcask-db/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestDB_DeleteRange(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-db-delete-range")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	db, err := Open(opts)
+	assert.Nil(t, err)
+
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(64)))
+	}
+	reclaimable := db.Stat().ReclaimableSize
+	// 删除 [100, 200) 范围内的 key
+	assert.Nil(t, db.DeleteRange(utils.GetTestKey(100), utils.GetTestKey(200)))
+	assert.Equal(t, uint(900), db.Stat().KeyNum)
+	assert.True(t, db.Stat().ReclaimableSize > reclaimable)
+	_, err = db.Get(utils.GetTestKey(150))
+	assert.Equal(t, ErrKeyNotFound, err)
+	_, err = db.Get(utils.GetTestKey(200))
+	assert.Nil(t, err)
+
+	// 范围删除之后重新写入的 key 不受影响
+	assert.Nil(t, db.Put(utils.GetTestKey(150), []byte("new")))
+	// 范围内没有 key 时不写入
+	offset := db.activeFile.WriteOffset
+	assert.Nil(t, db.DeleteRange(utils.GetTestKey(100), utils.GetTestKey(150)))
+	assert.Equal(t, offset, db.activeFile.WriteOffset)
+	assert.Nil(t, db.DeleteRange(utils.GetTestKey(300), utils.GetTestKey(300)))
+	// 范围的两端都为空时不删除所有的 key
+	assert.Equal(t, ErrKeyIsEmpty, db.DeleteRange(nil, nil))
+	assert.Equal(t, ErrKeyIsEmpty, db.DeleteRange([]byte{}, nil))
+	assert.Equal(t, uint(901), db.Stat().KeyNum)
+	assert.Nil(t, db.Close())
+
+	// 重启之后范围删除仍然有效
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	assert.Equal(t, uint(901), db2.Stat().KeyNum)
+	val, err := db2.Get(utils.GetTestKey(150))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("new"), val)
+	_, err = db2.Get(utils.GetTestKey(199))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// end 为空时删除 start 之后所有的 key
+	assert.Nil(t, db2.DeleteRange(utils.GetTestKey(900), nil))
+	assert.Equal(t, uint(801), db2.Stat().KeyNum)
+	_, err = db2.Get(utils.GetTestKey(999))
+	assert.Equal(t, ErrKeyNotFound, err)
+}
+
+func TestDB_DeletePrefix(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-db-delete-prefix")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	assert.Nil(t, err)
+
+	for i := 0; i < 500; i++ {
+		assert.Nil(t, db.Put([]byte("tenant-a/"+string(utils.GetTestKey(i))), utils.RandomValue(64)))
+		assert.Nil(t, db.Put([]byte("tenant-b/"+string(utils.GetTestKey(i))), utils.RandomValue(64)))
+	}
+	assert.Equal(t, ErrKeyIsEmpty, db.DeletePrefix(nil))
+	assert.Nil(t, db.DeletePrefix([]byte("tenant-a/")))
+	assert.Equal(t, uint(500), db.Stat().KeyNum)
+	for _, key := range db.ListKeys() {
+		assert.Equal(t, "tenant-b/", string(key[:9]))
+	}
+	assert.Nil(t, db.Put([]byte("tenant-a/new"), []byte("value")))
+
+	// merge 之后范围删除标记被清理，重启后数据仍然正确
+	assert.Nil(t, db.Merge())
+	assert.Nil(t, db.Close())
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	assert.Equal(t, uint(501), db2.Stat().KeyNum)
+	val, err := db2.Get([]byte("tenant-a/new"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value"), val)
+	_, err = db2.Get([]byte("tenant-a/" + string(utils.GetTestKey(1))))
+	assert.Equal(t, ErrKeyNotFound, err)
+}
+
+func TestPrefixEnd(t *testing.T) {
+	assert.Equal(t, []byte("ab"), prefixEnd([]byte("aa")))
+	assert.Equal(t, []byte{0x01}, prefixEnd([]byte{0x00, 0xff}))
+	assert.Nil(t, prefixEnd([]byte{0xff, 0xff}))
+}
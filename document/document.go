@@ -0,0 +1,187 @@
+package document
+
+import (
+	bitcask "bitcask-db"
+	"encoding/json"
+	"strings"
+	"sync"
+)
+
+// 在 DB 之上存储 JSON 文档，同一个集合中的文档以 集合名称/文档 id 为 key 存储
+// 集合名称中不能包含 /，文档 id 可以包含 /，key 中第一个 / 之前的部分就是集合名称
+// 文档的读-改-写通过版本号的条件写入保证原子性，并发修改同一个文档时重试
+
+// Collection 一组 JSON 文档
+type Collection struct {
+	db      *bitcask.DB
+	prefix  []byte
+	mu      sync.RWMutex
+	indexes map[string]string // JSON 路径对应的二级索引名称
+}
+
+// Document Find 返回的一个文档
+type Document struct {
+	ID   string
+	Body map[string]interface{}
+}
+
+// NewCollection 打开名称为 name 的集合，集合不需要提前创建，name 中包含 / 时返回 ErrInvalidName
+func NewCollection(db *bitcask.DB, name string) (*Collection, error) {
+	if !validName(name) {
+		return nil, ErrInvalidName
+	}
+	return &Collection{
+		db:      db,
+		prefix:  []byte(name + "/"),
+		indexes: make(map[string]string),
+	}, nil
+}
+
+// validName 集合名称是否合法，名称中包含 / 时一个集合的前缀会匹配到其他集合中的文档
+func validName(name string) bool {
+	return !strings.Contains(name, "/")
+}
+
+// Insert 写入新的文档，文档必须是 JSON 对象，id 已经存在时返回 ErrDocumentExists
+func (c *Collection) Insert(id string, doc interface{}) error {
+	if len(id) == 0 {
+		return ErrIDIsEmpty
+	}
+	value, err := encodeDocument(doc)
+	if err != nil {
+		return err
+	}
+	ok, err := c.db.PutIfAbsent(c.key(id), value)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrDocumentExists
+	}
+	return nil
+}
+
+// Update 使用 doc 替换已经存在的文档，id 不存在时返回 ErrDocumentNotFound
+func (c *Collection) Update(id string, doc interface{}) error {
+	value, err := encodeDocument(doc)
+	if err != nil {
+		return err
+	}
+	return c.modify(id, func(map[string]interface{}) ([]byte, error) {
+		return value, nil
+	})
+}
+
+// Patch 按照 JSON merge patch (RFC 7396) 修改已经存在的文档
+// patch 中值为 null 的字段从文档中删除，对象类型的字段递归合并，其他的值直接替换
+func (c *Collection) Patch(id string, patch []byte) error {
+	var patchDoc interface{}
+	if err := json.Unmarshal(patch, &patchDoc); err != nil {
+		return err
+	}
+	if _, ok := patchDoc.(map[string]interface{}); !ok {
+		return ErrNotObject
+	}
+	return c.modify(id, func(body map[string]interface{}) ([]byte, error) {
+		return json.Marshal(mergePatch(body, patchDoc))
+	})
+}
+
+// Get 读取文档，指定 fields 时只返回这些 JSON 路径上的字段，路径使用 . 分隔
+func (c *Collection) Get(id string, fields ...string) (map[string]interface{}, error) {
+	if len(id) == 0 {
+		return nil, ErrIDIsEmpty
+	}
+	value, err := c.db.Get(c.key(id))
+	if err == bitcask.ErrKeyNotFound {
+		return nil, ErrDocumentNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	body, err := decodeDocument(value)
+	if err != nil {
+		return nil, err
+	}
+	return project(body, fields), nil
+}
+
+// Delete 删除文档，id 不存在时返回 ErrDocumentNotFound
+func (c *Collection) Delete(id string) error {
+	if len(id) == 0 {
+		return ErrIDIsEmpty
+	}
+	key := c.key(id)
+	for {
+		_, version, err := c.db.GetWithVersion(key)
+		if err == bitcask.ErrKeyNotFound {
+			return ErrDocumentNotFound
+		}
+		if err != nil {
+			return err
+		}
+		ok, err := c.db.DeleteIfVersion(key, version)
+		if err != nil || ok {
+			return err
+		}
+	}
+}
+
+// modify 读取文档，使用 fn 生成新的文档之后写入，期间文档被其他写入修改时重新读取
+func (c *Collection) modify(id string, fn func(body map[string]interface{}) ([]byte, error)) error {
+	if len(id) == 0 {
+		return ErrIDIsEmpty
+	}
+	key := c.key(id)
+	for {
+		value, version, err := c.db.GetWithVersion(key)
+		if err == bitcask.ErrKeyNotFound {
+			return ErrDocumentNotFound
+		}
+		if err != nil {
+			return err
+		}
+		body, err := decodeDocument(value)
+		if err != nil {
+			return err
+		}
+		newValue, err := fn(body)
+		if err != nil {
+			return err
+		}
+		ok, err := c.db.PutIfVersion(key, newValue, version)
+		if err != nil || ok {
+			return err
+		}
+	}
+}
+
+// key 文档在 DB 中的 key
+func (c *Collection) key(id string) []byte {
+	return append(append([]byte(nil), c.prefix...), id...)
+}
+
+// encodeDocument 将文档编码为 JSON，文档必须是 JSON 对象
+func encodeDocument(doc interface{}) ([]byte, error) {
+	value, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := decodeDocument(value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// decodeDocument 解析 JSON 对象
+func decodeDocument(value []byte) (map[string]interface{}, error) {
+	var doc interface{}
+	if err := json.Unmarshal(value, &doc); err != nil {
+		return nil, err
+	}
+	body, ok := doc.(map[string]interface{})
+	if !ok {
+		return nil, ErrNotObject
+	}
+	return body, nil
+}
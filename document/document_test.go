@@ -0,0 +1,115 @@
+package document
+
+import (
+	bitcask "bitcask-db"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func openDB(t *testing.T, opts bitcask.Options) (*bitcask.DB, string) {
+	dir, _ := os.MkdirTemp("", "bitcask-db-document")
+	opts.DirPath = dir
+	db, err := bitcask.Open(opts)
+	assert.Nil(t, err)
+	return db, dir
+}
+
+func destroyDB(db *bitcask.DB, dir string) {
+	if db != nil {
+		_ = db.Close()
+	}
+	_ = os.RemoveAll(dir)
+}
+
+func newCollection(t *testing.T, db *bitcask.DB, name string) *Collection {
+	collection, err := NewCollection(db, name)
+	assert.Nil(t, err)
+	return collection
+}
+
+func TestCollection_InsertUpdatePatch(t *testing.T) {
+	db, dir := openDB(t, bitcask.DefaultOptions)
+	defer destroyDB(db, dir)
+	users := newCollection(t, db, "users")
+
+	doc := map[string]interface{}{
+		"name":    "alice",
+		"age":     30,
+		"address": map[string]interface{}{"city": "beijing", "zip": "100000"},
+		"tags":    []string{"a", "b"},
+	}
+	assert.Nil(t, users.Insert("1", doc))
+	assert.Equal(t, ErrDocumentExists, users.Insert("1", doc))
+	assert.Equal(t, ErrNotObject, users.Insert("2", []int{1, 2}))
+	assert.Equal(t, ErrIDIsEmpty, users.Insert("", doc))
+
+	body, err := users.Get("1")
+	assert.Nil(t, err)
+	assert.Equal(t, "alice", body["name"])
+	assert.Equal(t, float64(30), body["age"])
+
+	// 字段投影
+	body, err = users.Get("1", "name", "address.city", "tags.0", "missing")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"name":    "alice",
+		"address": map[string]interface{}{"city": "beijing"},
+		"tags":    []interface{}{"a", "b"},
+	}, body)
+
+	// merge patch 删除、替换和递归合并字段
+	assert.Nil(t, users.Patch("1", []byte(`{"age":31,"tags":null,"address":{"zip":null,"street":"main"}}`)))
+	body, err = users.Get("1")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"name":    "alice",
+		"age":     float64(31),
+		"address": map[string]interface{}{"city": "beijing", "street": "main"},
+	}, body)
+	assert.Equal(t, ErrNotObject, users.Patch("1", []byte(`[1]`)))
+	assert.Equal(t, ErrDocumentNotFound, users.Patch("2", []byte(`{}`)))
+
+	assert.Nil(t, users.Update("1", map[string]interface{}{"name": "bob"}))
+	body, err = users.Get("1")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"name": "bob"}, body)
+	assert.Equal(t, ErrDocumentNotFound, users.Update("2", doc))
+
+	// 其他集合中相同 id 的文档互不影响
+	orders := newCollection(t, db, "orders")
+	_, err = orders.Get("1")
+	assert.Equal(t, ErrDocumentNotFound, err)
+
+	assert.Nil(t, users.Delete("1"))
+	assert.Equal(t, ErrDocumentNotFound, users.Delete("1"))
+	_, err = users.Get("1")
+	assert.Equal(t, ErrDocumentNotFound, err)
+}
+
+func TestCollection_NestedName(t *testing.T) {
+	db, dir := openDB(t, bitcask.DefaultOptions)
+	defer destroyDB(db, dir)
+	_, err := NewCollection(db, "users/x")
+	assert.Equal(t, ErrInvalidName, err)
+
+	// id 中的 / 不会让文档出现在其他集合中
+	users := newCollection(t, db, "users")
+	assert.Nil(t, users.Insert("x/1", map[string]interface{}{"name": "alice"}))
+	assert.Nil(t, users.Insert("2", map[string]interface{}{"name": "bob"}))
+	body, err := users.Get("x/1")
+	assert.Nil(t, err)
+	assert.Equal(t, "alice", body["name"])
+	docs, err := users.Find(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"2", "x/1"}, docIDs(docs))
+
+	usersX := newCollection(t, db, "usersx")
+	assert.Nil(t, usersX.Insert("1", map[string]interface{}{"name": "carol"}))
+	docs, err = usersX.Find(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"1"}, docIDs(docs))
+	docs, err = users.Find(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(docs))
+}
@@ -0,0 +1,11 @@
+package document
+
+import "errors"
+
+var (
+	ErrIDIsEmpty        = errors.New("document id is empty")
+	ErrDocumentExists   = errors.New("document already exists")
+	ErrDocumentNotFound = errors.New("document not found in collection")
+	ErrNotObject        = errors.New("document is not a json object")
+	ErrInvalidName      = errors.New("collection name must not contain '/'")
+)
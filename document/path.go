@@ -0,0 +1,83 @@
+package document
+
+import (
+	"strconv"
+	"strings"
+)
+
+// lookup 查找 JSON 路径上的值，路径使用 . 分隔，数组使用下标访问
+func lookup(body map[string]interface{}, path string) (interface{}, bool) {
+	var value interface{} = body
+	for _, field := range strings.Split(path, ".") {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			var ok bool
+			if value, ok = v[field]; !ok {
+				return nil, false
+			}
+		case []interface{}:
+			i, err := strconv.Atoi(field)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil, false
+			}
+			value = v[i]
+		default:
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// project 只保留 fields 中的字段，保持原来的嵌套结构，fields 为空时返回完整的文档
+// 数组中的字段投影为整个数组
+func project(body map[string]interface{}, fields []string) map[string]interface{} {
+	if len(fields) == 0 {
+		return body
+	}
+	projected := make(map[string]interface{})
+	for _, path := range fields {
+		value, ok := lookup(body, path)
+		if !ok {
+			continue
+		}
+		parts := strings.Split(path, ".")
+		last := parts[len(parts)-1]
+		target, source := projected, body
+		for _, field := range parts[:len(parts)-1] {
+			next, ok := source[field].(map[string]interface{})
+			if !ok {
+				// 路径穿过数组时投影整个数组
+				last, value = field, source[field]
+				break
+			}
+			child, ok := target[field].(map[string]interface{})
+			if !ok {
+				child = make(map[string]interface{})
+				target[field] = child
+			}
+			target, source = child, next
+		}
+		target[last] = value
+	}
+	return projected
+}
+
+// mergePatch 将 patch 按照 JSON merge patch 的规则合并到 target 上，返回合并之后的值
+func mergePatch(target interface{}, patch interface{}) interface{} {
+	patchObj, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	targetObj, ok := target.(map[string]interface{})
+	if !ok {
+		targetObj = make(map[string]interface{})
+	}
+	for field, value := range patchObj {
+		if value == nil {
+			delete(targetObj, field)
+			continue
+		}
+		targetObj[field] = mergePatch(targetObj[field], value)
+	}
+	return targetObj
+}
@@ -0,0 +1,296 @@
+package document
+
+import (
+	bitcask "bitcask-db"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Op 过滤条件的比较方式
+type Op int
+
+const (
+	// Eq 等于
+	Eq Op = iota
+	// Gt 大于
+	Gt
+	// Gte 大于等于
+	Gte
+	// Lt 小于
+	Lt
+	// Lte 小于等于
+	Lte
+)
+
+// Filter JSON 路径上的值和 Value 比较的过滤条件
+// 范围比较只在数字之间或者字符串之间进行，类型不同时不满足条件
+type Filter struct {
+	Path  string
+	Op    Op
+	Value interface{}
+}
+
+// 二级索引中值的类型标记，同一类型的值按照编码之后的字节序排序
+const (
+	tagNull byte = iota
+	tagBool
+	tagNumber
+	tagString
+)
+
+// FieldIndex 提取集合 name 中文档 path 字段的二级索引提取函数，在 Options.SecondaryIndexes 中注册之后
+// 使用 Collection.UseIndex 让 Find 通过二级索引查找，name 不是合法的集合名称时不提取任何值
+func FieldIndex(name, path string) bitcask.SecondaryExtractor {
+	prefix := []byte(name + "/")
+	valid := validName(name)
+	return func(key, value []byte) [][]byte {
+		if !valid || !bytes.HasPrefix(key, prefix) {
+			return nil
+		}
+		body, err := decodeDocument(value)
+		if err != nil {
+			return nil
+		}
+		field, ok := lookup(body, path)
+		if !ok {
+			return nil
+		}
+		encoded, ok := encodeIndexValue(field)
+		if !ok {
+			return nil
+		}
+		return [][]byte{encoded}
+	}
+}
+
+// UseIndex Find 中 path 字段的过滤条件使用二级索引 indexName 查找候选的文档
+// 二级索引需要使用 FieldIndex 创建，DB 中没有 indexName 时返回 bitcask.ErrSecondaryIndexNotFound
+// 可以和 Find 并发调用，之后开始的 Find 使用新的二级索引
+func (c *Collection) UseIndex(path, indexName string) error {
+	// 只检查二级索引是否存在，不遍历其中的数据
+	err := c.db.ScanSecondary(indexName, nil, nil, func(_, _ []byte) bool {
+		return false
+	})
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.indexes[path] = indexName
+	return nil
+}
+
+// indexFor 获取 path 字段使用的二级索引名称
+func (c *Collection) indexFor(path string) (string, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	indexName, ok := c.indexes[path]
+	return indexName, ok
+}
+
+// Find 查找满足所有过滤条件的文档，按照 id 排序，指定 fields 时只返回这些字段
+// 存在使用二级索引的过滤条件时只读取索引找到的文档，否则遍历集合中所有的文档
+func (c *Collection) Find(filters []Filter, fields ...string) ([]*Document, error) {
+	normalized := make([]Filter, len(filters))
+	for i, filter := range filters {
+		value, err := normalize(filter.Value)
+		if err != nil {
+			return nil, err
+		}
+		normalized[i] = Filter{Path: filter.Path, Op: filter.Op, Value: value}
+	}
+	filters = normalized
+	for _, filter := range filters {
+		indexName, ok := c.indexFor(filter.Path)
+		if !ok {
+			continue
+		}
+		if start, end, ok := indexRange(filter); ok {
+			return c.findByIndex(indexName, start, end, filters, fields)
+		}
+	}
+
+	var docs []*Document
+	iterator := c.db.NewIterator(bitcask.IteratorOptions{Prefix: c.prefix})
+	defer iterator.Close()
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		value, err := iterator.Value()
+		if err != nil {
+			return nil, err
+		}
+		doc, err := c.match(iterator.Key(), value, filters, fields)
+		if err != nil {
+			return nil, err
+		}
+		if doc != nil {
+			docs = append(docs, doc)
+		}
+	}
+	return docs, nil
+}
+
+// findByIndex 根据二级索引 [start, end) 范围找到候选的文档，读取之后再检查所有的过滤条件
+func (c *Collection) findByIndex(indexName string, start, end []byte, filters []Filter, fields []string) ([]*Document, error) {
+	var keys [][]byte
+	err := c.db.ScanSecondary(indexName, start, end, func(_, key []byte) bool {
+		if bytes.HasPrefix(key, c.prefix) {
+			keys = append(keys, key)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	// 同一个文档在索引中只有一个值，按照 id 排序
+	sort.Slice(keys, func(i, j int) bool {
+		return bytes.Compare(keys[i], keys[j]) < 0
+	})
+
+	var docs []*Document
+	for _, key := range keys {
+		value, err := c.db.Get(key)
+		if err == bitcask.ErrKeyNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		doc, err := c.match(key, value, filters, fields)
+		if err != nil {
+			return nil, err
+		}
+		if doc != nil {
+			docs = append(docs, doc)
+		}
+	}
+	return docs, nil
+}
+
+// match 文档满足所有过滤条件时返回投影之后的文档，否则返回 nil
+func (c *Collection) match(key, value []byte, filters []Filter, fields []string) (*Document, error) {
+	body, err := decodeDocument(value)
+	if err != nil {
+		return nil, err
+	}
+	for _, filter := range filters {
+		field, ok := lookup(body, filter.Path)
+		if !ok || !compare(field, filter.Op, filter.Value) {
+			return nil, nil
+		}
+	}
+	return &Document{
+		ID:   string(key[len(c.prefix):]),
+		Body: project(body, fields),
+	}, nil
+}
+
+// compare 比较文档中的值和过滤条件中的值
+func compare(field interface{}, op Op, value interface{}) bool {
+	if op == Eq {
+		return reflect.DeepEqual(field, value)
+	}
+	var c int
+	switch v := value.(type) {
+	case float64:
+		f, ok := field.(float64)
+		if !ok {
+			return false
+		}
+		switch {
+		case f < v:
+			c = -1
+		case f > v:
+			c = 1
+		}
+	case string:
+		s, ok := field.(string)
+		if !ok {
+			return false
+		}
+		c = strings.Compare(s, v)
+	default:
+		return false
+	}
+	switch op {
+	case Gt:
+		return c > 0
+	case Gte:
+		return c >= 0
+	case Lt:
+		return c < 0
+	case Lte:
+		return c <= 0
+	}
+	return false
+}
+
+// normalize 将过滤条件中的值转换为解析 JSON 得到的类型，例如整数转换为 float64
+func normalize(value interface{}) (interface{}, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	var normalized interface{}
+	if err := json.Unmarshal(data, &normalized); err != nil {
+		return nil, err
+	}
+	return normalized, nil
+}
+
+// indexRange 过滤条件在二级索引中对应的 [start, end) 范围，不能使用索引查找时返回 false
+func indexRange(filter Filter) ([]byte, []byte, bool) {
+	encoded, ok := encodeIndexValue(filter.Value)
+	if !ok {
+		return nil, nil, false
+	}
+	// 紧跟在 encoded 之后的二级 key
+	next := append(append([]byte(nil), encoded...), 0)
+	typeStart, typeEnd := []byte{encoded[0]}, []byte{encoded[0] + 1}
+	switch filter.Op {
+	case Eq:
+		return encoded, next, true
+	case Gt:
+		return next, typeEnd, true
+	case Gte:
+		return encoded, typeEnd, true
+	case Lt:
+		return typeStart, encoded, true
+	case Lte:
+		return typeStart, next, true
+	}
+	return nil, nil, false
+}
+
+// encodeIndexValue 将 JSON 的标量值编码为二级 key，同一类型的值编码之后的字节序和值的顺序一致
+// 对象和数组不能作为二级 key
+func encodeIndexValue(value interface{}) ([]byte, bool) {
+	switch v := value.(type) {
+	case nil:
+		return []byte{tagNull}, true
+	case bool:
+		if v {
+			return []byte{tagBool, 1}, true
+		}
+		return []byte{tagBool, 0}, true
+	case float64:
+		// 正数翻转符号位，负数翻转所有的位，-0 和 0 使用相同的编码
+		if v == 0 {
+			v = 0
+		}
+		bits := math.Float64bits(v)
+		if v >= 0 {
+			bits ^= 1 << 63
+		} else {
+			bits = ^bits
+		}
+		return binary.BigEndian.AppendUint64([]byte{tagNumber}, bits), true
+	case string:
+		return append([]byte{tagString}, v...), true
+	}
+	return nil, false
+}
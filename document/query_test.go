@@ -0,0 +1,94 @@
+package document
+
+import (
+	bitcask "bitcask-db"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func insertUsers(t *testing.T, users *Collection) {
+	cities := []string{"beijing", "shanghai", "shenzhen"}
+	for i := 0; i < 30; i++ {
+		assert.Nil(t, users.Insert(fmt.Sprintf("%02d", i), map[string]interface{}{
+			"name":    fmt.Sprintf("user-%02d", i),
+			"age":     i - 10,
+			"address": map[string]interface{}{"city": cities[i%3]},
+		}))
+	}
+}
+
+func docIDs(docs []*Document) []string {
+	ids := make([]string, len(docs))
+	for i, doc := range docs {
+		ids[i] = doc.ID
+	}
+	return ids
+}
+
+func TestCollection_Find(t *testing.T) {
+	db, dir := openDB(t, bitcask.DefaultOptions)
+	defer destroyDB(db, dir)
+	users := newCollection(t, db, "users")
+	insertUsers(t, users)
+	assert.Nil(t, newCollection(t, db, "orders").Insert("00", map[string]interface{}{"age": 20}))
+
+	docs, err := users.Find([]Filter{{Path: "address.city", Op: Eq, Value: "shanghai"}, {Path: "age", Op: Lt, Value: 0}})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"01", "04", "07"}, docIDs(docs))
+
+	docs, err = users.Find([]Filter{{Path: "age", Op: Gte, Value: 17}}, "name")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"27", "28", "29"}, docIDs(docs))
+	assert.Equal(t, map[string]interface{}{"name": "user-27"}, docs[0].Body)
+
+	// 类型不同时不满足条件
+	docs, err = users.Find([]Filter{{Path: "age", Op: Gt, Value: "10"}})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(docs))
+
+	docs, err = users.Find(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 30, len(docs))
+}
+
+func TestCollection_FindByIndex(t *testing.T) {
+	opts := bitcask.DefaultOptions
+	opts.SecondaryIndexes = map[string]bitcask.SecondaryExtractor{
+		"users.age":  FieldIndex("users", "age"),
+		"users.city": FieldIndex("users", "address.city"),
+	}
+	db, dir := openDB(t, opts)
+	defer destroyDB(db, dir)
+	users := newCollection(t, db, "users")
+	assert.Nil(t, users.UseIndex("age", "users.age"))
+	assert.Nil(t, users.UseIndex("address.city", "users.city"))
+	assert.Equal(t, bitcask.ErrSecondaryIndexNotFound, users.UseIndex("name", "users.name"))
+	insertUsers(t, users)
+	assert.Nil(t, newCollection(t, db, "orders").Insert("00", map[string]interface{}{"age": 20}))
+
+	// 负数和正数的范围
+	docs, err := users.Find([]Filter{{Path: "age", Op: Gt, Value: -3}, {Path: "age", Op: Lte, Value: 12}})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"08", "09", "10", "11", "12", "13", "14", "15", "16", "17", "18", "19", "20", "21", "22"}, docIDs(docs))
+	docs, err = users.Find([]Filter{{Path: "age", Op: Lt, Value: 13}})
+	assert.Nil(t, err)
+	assert.Equal(t, 23, len(docs))
+
+	docs, err = users.Find([]Filter{{Path: "address.city", Op: Eq, Value: "shanghai"}, {Path: "age", Op: Lt, Value: 0}})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"01", "04", "07"}, docIDs(docs))
+
+	// 更新之后索引中是新的值
+	assert.Nil(t, users.Patch("01", []byte(`{"address":{"city":"beijing"}}`)))
+	docs, err = users.Find([]Filter{{Path: "address.city", Op: Eq, Value: "shanghai"}, {Path: "age", Op: Lt, Value: 0}})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"04", "07"}, docIDs(docs))
+	docs, err = users.Find([]Filter{{Path: "age", Op: Eq, Value: 10}})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"20"}, docIDs(docs))
+	assert.Nil(t, users.Delete("20"))
+	docs, err = users.Find([]Filter{{Path: "age", Op: Eq, Value: 10}})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(docs))
+}
@@ -13,4 +13,15 @@ var (
 	ErrDatabaseIsUsing        = errors.New("the database directory is used by another process")
 	ErrMergeRatioUnreached    = errors.New("the merge ratio do not reach the option")
 	ErrNoEnoughSpaceForMerge  = errors.New("no enough disk space for merge")
+	ErrUnsupportedIndexType   = errors.New("unsupported index type")
+	ErrMergeOperatorNotSet    = errors.New("merge operator is not set in options")
+	ErrInvalidMergeOperand    = errors.New("invalid merge operand")
+	ErrKeyspaceNameIsEmpty    = errors.New("keyspace name is empty")
+	ErrKeyspaceExists         = errors.New("keyspace already exists")
+	ErrKeyspaceNotFound       = errors.New("keyspace not found in database")
+	ErrKeyspaceUnsupported    = errors.New("keyspaces are not supported with the b+ tree index")
+	ErrBlobGCIsProgress       = errors.New("blob gc is in progress,try again later")
+	ErrBlobFileNotFound       = errors.New("blob file is not found")
+	ErrSecondaryIndexNotFound = errors.New("secondary index is not found")
+	ErrStreamWithSecondary    = errors.New("stream values can not be written when secondary indexes are set")
 )
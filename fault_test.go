@@ -0,0 +1,298 @@
+package bitcask_db
+
+import (
+	"bitcask-db/data"
+	"bitcask-db/fio"
+	"bitcask-db/utils"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// 打开运行在内存文件系统上、可以注入故障的数据库
+func openFaultDB(t *testing.T, opts Options) (*DB, *fio.FaultInjector) {
+	injector := fio.NewFaultInjector()
+	opts.FileSystem = fio.NewFaultFileSystem(fio.NewMemFileSystem(), injector)
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+	return db, injector
+}
+
+func TestDB_FaultPut(t *testing.T) {
+	opts := DefaultOptions
+	opts.DirPath = "/bitcask-db-fault-put"
+	db, injector := openFaultDB(t, opts)
+	defer destroyDB(db)
+	opts = db.options
+
+	for i := 0; i < 100; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(24))
+		assert.Nil(t, err)
+	}
+
+	// 只写入了一半的数据
+	fileId := db.activeFile.FileId
+	injector.Inject(fio.Fault{Op: fio.FaultWrite, Kind: fio.FaultShortWrite, N: 1, Pattern: "*.data"})
+	err := db.Put(utils.GetTestKey(100), utils.RandomValue(24))
+	assert.Equal(t, fio.ErrInjectedFault, err)
+	_, err = db.Get(utils.GetTestKey(100))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// 后续的数据写入新的活跃文件
+	err = db.Put(utils.GetTestKey(101), []byte("after short write"))
+	assert.Nil(t, err)
+	assert.Equal(t, fileId+1, db.activeFile.FileId)
+
+	// 持久化失败
+	injector.Inject(fio.Fault{Op: fio.FaultSync, Kind: fio.FaultError, N: 1, Pattern: "*.data"})
+	err = db.Put(utils.GetTestKey(102), utils.RandomValue(24))
+	assert.Nil(t, err)
+	err = db.Sync()
+	assert.Equal(t, fio.ErrInjectedFault, err)
+	err = db.Put(utils.GetTestKey(103), []byte("after sync error"))
+	assert.Nil(t, err)
+	assert.Equal(t, fileId+2, db.activeFile.FileId)
+
+	// 重启之后不完整的记录被忽略
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 103, len(db2.ListKeys()))
+	_, err = db2.Get(utils.GetTestKey(100))
+	assert.Equal(t, ErrKeyNotFound, err)
+	val, err := db2.Get(utils.GetTestKey(101))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("after short write"), val)
+	val, err = db2.Get(utils.GetTestKey(103))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("after sync error"), val)
+	err = db2.Close()
+	assert.Nil(t, err)
+}
+
+func TestDB_FaultPutCorrupt(t *testing.T) {
+	opts := DefaultOptions
+	opts.DirPath = "/bitcask-db-fault-corrupt"
+	db, injector := openFaultDB(t, opts)
+	defer destroyDB(db)
+	opts = db.options
+
+	err := db.Put(utils.GetTestKey(1), utils.RandomValue(24))
+	assert.Nil(t, err)
+
+	// 写入的数据被损坏，读取时校验失败
+	injector.Inject(fio.Fault{Op: fio.FaultWrite, Kind: fio.FaultCorrupt, N: 1, Pattern: "*.data"})
+	err = db.Put(utils.GetTestKey(2), utils.RandomValue(24))
+	assert.Nil(t, err)
+	_, err = db.Get(utils.GetTestKey(2))
+	assert.Equal(t, data.ErrInvalidCRC, err)
+
+	// 读取的数据被损坏，第一次读取的是 header，第二次读取 key 和 value
+	injector.Inject(fio.Fault{Op: fio.FaultRead, Kind: fio.FaultCorrupt, N: 2})
+	_, err = db.Get(utils.GetTestKey(1))
+	assert.Equal(t, data.ErrInvalidCRC, err)
+	_, err = db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+
+	// 从 hint 文件加载索引，读取时才能发现损坏的数据
+	fileId := db.activeFile.FileId
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	_, err = db2.Get(utils.GetTestKey(2))
+	assert.Equal(t, data.ErrInvalidCRC, err)
+	err = db2.Close()
+	assert.Nil(t, err)
+
+	// 从数据文件加载索引时发现损坏的数据，打开失败并释放文件锁
+	err = opts.FileSystem.Remove(data.GetHintFileName(opts.DirPath, fileId))
+	assert.Nil(t, err)
+	_, err = Open(opts)
+	assert.Equal(t, data.ErrInvalidCRC, err)
+	_, err = Open(opts)
+	assert.Equal(t, data.ErrInvalidCRC, err)
+}
+
+func TestDB_FaultOpen(t *testing.T) {
+	opts := DefaultOptions
+	opts.DirPath = "/bitcask-db-fault-open"
+	opts.DataFileSize = 64 * 1024
+	opts.IndexLoadWorkers = 1
+	db, injector := openFaultDB(t, opts)
+	defer destroyDB(db)
+	opts = db.options
+
+	for i := 0; i < 2000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(64))
+		assert.Nil(t, err)
+	}
+	assert.True(t, len(db.olderFiles) > 0)
+	err := db.Close()
+	assert.Nil(t, err)
+
+	// hint 文件读取失败时回退到读取数据文件
+	injector.Inject(fio.Fault{Op: fio.FaultRead, Kind: fio.FaultError, N: 1, Pattern: "*.hint"})
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, injector.Triggered())
+	assert.Equal(t, 2000, len(db2.ListKeys()))
+	err = db2.Close()
+	assert.Nil(t, err)
+
+	// 数据文件读取失败时打开失败
+	injector.Inject(fio.Fault{Op: fio.FaultRead, Kind: fio.FaultError, N: 1, Pattern: "*.hint"})
+	injector.Inject(fio.Fault{Op: fio.FaultRead, Kind: fio.FaultError, N: 1, Pattern: "*.data"})
+	_, err = Open(opts)
+	assert.Equal(t, fio.ErrInjectedFault, err)
+
+	// 故障恢复之后可以正常打开
+	db3, err := Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 2000, len(db3.ListKeys()))
+	err = db3.Close()
+	assert.Nil(t, err)
+}
+
+func TestDB_FaultWriteBatch(t *testing.T) {
+	opts := DefaultOptions
+	opts.DirPath = "/bitcask-db-fault-batch"
+	db, injector := openFaultDB(t, opts)
+	defer destroyDB(db)
+	opts = db.options
+
+	err := db.Put(utils.GetTestKey(0), []byte("value before batch"))
+	assert.Nil(t, err)
+
+	// 事务写到一半失败，所有的数据都不生效
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	for i := 0; i < 10; i++ {
+		err := wb.Put(utils.GetTestKey(i), utils.RandomValue(24))
+		assert.Nil(t, err)
+	}
+	injector.Inject(fio.Fault{Op: fio.FaultWrite, Kind: fio.FaultError, N: 5, Pattern: "*.data"})
+	err = wb.Commit()
+	assert.Equal(t, fio.ErrInjectedFault, err)
+	for i := 1; i < 10; i++ {
+		_, err := db.Get(utils.GetTestKey(i))
+		assert.Equal(t, ErrKeyNotFound, err)
+	}
+
+	// 持久化失败时同样返回错误
+	wbOpts := DefaultWriteBatchOptions
+	wbOpts.SyncWrites = true
+	wb2 := db.NewWriteBatch(wbOpts)
+	err = wb2.Put(utils.GetTestKey(20), utils.RandomValue(24))
+	assert.Nil(t, err)
+	injector.Inject(fio.Fault{Op: fio.FaultSync, Kind: fio.FaultError, N: 1, Pattern: "*.data"})
+	err = wb2.Commit()
+	assert.Equal(t, fio.ErrInjectedFault, err)
+
+	wb3 := db.NewWriteBatch(DefaultWriteBatchOptions)
+	err = wb3.Put(utils.GetTestKey(30), []byte("value after batch"))
+	assert.Nil(t, err)
+	err = wb3.Commit()
+	assert.Nil(t, err)
+
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	val, err := db2.Get(utils.GetTestKey(0))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value before batch"), val)
+	for i := 1; i < 10; i++ {
+		_, err := db2.Get(utils.GetTestKey(i))
+		assert.Equal(t, ErrKeyNotFound, err)
+	}
+	val, err = db2.Get(utils.GetTestKey(30))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value after batch"), val)
+	err = db2.Close()
+	assert.Nil(t, err)
+}
+
+func TestDB_FaultMerge(t *testing.T) {
+	opts := DefaultOptions
+	opts.DirPath = "/bitcask-db-fault-merge"
+	opts.DataFileSize = 64 * 1024
+	opts.DataFileMergeRatio = 0
+	db, injector := openFaultDB(t, opts)
+	defer destroyDB(db)
+	opts = db.options
+
+	for i := 0; i < 2000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(64))
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 1000; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+
+	// merge 过程中写入失败，不影响原来的数据
+	injector.Inject(fio.Fault{Op: fio.FaultWrite, Kind: fio.FaultShortWrite, N: 500, Pattern: "*.data"})
+	err := db.Merge()
+	assert.Equal(t, fio.ErrInjectedFault, err)
+	assert.Equal(t, 1, injector.Triggered())
+	assert.Equal(t, 1000, len(db.ListKeys()))
+
+	injector.Inject(fio.Fault{Op: fio.FaultSync, Kind: fio.FaultError, N: 1, Pattern: data.HintFileName})
+	err = db.Merge()
+	assert.Equal(t, fio.ErrInjectedFault, err)
+
+	// 没有完成的 merge 在重启时被忽略
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 1000, len(db2.ListKeys()))
+
+	// 故障恢复之后可以正常 merge
+	err = db2.Merge()
+	assert.Nil(t, err)
+	err = db2.Close()
+	assert.Nil(t, err)
+	db3, err := Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 1000, len(db3.ListKeys()))
+	_, err = db3.Get(utils.GetTestKey(10))
+	assert.Equal(t, ErrKeyNotFound, err)
+	val, err := db3.Get(utils.GetTestKey(1500))
+	assert.Nil(t, err)
+	assert.NotNil(t, val)
+	err = db3.Close()
+	assert.Nil(t, err)
+}
+
+func TestDB_FaultBlobSync(t *testing.T) {
+	opts := DefaultOptions
+	opts.DirPath = "/bitcask-db-fault-blob"
+	opts.BlobThreshold = 1024
+	db, injector := openFaultDB(t, opts)
+	defer destroyDB(db)
+	opts = db.options
+
+	// 没有开启 SyncWrites 时 blob 文件也在写入位置之前持久化，持久化失败时不写入位置
+	injector.Inject(fio.Fault{Op: fio.FaultSync, Kind: fio.FaultError, N: 1, Pattern: "*" + data.BlobFileNameSuffix})
+	err := db.Put(utils.GetTestKey(1), utils.RandomValue(4*1024))
+	assert.Equal(t, fio.ErrInjectedFault, err)
+	_, err = db.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// 之后的 value 写入新的 blob 文件
+	value := utils.RandomValue(4 * 1024)
+	assert.Nil(t, db.Put(utils.GetTestKey(2), value))
+	assert.Equal(t, 2, len(db.blobFiles))
+	assert.Nil(t, db.Close())
+
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	_, err = db2.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+	val, err := db2.Get(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.Equal(t, value, val)
+	assert.Nil(t, db2.Close())
+}
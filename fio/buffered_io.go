@@ -0,0 +1,137 @@
+package fio
+
+import (
+	"io"
+	"sync"
+)
+
+// BufferedIO 带写缓冲的 IO，将多次追加写入合并为一次系统调用
+// 缓冲区中还没有写入文件的数据同样可以读取
+type BufferedIO struct {
+	ioManager IOManager     // 底层的 IO
+	buf       []byte        // 写缓冲区
+	fileSize  int64         // 已经写入底层文件的数据大小
+	lock      *sync.RWMutex // 保护缓冲区，读写可以并发进行
+}
+
+// NewBufferedIO 在已有的 IOManager 之上初始化带写缓冲的 IO
+func NewBufferedIO(ioManager IOManager, bufferSize int) (*BufferedIO, error) {
+	fileSize, err := ioManager.Size()
+	if err != nil {
+		return nil, err
+	}
+	return &BufferedIO{
+		ioManager: ioManager,
+		buf:       make([]byte, 0, bufferSize),
+		fileSize:  fileSize,
+		lock:      new(sync.RWMutex),
+	}, nil
+}
+
+// Read 从文件的给定位置读取对应的数据，超出文件的部分从缓冲区中读取
+func (bio *BufferedIO) Read(b []byte, offset int64) (int, error) {
+	bio.lock.RLock()
+	defer bio.lock.RUnlock()
+	if offset+int64(len(b)) <= bio.fileSize {
+		return bio.ioManager.Read(b, offset)
+	}
+
+	var n int
+	if offset < bio.fileSize {
+		nBytes, err := bio.ioManager.Read(b[:bio.fileSize-offset], offset)
+		if err != nil {
+			return nBytes, err
+		}
+		n = nBytes
+	}
+	bufOffset := offset + int64(n) - bio.fileSize
+	if bufOffset < int64(len(bio.buf)) {
+		n += copy(b[n:], bio.buf[bufOffset:])
+	}
+	if n < len(b) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Write 写入字节数组到缓冲区，缓冲区满了之后写入文件
+func (bio *BufferedIO) Write(b []byte) (int, error) {
+	bio.lock.Lock()
+	defer bio.lock.Unlock()
+	if len(bio.buf)+len(b) > cap(bio.buf) {
+		if err := bio.flush(); err != nil {
+			return 0, err
+		}
+	}
+	// 比缓冲区还大的数据直接写入文件
+	if len(b) > cap(bio.buf) {
+		n, err := bio.ioManager.Write(b)
+		bio.fileSize += int64(n)
+		return n, err
+	}
+	bio.buf = append(bio.buf, b...)
+	return len(b), nil
+}
+
+// Flush 将缓冲区中的数据写入文件
+func (bio *BufferedIO) Flush() error {
+	bio.lock.Lock()
+	defer bio.lock.Unlock()
+	return bio.flush()
+}
+
+// Sync 将缓冲区中的数据写入文件，并持久化到磁盘中
+func (bio *BufferedIO) Sync() error {
+	bio.lock.Lock()
+	defer bio.lock.Unlock()
+	if err := bio.flush(); err != nil {
+		return err
+	}
+	return bio.ioManager.Sync()
+}
+
+// SyncRange 将缓冲区中的数据写入文件，底层的 IO 支持时提交部分范围回写
+func (bio *BufferedIO) SyncRange(offset, n int64) error {
+	bio.lock.Lock()
+	defer bio.lock.Unlock()
+	if err := bio.flush(); err != nil {
+		return err
+	}
+	if rs, ok := bio.ioManager.(RangeSyncer); ok {
+		return rs.SyncRange(offset, n)
+	}
+	return nil
+}
+
+// Unwrap 获取底层的 IO
+func (bio *BufferedIO) Unwrap() IOManager {
+	return bio.ioManager
+}
+
+// Close 将缓冲区中的数据写入文件，并关闭文件
+func (bio *BufferedIO) Close() error {
+	bio.lock.Lock()
+	defer bio.lock.Unlock()
+	if err := bio.flush(); err != nil {
+		return err
+	}
+	return bio.ioManager.Close()
+}
+
+// Size 获取文件大小，包括缓冲区中的数据
+func (bio *BufferedIO) Size() (int64, error) {
+	bio.lock.RLock()
+	defer bio.lock.RUnlock()
+	return bio.fileSize + int64(len(bio.buf)), nil
+}
+
+func (bio *BufferedIO) flush() error {
+	if len(bio.buf) == 0 {
+		return nil
+	}
+	n, err := bio.ioManager.Write(bio.buf)
+	bio.fileSize += int64(n)
+	// 只写入了部分数据时，保留剩余的部分
+	bio.buf = bio.buf[:copy(bio.buf, bio.buf[n:])]
+	return err
+}
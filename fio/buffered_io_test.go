@@ -0,0 +1,112 @@
+package fio
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBufferedIO_Write(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "buffered-a.data")
+	defer destoryFile(path)
+	fio, err := NewFileIOManager(path)
+	assert.Nil(t, err)
+	bio, err := NewBufferedIO(fio, 8)
+	assert.Nil(t, err)
+
+	n, err := bio.Write([]byte("key-a"))
+	assert.Nil(t, err)
+	assert.Equal(t, 5, n)
+	// 数据还在缓冲区中
+	fileSize, err := fio.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), fileSize)
+	size, err := bio.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(5), size)
+
+	// 缓冲区满了之后写入文件
+	_, err = bio.Write([]byte("key-b"))
+	assert.Nil(t, err)
+	fileSize, err = fio.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(5), fileSize)
+
+	// 比缓冲区大的数据直接写入文件
+	_, err = bio.Write([]byte("a long value"))
+	assert.Nil(t, err)
+	fileSize, err = fio.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(22), fileSize)
+
+	_, err = bio.Write([]byte("c"))
+	assert.Nil(t, err)
+	err = bio.Sync()
+	assert.Nil(t, err)
+	fileSize, err = fio.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(23), fileSize)
+	assert.Nil(t, bio.Close())
+}
+
+func TestBufferedIO_Read(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "buffered-b.data")
+	defer destoryFile(path)
+	fio, err := NewFileIOManager(path)
+	assert.Nil(t, err)
+	_, err = fio.Write([]byte("key-a"))
+	assert.Nil(t, err)
+	bio, err := NewBufferedIO(fio, 64)
+	assert.Nil(t, err)
+	_, err = bio.Write([]byte("key-b"))
+	assert.Nil(t, err)
+
+	// 只在文件中
+	b1 := make([]byte, 5)
+	n, err := bio.Read(b1, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 5, n)
+	assert.Equal(t, []byte("key-a"), b1)
+
+	// 跨越文件和缓冲区
+	b2 := make([]byte, 6)
+	n, err = bio.Read(b2, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, 6, n)
+	assert.Equal(t, []byte("y-akey"), b2)
+
+	// 只在缓冲区中
+	b3 := make([]byte, 5)
+	n, err = bio.Read(b3, 5)
+	assert.Nil(t, err)
+	assert.Equal(t, 5, n)
+	assert.Equal(t, []byte("key-b"), b3)
+
+	// 超出数据范围
+	b4 := make([]byte, 5)
+	n, err = bio.Read(b4, 8)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, 2, n)
+	assert.Nil(t, bio.Close())
+}
+
+func TestBufferedIO_SyncRange(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "buffered-c.data")
+	defer destoryFile(path)
+	fio, err := NewFileIOManager(path)
+	assert.Nil(t, err)
+	bio, err := NewBufferedIO(fio, 64)
+	assert.Nil(t, err)
+
+	_, err = bio.Write([]byte("key-a"))
+	assert.Nil(t, err)
+	// 提交回写之前先将缓冲区中的数据写入文件
+	assert.Nil(t, bio.SyncRange(0, 5))
+	fileSize, err := fio.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(5), fileSize)
+	assert.Equal(t, fio, bio.Unwrap())
+	assert.Nil(t, bio.Close())
+}
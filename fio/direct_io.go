@@ -0,0 +1,142 @@
+package fio
+
+import (
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+// directIOAlignSize O_DIRECT 要求读写的内存地址、文件偏移和长度按块对齐
+const directIOAlignSize = 4096
+
+var errDirectIOUnsupported = errors.New("direct io is not supported on this platform")
+
+// DirectIO 使用 O_DIRECT 的文件 IO，读写绕过操作系统的页缓存
+// 追加写入时最后一个不完整的块补零后写入文件，关闭时截断为实际的数据大小
+type DirectIO struct {
+	fd   *os.File
+	size int64  // 实际写入的数据大小
+	tail []byte // 最后一个不完整的块中的数据，下次写入时和新的数据一起按块写入
+	lock *sync.RWMutex
+}
+
+// NewDirectIOManager 初始化 Direct IO，当前平台或者文件系统不支持 O_DIRECT 时回退到标准文件 IO
+func NewDirectIOManager(fileName string) (IOManager, error) {
+	fd, err := openDirectFile(fileName)
+	if err != nil {
+		if errors.Is(err, syscall.EINVAL) || errors.Is(err, errDirectIOUnsupported) {
+			return NewFileIOManager(fileName)
+		}
+		return nil, err
+	}
+	stat, err := fd.Stat()
+	if err != nil {
+		_ = fd.Close()
+		return nil, err
+	}
+	dio := &DirectIO{fd: fd, size: stat.Size(), lock: new(sync.RWMutex)}
+	// 读出最后一个不完整的块
+	if rem := dio.size % directIOAlignSize; rem > 0 {
+		buf := alignedBlock(directIOAlignSize)
+		n, err := fd.ReadAt(buf, dio.size-rem)
+		if err != nil && err != io.EOF {
+			_ = fd.Close()
+			return nil, err
+		}
+		if int64(n) < rem {
+			_ = fd.Close()
+			return nil, io.ErrUnexpectedEOF
+		}
+		dio.tail = append(make([]byte, 0, directIOAlignSize), buf[:rem]...)
+	}
+	return dio, nil
+}
+
+// Read 从文件的给定位置读取对应的数据
+func (dio *DirectIO) Read(b []byte, offset int64) (int, error) {
+	dio.lock.RLock()
+	defer dio.lock.RUnlock()
+	if offset >= dio.size {
+		return 0, io.EOF
+	}
+	end := offset + int64(len(b))
+	if end > dio.size {
+		end = dio.size
+	}
+	alignedStart := offset &^ (directIOAlignSize - 1)
+	buf := alignedBlock(int(alignUp(end - alignedStart)))
+	n, err := dio.fd.ReadAt(buf, alignedStart)
+	if err != nil && err != io.EOF {
+		return 0, err
+	}
+	if int64(n) < end-alignedStart {
+		return 0, io.ErrUnexpectedEOF
+	}
+	copied := copy(b, buf[offset-alignedStart:end-alignedStart])
+	if copied < len(b) {
+		return copied, io.EOF
+	}
+	return copied, nil
+}
+
+// Write 追加写入字节数组，和最后一个不完整的块一起按块写入文件
+func (dio *DirectIO) Write(b []byte) (int, error) {
+	dio.lock.Lock()
+	defer dio.lock.Unlock()
+	total := len(dio.tail) + len(b)
+	buf := alignedBlock(int(alignUp(int64(total))))
+	copy(buf, dio.tail)
+	copy(buf[len(dio.tail):], b)
+	// 写入失败时不更新数据大小，下次写入会覆盖这个位置
+	if _, err := dio.fd.WriteAt(buf, dio.size-int64(len(dio.tail))); err != nil {
+		return 0, err
+	}
+	dio.size += int64(len(b))
+	rem := total % directIOAlignSize
+	if dio.tail == nil {
+		dio.tail = make([]byte, 0, directIOAlignSize)
+	}
+	dio.tail = append(dio.tail[:0], buf[total-rem:total]...)
+	return len(b), nil
+}
+
+// Sync 持久化到磁盘中
+func (dio *DirectIO) Sync() error {
+	return dio.fd.Sync()
+}
+
+// Close 截断块对齐时补的零，并关闭文件
+func (dio *DirectIO) Close() error {
+	dio.lock.Lock()
+	defer dio.lock.Unlock()
+	if err := dio.fd.Truncate(dio.size); err != nil {
+		_ = dio.fd.Close()
+		return err
+	}
+	return dio.fd.Close()
+}
+
+// Size 获取到实际写入的数据大小
+func (dio *DirectIO) Size() (int64, error) {
+	dio.lock.RLock()
+	defer dio.lock.RUnlock()
+	return dio.size, nil
+}
+
+// alignUp 向上对齐到块大小
+func alignUp(n int64) int64 {
+	return (n + directIOAlignSize - 1) &^ (directIOAlignSize - 1)
+}
+
+// alignedBlock 分配内存地址按块对齐的缓冲区
+func alignedBlock(size int) []byte {
+	buf := make([]byte, size+directIOAlignSize)
+	offset := int(uintptr(unsafe.Pointer(&buf[0])) & (directIOAlignSize - 1))
+	if offset != 0 {
+		offset = directIOAlignSize - offset
+	}
+	return buf[offset : offset+size]
+}
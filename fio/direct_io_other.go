@@ -0,0 +1,10 @@
+//go:build !linux
+
+package fio
+
+import "os"
+
+// openDirectFile 当前平台不支持 O_DIRECT
+func openDirectFile(string) (*os.File, error) {
+	return nil, errDirectIOUnsupported
+}
@@ -0,0 +1,117 @@
+package fio
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDirectIO_Write(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "direct-io-a.data")
+	defer destoryFile(path)
+
+	dio, err := NewDirectIOManager(path)
+	assert.Nil(t, err)
+
+	_, err = dio.Write([]byte("key-a"))
+	assert.Nil(t, err)
+	// 跨越多个块的数据
+	value := make([]byte, directIOAlignSize*2+100)
+	for i := range value {
+		value[i] = byte(i)
+	}
+	_, err = dio.Write(value)
+	assert.Nil(t, err)
+	_, err = dio.Write([]byte("key-b"))
+	assert.Nil(t, err)
+	size, err := dio.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(len(value)+10), size)
+	assert.Nil(t, dio.Sync())
+
+	b := make([]byte, len(value))
+	n, err := dio.Read(b, 5)
+	assert.Nil(t, err)
+	assert.Equal(t, len(value), n)
+	assert.Equal(t, value, b)
+
+	b2 := make([]byte, 10)
+	n, err = dio.Read(b2, size-5)
+	assert.Equal(t, 5, n)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, []byte("key-b"), b2[:n])
+
+	// 关闭时截断为实际的数据大小
+	assert.Nil(t, dio.Close())
+	stat, err := os.Stat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, size, stat.Size())
+
+	// 重新打开之后继续追加写入
+	dio2, err := NewDirectIOManager(path)
+	assert.Nil(t, err)
+	_, err = dio2.Write([]byte("key-c"))
+	assert.Nil(t, err)
+	n, err = dio2.Read(b2, size-5)
+	assert.Nil(t, err)
+	assert.Equal(t, 10, n)
+	assert.Equal(t, []byte("key-bkey-c"), b2)
+	assert.Nil(t, dio2.Close())
+}
+
+func benchmarkWrite(b *testing.B, ioType FileIOType) {
+	path := filepath.Join(os.TempDir(), "bench-write.data")
+	defer destoryFile(path)
+	ioManager, err := NewIOManager(path, ioType)
+	assert.Nil(b, err)
+	defer ioManager.Close()
+
+	value := make([]byte, 4096)
+	b.SetBytes(int64(len(value)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := ioManager.Write(value); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func benchmarkRead(b *testing.B, ioType FileIOType) {
+	path := filepath.Join(os.TempDir(), "bench-read.data")
+	defer destoryFile(path)
+	ioManager, err := NewIOManager(path, ioType)
+	assert.Nil(b, err)
+	defer ioManager.Close()
+
+	value := make([]byte, 4096)
+	const blocks = 1024
+	for i := 0; i < blocks; i++ {
+		_, err := ioManager.Write(value)
+		assert.Nil(b, err)
+	}
+	b.SetBytes(int64(len(value)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := ioManager.Read(value, int64(i%blocks)*int64(len(value))); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkFileIO_Write(b *testing.B) {
+	benchmarkWrite(b, StandardFIO)
+}
+
+func BenchmarkDirectIO_Write(b *testing.B) {
+	benchmarkWrite(b, DirectFIO)
+}
+
+func BenchmarkFileIO_Read(b *testing.B) {
+	benchmarkRead(b, StandardFIO)
+}
+
+func BenchmarkDirectIO_Read(b *testing.B) {
+	benchmarkRead(b, DirectFIO)
+}
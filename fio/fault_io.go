@@ -0,0 +1,231 @@
+package fio
+
+import (
+	"errors"
+	"path/filepath"
+	"sync"
+)
+
+var ErrInjectedFault = errors.New("injected io fault")
+
+// FaultOp 可以注入故障的 IO 操作
+type FaultOp = byte
+
+const (
+	FaultRead FaultOp = iota
+	FaultWrite
+	FaultSync
+	FaultSize
+)
+
+// FaultKind 注入的故障类型
+type FaultKind = byte
+
+const (
+	// FaultError 不执行操作，直接返回错误
+	FaultError FaultKind = iota
+	// FaultShortWrite 只写入一半的数据，并返回错误，只对 Write 有效
+	FaultShortWrite
+	// FaultCorrupt 读取或写入的数据中有一个字节被修改，操作本身返回成功，只对 Read 和 Write 有效
+	FaultCorrupt
+)
+
+// Fault 故障规则，匹配的文件第 N 次执行 Op 操作时注入故障，只会触发一次
+type Fault struct {
+	Op      FaultOp
+	Kind    FaultKind
+	N       int    // 从注入规则之后开始计数，从 1 开始
+	Pattern string // 匹配文件名（不包含目录）的通配符，为空时匹配所有文件
+	Err     error  // 返回的错误，为空时返回 ErrInjectedFault
+}
+
+// FaultInjector 管理故障规则，多个 FaultIO 可以共享同一个 FaultInjector
+type FaultInjector struct {
+	faults []*faultState
+	mu     *sync.Mutex
+}
+
+type faultState struct {
+	fault     Fault
+	count     int
+	triggered bool
+}
+
+// NewFaultInjector 初始化 FaultInjector
+func NewFaultInjector() *FaultInjector {
+	return &FaultInjector{mu: new(sync.Mutex)}
+}
+
+// Inject 添加一条故障规则
+func (fi *FaultInjector) Inject(fault Fault) {
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+	fi.faults = append(fi.faults, &faultState{fault: fault})
+}
+
+// Clear 清除所有的故障规则
+func (fi *FaultInjector) Clear() {
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+	fi.faults = nil
+}
+
+// Triggered 已经触发的故障规则数量
+func (fi *FaultInjector) Triggered() int {
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+	var n int
+	for _, state := range fi.faults {
+		if state.triggered {
+			n++
+		}
+	}
+	return n
+}
+
+// check 记录一次操作，返回需要注入的故障
+func (fi *FaultInjector) check(op FaultOp, fileName string) *Fault {
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+	var matched *Fault
+	for _, state := range fi.faults {
+		if state.triggered || state.fault.Op != op {
+			continue
+		}
+		if state.fault.Pattern != "" {
+			if ok, err := filepath.Match(state.fault.Pattern, filepath.Base(fileName)); err != nil || !ok {
+				continue
+			}
+		}
+		state.count++
+		if state.count == state.fault.N && matched == nil {
+			state.triggered = true
+			matched = &state.fault
+		}
+	}
+	return matched
+}
+
+// FaultIO 可以注入故障的 IO，用于测试 IO 出错时的处理逻辑
+type FaultIO struct {
+	ioManager IOManager
+	fileName  string
+	injector  *FaultInjector
+}
+
+// NewFaultIO 在已有的 IOManager 之上初始化可以注入故障的 IO
+func NewFaultIO(ioManager IOManager, fileName string, injector *FaultInjector) *FaultIO {
+	return &FaultIO{ioManager: ioManager, fileName: fileName, injector: injector}
+}
+
+// Read 从文件的给定位置读取对应的数据
+func (f *FaultIO) Read(b []byte, offset int64) (int, error) {
+	fault := f.injector.check(FaultRead, f.fileName)
+	if fault == nil {
+		return f.ioManager.Read(b, offset)
+	}
+	if fault.Kind != FaultCorrupt {
+		return 0, fault.error()
+	}
+	n, err := f.ioManager.Read(b, offset)
+	if n > 0 {
+		b[n/2] ^= 0xff
+	}
+	return n, err
+}
+
+// Write 写入字节数组到文件中
+func (f *FaultIO) Write(b []byte) (int, error) {
+	fault := f.injector.check(FaultWrite, f.fileName)
+	if fault == nil {
+		return f.ioManager.Write(b)
+	}
+	switch fault.Kind {
+	case FaultShortWrite:
+		n, err := f.ioManager.Write(b[:len(b)/2])
+		if err != nil {
+			return n, err
+		}
+		return n, fault.error()
+	case FaultCorrupt:
+		buf := make([]byte, len(b))
+		copy(buf, b)
+		if len(buf) > 0 {
+			buf[len(buf)/2] ^= 0xff
+		}
+		return f.ioManager.Write(buf)
+	default:
+		return 0, fault.error()
+	}
+}
+
+// Sync 从内存缓冲区的数据持久化到磁盘中
+func (f *FaultIO) Sync() error {
+	if fault := f.injector.check(FaultSync, f.fileName); fault != nil {
+		return fault.error()
+	}
+	return f.ioManager.Sync()
+}
+
+// SyncRange 底层的 IO 支持时提交部分范围回写
+func (f *FaultIO) SyncRange(offset, n int64) error {
+	if rs, ok := f.ioManager.(RangeSyncer); ok {
+		return rs.SyncRange(offset, n)
+	}
+	return nil
+}
+
+// Close 结束读写操作
+func (f *FaultIO) Close() error {
+	return f.ioManager.Close()
+}
+
+// Size 获取到文件大小
+func (f *FaultIO) Size() (int64, error) {
+	if fault := f.injector.check(FaultSize, f.fileName); fault != nil {
+		return 0, fault.error()
+	}
+	return f.ioManager.Size()
+}
+
+func (f *Fault) error() error {
+	if f.Err != nil {
+		return f.Err
+	}
+	return ErrInjectedFault
+}
+
+// FaultFileSystem 可以注入故障的文件系统，打开的文件都使用 FaultIO
+type FaultFileSystem struct {
+	FileSystem
+	injector *FaultInjector
+}
+
+// NewFaultFileSystem 在已有的文件系统之上初始化可以注入故障的文件系统
+func NewFaultFileSystem(fs FileSystem, injector *FaultInjector) *FaultFileSystem {
+	return &FaultFileSystem{FileSystem: fs, injector: injector}
+}
+
+func (ffs *FaultFileSystem) OpenIOManager(fileName string, ioType FileIOType) (IOManager, error) {
+	ioManager, err := ffs.FileSystem.OpenIOManager(fileName, ioType)
+	if err != nil {
+		return nil, err
+	}
+	return NewFaultIO(ioManager, fileName, ffs.injector), nil
+}
+
+func (ffs *FaultFileSystem) OpenPreallocIO(fileName string, capacity int64) (IOManager, error) {
+	ioManager, err := ffs.FileSystem.OpenPreallocIO(fileName, capacity)
+	if err != nil {
+		return nil, err
+	}
+	return NewFaultIO(ioManager, fileName, ffs.injector), nil
+}
+
+func (ffs *FaultFileSystem) OpenMMapRW(fileName string, capacity int64) (IOManager, error) {
+	ioManager, err := ffs.FileSystem.OpenMMapRW(fileName, capacity)
+	if err != nil {
+		return nil, err
+	}
+	return NewFaultIO(ioManager, fileName, ffs.injector), nil
+}
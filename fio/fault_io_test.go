@@ -0,0 +1,73 @@
+package fio
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestFaultIO_Write(t *testing.T) {
+	injector := NewFaultInjector()
+	memFS := NewFaultFileSystem(NewMemFileSystem(), injector)
+	ioManager, err := memFS.OpenIOManager("/bitcask/a.data", StandardFIO)
+	assert.Nil(t, err)
+
+	injector.Inject(Fault{Op: FaultWrite, Kind: FaultError, N: 2})
+	injector.Inject(Fault{Op: FaultWrite, Kind: FaultShortWrite, N: 3})
+	_, err = ioManager.Write([]byte("key-a"))
+	assert.Nil(t, err)
+	n, err := ioManager.Write([]byte("key-b"))
+	assert.Equal(t, 0, n)
+	assert.Equal(t, ErrInjectedFault, err)
+	n, err = ioManager.Write([]byte("key-c"))
+	assert.Equal(t, 2, n)
+	assert.Equal(t, ErrInjectedFault, err)
+	assert.Equal(t, 2, injector.Triggered())
+
+	// 规则只触发一次
+	_, err = ioManager.Write([]byte("key-d"))
+	assert.Nil(t, err)
+	size, err := ioManager.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(12), size)
+
+	injector.Inject(Fault{Op: FaultWrite, Kind: FaultCorrupt, N: 1})
+	_, err = ioManager.Write([]byte("key-e"))
+	assert.Nil(t, err)
+	b := make([]byte, 5)
+	_, err = ioManager.Read(b, 12)
+	assert.Nil(t, err)
+	assert.NotEqual(t, []byte("key-e"), b)
+}
+
+func TestFaultIO_ReadSyncSize(t *testing.T) {
+	injector := NewFaultInjector()
+	memFS := NewFaultFileSystem(NewMemFileSystem(), injector)
+	dataIO, err := memFS.OpenIOManager("/bitcask/a.data", StandardFIO)
+	assert.Nil(t, err)
+	hintIO, err := memFS.OpenIOManager("/bitcask/a.hint", StandardFIO)
+	assert.Nil(t, err)
+	_, err = dataIO.Write([]byte("key-a"))
+	assert.Nil(t, err)
+
+	// 只匹配 hint 文件
+	errEIO := errors.New("input/output error")
+	injector.Inject(Fault{Op: FaultSync, Kind: FaultError, N: 1, Pattern: "*.hint", Err: errEIO})
+	assert.Nil(t, dataIO.Sync())
+	assert.Equal(t, errEIO, hintIO.Sync())
+
+	injector.Inject(Fault{Op: FaultSize, Kind: FaultError, N: 1})
+	_, err = dataIO.Size()
+	assert.Equal(t, ErrInjectedFault, err)
+
+	injector.Inject(Fault{Op: FaultRead, Kind: FaultCorrupt, N: 1})
+	b := make([]byte, 5)
+	_, err = dataIO.Read(b, 0)
+	assert.Nil(t, err)
+	assert.NotEqual(t, []byte("key-a"), b)
+
+	injector.Clear()
+	_, err = dataIO.Read(b, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("key-a"), b)
+}
@@ -28,6 +28,10 @@ func (f *FileIO) Sync() error {
 	return f.fd.Sync()
 }
 
+func (f *FileIO) SyncRange(offset, n int64) error {
+	return syncFileRange(f.fd, offset, n)
+}
+
 func (f *FileIO) Close() error {
 	return f.fd.Close()
 }
@@ -0,0 +1,223 @@
+package fio
+
+import (
+	"container/list"
+	"errors"
+	"sync"
+)
+
+var ErrPooledIOClosed = errors.New("the pooled io manager is closed")
+
+// FilePool 文件句柄池，限制同时打开的文件数量，超出时关闭最近最少使用的文件
+// 固定（Pin）的文件和正在读写的文件不会被关闭
+type FilePool struct {
+	capacity int
+	lru      *list.List // 已经打开并且可以被关闭的文件，最近使用的在前面
+	lock     *sync.Mutex
+}
+
+// NewFilePool 初始化文件句柄池，capacity 为最多同时打开的文件数量
+func NewFilePool(capacity int) *FilePool {
+	return &FilePool{
+		capacity: capacity,
+		lru:      list.New(),
+		lock:     new(sync.Mutex),
+	}
+}
+
+// Open 获取由句柄池管理的文件，读写时才会真正打开文件
+func (p *FilePool) Open(fs FileSystem, fileName string, ioType FileIOType) *PooledIO {
+	return &PooledIO{pool: p, fs: fs, fileName: fileName, ioType: ioType}
+}
+
+// Pin 将已经打开的文件交给句柄池管理，Unpin 之前不会被关闭
+// 文件被关闭之后再次读写时使用 ioType 重新打开
+func (p *FilePool) Pin(ioManager IOManager, fs FileSystem, fileName string, ioType FileIOType) *PooledIO {
+	return &PooledIO{pool: p, fs: fs, fileName: fileName, ioType: ioType, ioManager: ioManager, pinned: true}
+}
+
+// Len 当前打开的可以被关闭的文件数量
+func (p *FilePool) Len() int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.lru.Len()
+}
+
+// evict 关闭超出容量的最近最少使用的文件，调用时需要持有锁
+func (p *FilePool) evict() {
+	for elem := p.lru.Back(); elem != nil && p.lru.Len() > p.capacity; {
+		pio := elem.Value.(*PooledIO)
+		prev := elem.Prev()
+		if pio.refs == 0 {
+			p.lru.Remove(elem)
+			pio.elem = nil
+			_ = pio.ioManager.Close()
+			pio.ioManager = nil
+		}
+		elem = prev
+	}
+}
+
+// PooledIO 由句柄池管理的 IO，文件可能被句柄池关闭，再次读写时重新打开
+type PooledIO struct {
+	pool      *FilePool
+	fs        FileSystem
+	fileName  string
+	ioType    FileIOType
+	ioManager IOManager     // 为空表示文件当前没有打开
+	elem      *list.Element // 在句柄池 lru 中的位置
+	refs      int           // 正在进行的读写操作数量
+	pinned    bool
+	closed    bool
+}
+
+// Read 从文件的给定位置读取对应的数据
+func (pio *PooledIO) Read(b []byte, offset int64) (int, error) {
+	ioManager, err := pio.acquire()
+	if err != nil {
+		return 0, err
+	}
+	defer pio.release()
+	return ioManager.Read(b, offset)
+}
+
+// Write 写入字节数组到文件中
+func (pio *PooledIO) Write(b []byte) (int, error) {
+	ioManager, err := pio.acquire()
+	if err != nil {
+		return 0, err
+	}
+	defer pio.release()
+	return ioManager.Write(b)
+}
+
+// Sync 从内存缓冲区的数据持久化到磁盘中
+func (pio *PooledIO) Sync() error {
+	ioManager, err := pio.acquire()
+	if err != nil {
+		return err
+	}
+	defer pio.release()
+	return ioManager.Sync()
+}
+
+// SyncRange 打开的文件支持时提交部分范围回写
+func (pio *PooledIO) SyncRange(offset, n int64) error {
+	ioManager, err := pio.acquire()
+	if err != nil {
+		return err
+	}
+	defer pio.release()
+	if rs, ok := ioManager.(RangeSyncer); ok {
+		return rs.SyncRange(offset, n)
+	}
+	return nil
+}
+
+// Close 关闭文件，之后不能再读写
+func (pio *PooledIO) Close() error {
+	pio.pool.lock.Lock()
+	defer pio.pool.lock.Unlock()
+	if pio.closed {
+		return nil
+	}
+	pio.closed = true
+	return pio.closeFile()
+}
+
+// Size 获取到文件大小
+func (pio *PooledIO) Size() (int64, error) {
+	ioManager, err := pio.acquire()
+	if err != nil {
+		return 0, err
+	}
+	defer pio.release()
+	return ioManager.Size()
+}
+
+// Unwrap 获取当前打开的 IOManager，只能在固定的文件上使用
+func (pio *PooledIO) Unwrap() IOManager {
+	pio.pool.lock.Lock()
+	defer pio.pool.lock.Unlock()
+	return pio.ioManager
+}
+
+// Unpin 取消固定，之后文件可以被句柄池关闭
+func (pio *PooledIO) Unpin() {
+	pio.pool.lock.Lock()
+	defer pio.pool.lock.Unlock()
+	if !pio.pinned {
+		return
+	}
+	pio.pinned = false
+	if pio.ioManager != nil && !pio.closed {
+		pio.elem = pio.pool.lru.PushFront(pio)
+		pio.pool.evict()
+	}
+}
+
+// Reset 关闭当前打开的文件，之后使用新的 IO 类型重新打开
+func (pio *PooledIO) Reset(ioType FileIOType) error {
+	pio.pool.lock.Lock()
+	defer pio.pool.lock.Unlock()
+	pio.ioType = ioType
+	return pio.closeFile()
+}
+
+// acquire 打开文件并增加引用计数，读写完成之后需要调用 release
+// 打开文件时不持有句柄池的锁，避免一个慢的打开操作阻塞其他文件的读写
+func (pio *PooledIO) acquire() (IOManager, error) {
+	pio.pool.lock.Lock()
+	defer pio.pool.lock.Unlock()
+	for pio.ioManager == nil {
+		if pio.closed {
+			return nil, ErrPooledIOClosed
+		}
+		ioType := pio.ioType
+		pio.pool.lock.Unlock()
+		ioManager, err := pio.fs.OpenIOManager(pio.fileName, ioType)
+		pio.pool.lock.Lock()
+		if err != nil {
+			return nil, err
+		}
+		// 打开期间文件可能已经被其他读写打开、被关闭或者修改了 IO 类型，重新检查之后再使用
+		if pio.ioManager != nil || pio.closed || pio.ioType != ioType {
+			_ = ioManager.Close()
+			continue
+		}
+		pio.ioManager = ioManager
+		if !pio.pinned {
+			pio.elem = pio.pool.lru.PushFront(pio)
+		}
+	}
+	if pio.closed {
+		return nil, ErrPooledIOClosed
+	}
+	if pio.elem != nil {
+		pio.pool.lru.MoveToFront(pio.elem)
+	}
+	pio.refs++
+	pio.pool.evict()
+	return pio.ioManager, nil
+}
+
+func (pio *PooledIO) release() {
+	pio.pool.lock.Lock()
+	defer pio.pool.lock.Unlock()
+	pio.refs--
+	pio.pool.evict()
+}
+
+// closeFile 关闭当前打开的文件，调用时需要持有句柄池的锁
+func (pio *PooledIO) closeFile() error {
+	if pio.elem != nil {
+		pio.pool.lru.Remove(pio.elem)
+		pio.elem = nil
+	}
+	if pio.ioManager == nil {
+		return nil
+	}
+	err := pio.ioManager.Close()
+	pio.ioManager = nil
+	return err
+}
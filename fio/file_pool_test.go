@@ -0,0 +1,121 @@
+package fio
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"path/filepath"
+	"testing"
+)
+
+func TestFilePool_Evict(t *testing.T) {
+	memFS := NewMemFileSystem()
+	pool := NewFilePool(2)
+
+	var files []*PooledIO
+	for i := 0; i < 4; i++ {
+		fileName := filepath.Join("/bitcask", fmt.Sprintf("%09d.data", i))
+		ioManager, err := memFS.OpenIOManager(fileName, StandardFIO)
+		assert.Nil(t, err)
+		_, err = ioManager.Write([]byte(fmt.Sprintf("value-%d", i)))
+		assert.Nil(t, err)
+		files = append(files, pool.Open(memFS, fileName, StandardFIO))
+	}
+	// 读取时才会打开文件
+	assert.Equal(t, 0, pool.Len())
+
+	b := make([]byte, 7)
+	for i, pio := range files {
+		_, err := pio.Read(b, 0)
+		assert.Nil(t, err)
+		assert.Equal(t, []byte(fmt.Sprintf("value-%d", i)), b)
+		assert.True(t, pool.Len() <= 2)
+	}
+	// 最近使用的文件保持打开
+	assert.Nil(t, files[0].ioManager)
+	assert.NotNil(t, files[3].ioManager)
+
+	// 被关闭的文件重新打开
+	_, err := files[0].Read(b, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value-0"), b)
+	assert.Equal(t, 2, pool.Len())
+
+	assert.Nil(t, files[0].Close())
+	assert.Equal(t, 1, pool.Len())
+	_, err = files[0].Read(b, 0)
+	assert.Equal(t, ErrPooledIOClosed, err)
+}
+
+func TestFilePool_Pin(t *testing.T) {
+	memFS := NewMemFileSystem()
+	pool := NewFilePool(1)
+
+	ioManager, err := memFS.OpenIOManager("/bitcask/000000000.data", StandardFIO)
+	assert.Nil(t, err)
+	active := pool.Pin(ioManager, memFS, "/bitcask/000000000.data", StandardFIO)
+	_, err = active.Write([]byte("active"))
+	assert.Nil(t, err)
+
+	older := pool.Open(memFS, "/bitcask/000000000.data", StandardFIO)
+	_, err = older.Size()
+	assert.Nil(t, err)
+	// 固定的文件不占用句柄池的容量
+	assert.Equal(t, 1, pool.Len())
+	assert.Equal(t, ioManager, active.Unwrap())
+
+	active.Unpin()
+	assert.Equal(t, 1, pool.Len())
+	assert.Nil(t, older.ioManager)
+	size, err := active.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(6), size)
+}
+
+// blockingFS 打开 blocked 文件时等待 release 关闭
+type blockingFS struct {
+	FileSystem
+	blocked string
+	opening chan struct{}
+	release chan struct{}
+}
+
+func (fs *blockingFS) OpenIOManager(fileName string, ioType FileIOType) (IOManager, error) {
+	if fileName == fs.blocked {
+		close(fs.opening)
+		<-fs.release
+	}
+	return fs.FileSystem.OpenIOManager(fileName, ioType)
+}
+
+func TestFilePool_SlowOpen(t *testing.T) {
+	memFS := NewMemFileSystem()
+	fs := &blockingFS{FileSystem: memFS, opening: make(chan struct{}), release: make(chan struct{})}
+	pool := NewFilePool(2)
+
+	var files []*PooledIO
+	for i := 0; i < 2; i++ {
+		fileName := filepath.Join("/bitcask", fmt.Sprintf("%09d.data", i))
+		ioManager, err := memFS.OpenIOManager(fileName, StandardFIO)
+		assert.Nil(t, err)
+		_, err = ioManager.Write([]byte(fmt.Sprintf("value-%d", i)))
+		assert.Nil(t, err)
+		files = append(files, pool.Open(fs, fileName, StandardFIO))
+	}
+	fs.blocked = files[0].fileName
+
+	// 一个文件的打开操作没有完成时，其他文件仍然可以读取
+	done := make(chan error, 1)
+	go func() {
+		_, err := files[0].Read(make([]byte, 7), 0)
+		done <- err
+	}()
+	<-fs.opening
+	b := make([]byte, 7)
+	_, err := files[1].Read(b, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value-1"), b)
+
+	close(fs.release)
+	assert.Nil(t, <-done)
+	assert.Equal(t, 2, pool.Len())
+}
@@ -0,0 +1,120 @@
+package fio
+
+import (
+	"bitcask-db/utils"
+	"github.com/gofrs/flock"
+	"os"
+)
+
+// FileSystem 文件系统抽象，数据库对目录和文件的操作都通过该接口进行
+type FileSystem interface {
+	// OpenIOManager 使用给定的 IO 类型打开文件，文件不存在时创建
+	OpenIOManager(fileName string, ioType FileIOType) (IOManager, error)
+	// OpenMMapRW 打开可读写的 MMap 文件，文件预分配到 capacity 大小
+	OpenMMapRW(fileName string, capacity int64) (IOManager, error)
+	// OpenPreallocIO 打开预分配空间的文件，文件预分配到 capacity 大小
+	OpenPreallocIO(fileName string, capacity int64) (IOManager, error)
+	// ReadDir 获取目录下所有文件和子目录的名称，按名称排序
+	ReadDir(dirPath string) ([]string, error)
+	// Stat 获取文件信息，文件不存在时返回的错误满足 os.IsNotExist
+	Stat(name string) (os.FileInfo, error)
+	// MkdirAll 创建目录以及不存在的上级目录
+	MkdirAll(dirPath string) error
+	// Remove 删除文件或者空目录
+	Remove(name string) error
+	// RemoveAll 删除文件或者目录以及目录下的所有文件
+	RemoveAll(path string) error
+	// Rename 重命名文件或者目录
+	Rename(oldPath, newPath string) error
+	// Truncate 将文件截断为给定的大小
+	Truncate(name string, size int64) error
+	// DirSize 获取目录下所有文件的大小
+	DirSize(dirPath string) (int64, error)
+	// AvailableDiskSize 获取剩余可用的空间大小
+	AvailableDiskSize() (uint64, error)
+	// CopyDir 拷贝目录，名称匹配 exclude 的文件不拷贝
+	CopyDir(src, dest string, exclude []string) error
+	// TryLock 尝试获取文件锁，保证多进程之间的互斥，锁已经被持有时返回 false
+	TryLock(name string) (FileLock, bool, error)
+}
+
+// FileLock 已经获取到的文件锁
+type FileLock interface {
+	// Unlock 释放文件锁
+	Unlock() error
+}
+
+// DefaultFileSystem 默认使用操作系统的文件系统
+var DefaultFileSystem FileSystem = OSFileSystem{}
+
+// OSFileSystem 操作系统的文件系统
+type OSFileSystem struct{}
+
+func (OSFileSystem) OpenIOManager(fileName string, ioType FileIOType) (IOManager, error) {
+	return NewIOManager(fileName, ioType)
+}
+
+func (OSFileSystem) OpenMMapRW(fileName string, capacity int64) (IOManager, error) {
+	return NewMMapRWIOManager(fileName, capacity)
+}
+
+func (OSFileSystem) OpenPreallocIO(fileName string, capacity int64) (IOManager, error) {
+	return NewPreallocIOManager(fileName, capacity)
+}
+
+func (OSFileSystem) ReadDir(dirPath string) ([]string, error) {
+	entries, err := os.ReadDir(dirPath)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(entries))
+	for i, entry := range entries {
+		names[i] = entry.Name()
+	}
+	return names, nil
+}
+
+func (OSFileSystem) Stat(name string) (os.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (OSFileSystem) MkdirAll(dirPath string) error {
+	return os.MkdirAll(dirPath, os.ModePerm)
+}
+
+func (OSFileSystem) Remove(name string) error {
+	return os.Remove(name)
+}
+
+func (OSFileSystem) RemoveAll(path string) error {
+	return os.RemoveAll(path)
+}
+
+func (OSFileSystem) Rename(oldPath, newPath string) error {
+	return os.Rename(oldPath, newPath)
+}
+
+func (OSFileSystem) Truncate(name string, size int64) error {
+	return os.Truncate(name, size)
+}
+
+func (OSFileSystem) DirSize(dirPath string) (int64, error) {
+	return utils.DirSize(dirPath)
+}
+
+func (OSFileSystem) AvailableDiskSize() (uint64, error) {
+	return utils.AvailableDiskSize()
+}
+
+func (OSFileSystem) CopyDir(src, dest string, exclude []string) error {
+	return utils.CopyDir(src, dest, exclude)
+}
+
+func (OSFileSystem) TryLock(name string) (FileLock, bool, error) {
+	fileLock := flock.New(name)
+	hold, err := fileLock.TryLock()
+	if err != nil {
+		return nil, false, err
+	}
+	return fileLock, hold, nil
+}
@@ -9,6 +9,8 @@ const (
 	StandardFIO FileIOType = iota
 	// MemoryMap 内存文件映射
 	MemoryMap
+	// DirectFIO 使用 O_DIRECT 的文件 IO，不支持时回退到标准文件 IO
+	DirectFIO
 )
 
 // IOManager 抽象 IO 管理接口，可以接入不同的 IO 类型，目前支持标准 IO
@@ -25,6 +27,12 @@ type IOManager interface {
 	Size() (int64, error)
 }
 
+// RangeSyncer 支持提交文件中部分范围回写的 IO
+type RangeSyncer interface {
+	// SyncRange 提交 [offset, offset+n) 范围内的数据回写，不等待回写完成
+	SyncRange(offset, n int64) error
+}
+
 // 初始化 IOManager 目前只支持标准 FileIO
 func NewIOManager(fileName string, ioType FileIOType) (IOManager, error) {
 	switch ioType {
@@ -32,6 +40,8 @@ func NewIOManager(fileName string, ioType FileIOType) (IOManager, error) {
 		return NewFileIOManager(fileName)
 	case MemoryMap:
 		return NewMMapIOManager(fileName)
+	case DirectFIO:
+		return NewDirectIOManager(fileName)
 	default:
 		panic("unsupported io type")
 	}
@@ -0,0 +1,387 @@
+package fio
+
+import (
+	"io"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// MemFileSystem 内存文件系统，所有的文件都保存在内存中，进程退出后数据丢失
+// 用于测试以及临时的缓存场景
+type MemFileSystem struct {
+	files map[string]*memFile
+	dirs  map[string]time.Time
+	locks map[string]struct{}
+	mu    *sync.RWMutex
+}
+
+// memFile 内存中的文件
+type memFile struct {
+	data    []byte
+	modTime time.Time
+	lock    *sync.RWMutex
+}
+
+// NewMemFileSystem 初始化内存文件系统
+func NewMemFileSystem() *MemFileSystem {
+	return &MemFileSystem{
+		files: make(map[string]*memFile),
+		dirs:  make(map[string]time.Time),
+		locks: make(map[string]struct{}),
+		mu:    new(sync.RWMutex),
+	}
+}
+
+// OpenIOManager 打开内存文件，所有的 IO 类型都直接读写内存
+func (mfs *MemFileSystem) OpenIOManager(fileName string, _ FileIOType) (IOManager, error) {
+	mfs.mu.Lock()
+	defer mfs.mu.Unlock()
+	fileName = filepath.Clean(fileName)
+	if _, ok := mfs.dirs[fileName]; ok {
+		return nil, &fs.PathError{Op: "open", Path: fileName, Err: syscall.EISDIR}
+	}
+	file, ok := mfs.files[fileName]
+	if !ok {
+		file = &memFile{modTime: time.Now(), lock: new(sync.RWMutex)}
+		mfs.files[fileName] = file
+		mfs.mkdirAll(filepath.Dir(fileName))
+	}
+	return &MemIO{file: file}, nil
+}
+
+// OpenMMapRW 内存文件不需要预分配空间
+func (mfs *MemFileSystem) OpenMMapRW(fileName string, _ int64) (IOManager, error) {
+	return mfs.OpenIOManager(fileName, MemoryMap)
+}
+
+// OpenPreallocIO 内存文件不需要预分配空间
+func (mfs *MemFileSystem) OpenPreallocIO(fileName string, _ int64) (IOManager, error) {
+	return mfs.OpenIOManager(fileName, StandardFIO)
+}
+
+func (mfs *MemFileSystem) ReadDir(dirPath string) ([]string, error) {
+	mfs.mu.RLock()
+	defer mfs.mu.RUnlock()
+	dirPath = filepath.Clean(dirPath)
+	if _, ok := mfs.dirs[dirPath]; !ok {
+		return nil, &fs.PathError{Op: "readdir", Path: dirPath, Err: fs.ErrNotExist}
+	}
+	var names []string
+	for name := range mfs.files {
+		if filepath.Dir(name) == dirPath {
+			names = append(names, filepath.Base(name))
+		}
+	}
+	for name := range mfs.dirs {
+		if name != dirPath && filepath.Dir(name) == dirPath {
+			names = append(names, filepath.Base(name))
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (mfs *MemFileSystem) Stat(name string) (os.FileInfo, error) {
+	mfs.mu.RLock()
+	defer mfs.mu.RUnlock()
+	name = filepath.Clean(name)
+	if file, ok := mfs.files[name]; ok {
+		file.lock.RLock()
+		defer file.lock.RUnlock()
+		return &memFileInfo{name: filepath.Base(name), size: int64(len(file.data)), modTime: file.modTime}, nil
+	}
+	if modTime, ok := mfs.dirs[name]; ok {
+		return &memFileInfo{name: filepath.Base(name), modTime: modTime, isDir: true}, nil
+	}
+	return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+}
+
+func (mfs *MemFileSystem) MkdirAll(dirPath string) error {
+	mfs.mu.Lock()
+	defer mfs.mu.Unlock()
+	dirPath = filepath.Clean(dirPath)
+	if _, ok := mfs.files[dirPath]; ok {
+		return &fs.PathError{Op: "mkdir", Path: dirPath, Err: syscall.ENOTDIR}
+	}
+	mfs.mkdirAll(dirPath)
+	return nil
+}
+
+func (mfs *MemFileSystem) Remove(name string) error {
+	mfs.mu.Lock()
+	defer mfs.mu.Unlock()
+	name = filepath.Clean(name)
+	if _, ok := mfs.files[name]; ok {
+		delete(mfs.files, name)
+		return nil
+	}
+	if _, ok := mfs.dirs[name]; !ok {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+	}
+	if len(mfs.children(name)) > 0 {
+		return &fs.PathError{Op: "remove", Path: name, Err: syscall.ENOTEMPTY}
+	}
+	delete(mfs.dirs, name)
+	return nil
+}
+
+func (mfs *MemFileSystem) RemoveAll(path string) error {
+	mfs.mu.Lock()
+	defer mfs.mu.Unlock()
+	path = filepath.Clean(path)
+	for _, name := range mfs.children(path) {
+		delete(mfs.files, name)
+		delete(mfs.dirs, name)
+	}
+	delete(mfs.files, path)
+	delete(mfs.dirs, path)
+	return nil
+}
+
+func (mfs *MemFileSystem) Rename(oldPath, newPath string) error {
+	mfs.mu.Lock()
+	defer mfs.mu.Unlock()
+	oldPath, newPath = filepath.Clean(oldPath), filepath.Clean(newPath)
+	if file, ok := mfs.files[oldPath]; ok {
+		delete(mfs.files, oldPath)
+		mfs.files[newPath] = file
+		mfs.mkdirAll(filepath.Dir(newPath))
+		return nil
+	}
+	if _, ok := mfs.dirs[oldPath]; !ok {
+		return &fs.PathError{Op: "rename", Path: oldPath, Err: fs.ErrNotExist}
+	}
+	// 目录下的文件和子目录一起移动
+	for _, name := range append(mfs.children(oldPath), oldPath) {
+		newName := newPath + strings.TrimPrefix(name, oldPath)
+		if file, ok := mfs.files[name]; ok {
+			delete(mfs.files, name)
+			mfs.files[newName] = file
+		} else {
+			modTime := mfs.dirs[name]
+			delete(mfs.dirs, name)
+			mfs.dirs[newName] = modTime
+		}
+	}
+	mfs.mkdirAll(filepath.Dir(newPath))
+	return nil
+}
+
+func (mfs *MemFileSystem) Truncate(name string, size int64) error {
+	mfs.mu.RLock()
+	file, ok := mfs.files[filepath.Clean(name)]
+	mfs.mu.RUnlock()
+	if !ok {
+		return &fs.PathError{Op: "truncate", Path: name, Err: fs.ErrNotExist}
+	}
+	file.lock.Lock()
+	defer file.lock.Unlock()
+	if size <= int64(len(file.data)) {
+		file.data = file.data[:size]
+	} else {
+		file.data = append(file.data, make([]byte, size-int64(len(file.data)))...)
+	}
+	file.modTime = time.Now()
+	return nil
+}
+
+func (mfs *MemFileSystem) DirSize(dirPath string) (int64, error) {
+	mfs.mu.RLock()
+	defer mfs.mu.RUnlock()
+	dirPath = filepath.Clean(dirPath)
+	if _, ok := mfs.dirs[dirPath]; !ok {
+		return 0, &fs.PathError{Op: "lstat", Path: dirPath, Err: fs.ErrNotExist}
+	}
+	var size int64
+	for _, name := range mfs.children(dirPath) {
+		if file, ok := mfs.files[name]; ok {
+			file.lock.RLock()
+			size += int64(len(file.data))
+			file.lock.RUnlock()
+		}
+	}
+	return size, nil
+}
+
+// AvailableDiskSize 内存文件系统不限制可用的空间大小
+func (mfs *MemFileSystem) AvailableDiskSize() (uint64, error) {
+	return math.MaxUint64, nil
+}
+
+func (mfs *MemFileSystem) CopyDir(src, dest string, exclude []string) error {
+	mfs.mu.Lock()
+	defer mfs.mu.Unlock()
+	src, dest = filepath.Clean(src), filepath.Clean(dest)
+	if _, ok := mfs.dirs[src]; !ok {
+		return &fs.PathError{Op: "lstat", Path: src, Err: fs.ErrNotExist}
+	}
+	mfs.mkdirAll(dest)
+	for _, name := range mfs.children(src) {
+		if isExcluded(filepath.Base(name), exclude) {
+			continue
+		}
+		destName := dest + strings.TrimPrefix(name, src)
+		file, ok := mfs.files[name]
+		if !ok {
+			mfs.mkdirAll(destName)
+			continue
+		}
+		file.lock.RLock()
+		buf := make([]byte, len(file.data))
+		copy(buf, file.data)
+		file.lock.RUnlock()
+		mfs.files[destName] = &memFile{data: buf, modTime: time.Now(), lock: new(sync.RWMutex)}
+		mfs.mkdirAll(filepath.Dir(destName))
+	}
+	return nil
+}
+
+func (mfs *MemFileSystem) TryLock(name string) (FileLock, bool, error) {
+	mfs.mu.Lock()
+	defer mfs.mu.Unlock()
+	name = filepath.Clean(name)
+	if _, ok := mfs.locks[name]; ok {
+		return nil, false, nil
+	}
+	mfs.locks[name] = struct{}{}
+	return &memFileLock{fs: mfs, name: name}, true, nil
+}
+
+// mkdirAll 创建目录以及上级目录，调用时需要持有锁
+func (mfs *MemFileSystem) mkdirAll(dirPath string) {
+	for {
+		if _, ok := mfs.dirs[dirPath]; ok {
+			return
+		}
+		mfs.dirs[dirPath] = time.Now()
+		parent := filepath.Dir(dirPath)
+		if parent == dirPath {
+			return
+		}
+		dirPath = parent
+	}
+}
+
+// children 获取目录下所有的文件和子目录，包括多级子目录，调用时需要持有锁
+func (mfs *MemFileSystem) children(dirPath string) []string {
+	prefix := dirPath + string(filepath.Separator)
+	if strings.HasSuffix(dirPath, string(filepath.Separator)) {
+		prefix = dirPath
+	}
+	var names []string
+	for name := range mfs.files {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	for name := range mfs.dirs {
+		if name != dirPath && strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func isExcluded(name string, exclude []string) bool {
+	for _, e := range exclude {
+		if matched, err := filepath.Match(e, name); err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
+
+// memFileLock 内存文件系统中的文件锁
+type memFileLock struct {
+	fs       *MemFileSystem
+	name     string
+	released bool
+}
+
+// Unlock 释放文件锁，重复释放不会影响之后其他地方获取到的锁
+func (l *memFileLock) Unlock() error {
+	l.fs.mu.Lock()
+	defer l.fs.mu.Unlock()
+	if !l.released {
+		delete(l.fs.locks, l.name)
+		l.released = true
+	}
+	return nil
+}
+
+// memFileInfo 内存文件的信息
+type memFileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	isDir   bool
+}
+
+func (fi *memFileInfo) Name() string { return fi.name }
+
+func (fi *memFileInfo) Size() int64 { return fi.size }
+
+func (fi *memFileInfo) Mode() fs.FileMode {
+	if fi.isDir {
+		return fs.ModeDir | os.ModePerm
+	}
+	return DataFilePerm
+}
+
+func (fi *memFileInfo) ModTime() time.Time { return fi.modTime }
+
+func (fi *memFileInfo) IsDir() bool { return fi.isDir }
+
+func (fi *memFileInfo) Sys() any { return nil }
+
+// MemIO 内存文件 IO
+type MemIO struct {
+	file *memFile
+}
+
+// Read 从文件的给定位置读取对应的数据
+func (mio *MemIO) Read(b []byte, offset int64) (int, error) {
+	mio.file.lock.RLock()
+	defer mio.file.lock.RUnlock()
+	if offset >= int64(len(mio.file.data)) {
+		return 0, io.EOF
+	}
+	n := copy(b, mio.file.data[offset:])
+	if n < len(b) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Write 追加写入字节数组到文件中
+func (mio *MemIO) Write(b []byte) (int, error) {
+	mio.file.lock.Lock()
+	defer mio.file.lock.Unlock()
+	mio.file.data = append(mio.file.data, b...)
+	mio.file.modTime = time.Now()
+	return len(b), nil
+}
+
+// Sync 内存文件不需要持久化
+func (mio *MemIO) Sync() error {
+	return nil
+}
+
+// Close 内存文件关闭之后数据仍然保留
+func (mio *MemIO) Close() error {
+	return nil
+}
+
+// Size 获取到文件大小
+func (mio *MemIO) Size() (int64, error) {
+	mio.file.lock.RLock()
+	defer mio.file.lock.RUnlock()
+	return int64(len(mio.file.data)), nil
+}
@@ -0,0 +1,115 @@
+package fio
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMemIO_Read(t *testing.T) {
+	memFS := NewMemFileSystem()
+	ioManager, err := memFS.OpenIOManager("/bitcask/a.data", StandardFIO)
+	assert.Nil(t, err)
+
+	b1 := make([]byte, 5)
+	n1, err := ioManager.Read(b1, 0)
+	assert.Equal(t, 0, n1)
+	assert.Equal(t, io.EOF, err)
+
+	_, err = ioManager.Write([]byte("key-a"))
+	assert.Nil(t, err)
+	_, err = ioManager.Write([]byte("key-b"))
+	assert.Nil(t, err)
+	size, err := ioManager.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(10), size)
+
+	n2, err := ioManager.Read(b1, 5)
+	assert.Nil(t, err)
+	assert.Equal(t, 5, n2)
+	assert.Equal(t, []byte("key-b"), b1)
+
+	n3, err := ioManager.Read(b1, 8)
+	assert.Equal(t, 2, n3)
+	assert.Equal(t, io.EOF, err)
+
+	// 关闭之后重新打开，数据仍然存在
+	assert.Nil(t, ioManager.Close())
+	ioManager2, err := memFS.OpenIOManager("/bitcask/a.data", MemoryMap)
+	assert.Nil(t, err)
+	size, err = ioManager2.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(10), size)
+}
+
+func TestMemFileSystem_Dir(t *testing.T) {
+	memFS := NewMemFileSystem()
+	_, err := memFS.Stat("/bitcask")
+	assert.True(t, os.IsNotExist(err))
+
+	err = memFS.MkdirAll("/bitcask")
+	assert.Nil(t, err)
+	stat, err := memFS.Stat("/bitcask")
+	assert.Nil(t, err)
+	assert.True(t, stat.IsDir())
+
+	for _, name := range []string{"000000001.data", "000000000.data", "flock"} {
+		ioManager, err := memFS.OpenIOManager(filepath.Join("/bitcask", name), StandardFIO)
+		assert.Nil(t, err)
+		_, err = ioManager.Write([]byte("bitcask"))
+		assert.Nil(t, err)
+	}
+	names, err := memFS.ReadDir("/bitcask")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"000000000.data", "000000001.data", "flock"}, names)
+
+	size, err := memFS.DirSize("/bitcask")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(21), size)
+
+	// 非空目录不能直接删除
+	err = memFS.Remove("/bitcask")
+	assert.NotNil(t, err)
+
+	err = memFS.Truncate("/bitcask/flock", 2)
+	assert.Nil(t, err)
+	stat, err = memFS.Stat("/bitcask/flock")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), stat.Size())
+
+	err = memFS.Rename("/bitcask/000000001.data", "/bitcask/000000002.data")
+	assert.Nil(t, err)
+	_, err = memFS.Stat("/bitcask/000000001.data")
+	assert.True(t, os.IsNotExist(err))
+
+	err = memFS.CopyDir("/bitcask", "/bitcask-backup", []string{"flock"})
+	assert.Nil(t, err)
+	names, err = memFS.ReadDir("/bitcask-backup")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"000000000.data", "000000002.data"}, names)
+
+	err = memFS.RemoveAll("/bitcask")
+	assert.Nil(t, err)
+	_, err = memFS.Stat("/bitcask/000000000.data")
+	assert.True(t, os.IsNotExist(err))
+	_, err = memFS.ReadDir("/bitcask")
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestMemFileSystem_TryLock(t *testing.T) {
+	memFS := NewMemFileSystem()
+	lock, hold, err := memFS.TryLock("/bitcask/flock")
+	assert.Nil(t, err)
+	assert.True(t, hold)
+
+	_, hold, err = memFS.TryLock("/bitcask/flock")
+	assert.Nil(t, err)
+	assert.False(t, hold)
+
+	assert.Nil(t, lock.Unlock())
+	_, hold, err = memFS.TryLock("/bitcask/flock")
+	assert.Nil(t, err)
+	assert.True(t, hold)
+}
@@ -0,0 +1,190 @@
+package fio
+
+import (
+	"errors"
+	"golang.org/x/sys/unix"
+	"io"
+	"os"
+	"sync"
+)
+
+var (
+	ErrMMapClosed   = errors.New("the mmap io manager is closed")
+	ErrMMapReadOnly = errors.New("the mmap io manager is read only")
+)
+
+// MMapRW 可读写的内存文件映射，文件预分配到指定的容量，追加写入只需要一次内存拷贝
+// 文件关闭时截断为实际写入的数据大小
+type MMapRW struct {
+	fd     *os.File
+	data   []byte        // 映射的内存区域，长度即为文件的容量
+	size   int64         // 实际写入的数据大小
+	shrunk bool          // 文件已经截断为实际的数据大小，不能再写入
+	lock   *sync.RWMutex // 扩容时会重新映射，需要和读取互斥
+}
+
+// NewMMapRWIOManager 初始化可读写的 MMap IO，capacity 为预分配的文件大小
+func NewMMapRWIOManager(fileName string, capacity int64) (*MMapRW, error) {
+	fd, err := os.OpenFile(fileName, os.O_CREATE|os.O_RDWR, DataFilePerm)
+	if err != nil {
+		return nil, err
+	}
+	stat, err := fd.Stat()
+	if err != nil {
+		_ = fd.Close()
+		return nil, err
+	}
+	size := stat.Size()
+	if capacity < size {
+		capacity = size
+	}
+	if capacity <= 0 {
+		_ = fd.Close()
+		return nil, errors.New("mmap capacity must be greater than 0")
+	}
+	if err := fd.Truncate(capacity); err != nil {
+		_ = fd.Close()
+		return nil, err
+	}
+	data, err := unix.Mmap(int(fd.Fd()), 0, int(capacity), unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED)
+	if err != nil {
+		_ = fd.Close()
+		return nil, err
+	}
+	return &MMapRW{fd: fd, data: data, size: size, lock: new(sync.RWMutex)}, nil
+}
+
+// Read 从文件的给定位置读取对应的数据
+func (mm *MMapRW) Read(b []byte, offset int64) (int, error) {
+	mm.lock.RLock()
+	defer mm.lock.RUnlock()
+	if mm.data == nil {
+		return 0, ErrMMapClosed
+	}
+	if offset >= mm.size {
+		return 0, io.EOF
+	}
+	n := copy(b, mm.data[offset:mm.size])
+	if n < len(b) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Write 追加写入字节数组，容量不足时扩容并重新映射
+func (mm *MMapRW) Write(b []byte) (int, error) {
+	mm.lock.Lock()
+	defer mm.lock.Unlock()
+	if mm.data == nil {
+		return 0, ErrMMapClosed
+	}
+	if mm.shrunk {
+		return 0, ErrMMapReadOnly
+	}
+	if need := mm.size + int64(len(b)); need > int64(len(mm.data)) {
+		capacity := int64(len(mm.data)) * 2
+		if capacity < need {
+			capacity = need
+		}
+		if err := mm.remap(capacity); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(mm.data[mm.size:], b)
+	mm.size += int64(n)
+	return n, nil
+}
+
+// Sync 将映射区域中的数据持久化到磁盘中
+func (mm *MMapRW) Sync() error {
+	mm.lock.RLock()
+	defer mm.lock.RUnlock()
+	if mm.data == nil {
+		return ErrMMapClosed
+	}
+	return mm.msync()
+}
+
+// Close 持久化数据，解除映射并将文件截断为实际的数据大小
+func (mm *MMapRW) Close() error {
+	mm.lock.Lock()
+	defer mm.lock.Unlock()
+	if mm.data == nil {
+		return nil
+	}
+	if err := mm.msync(); err != nil {
+		return err
+	}
+	if err := unix.Munmap(mm.data); err != nil {
+		return err
+	}
+	mm.data = nil
+	if err := mm.fd.Truncate(mm.size); err != nil {
+		_ = mm.fd.Close()
+		return err
+	}
+	return mm.fd.Close()
+}
+
+// Size 获取到实际写入的数据大小
+func (mm *MMapRW) Size() (int64, error) {
+	mm.lock.RLock()
+	defer mm.lock.RUnlock()
+	return mm.size, nil
+}
+
+// SetSize 设置实际的数据大小，之后从这个位置继续追加写入
+// 预分配的文件异常退出后，末尾会有未写入的空白区域，加载数据文件之后需要重新设置
+func (mm *MMapRW) SetSize(size int64) error {
+	mm.lock.Lock()
+	defer mm.lock.Unlock()
+	if size < 0 || size > int64(len(mm.data)) {
+		return errors.New("invalid mmap data size")
+	}
+	mm.size = size
+	return nil
+}
+
+// Shrink 将文件截断为实际的数据大小，映射的内存保留用于读取
+// 用于活跃文件写满转换为旧的数据文件时，之后不能再写入
+func (mm *MMapRW) Shrink() error {
+	mm.lock.Lock()
+	defer mm.lock.Unlock()
+	if mm.data == nil {
+		return ErrMMapClosed
+	}
+	if err := mm.msync(); err != nil {
+		return err
+	}
+	// 截断之后超出文件大小的内存不能再访问，读取时只会访问实际写入的数据
+	if err := mm.fd.Truncate(mm.size); err != nil {
+		return err
+	}
+	mm.shrunk = true
+	return nil
+}
+
+// msync 将实际写入的数据刷到磁盘中
+func (mm *MMapRW) msync() error {
+	if mm.size == 0 {
+		return nil
+	}
+	return unix.Msync(mm.data[:mm.size], unix.MS_SYNC)
+}
+
+// remap 将文件扩容到新的容量并重新映射
+func (mm *MMapRW) remap(capacity int64) error {
+	if err := unix.Munmap(mm.data); err != nil {
+		return err
+	}
+	mm.data = nil
+	if err := mm.fd.Truncate(capacity); err != nil {
+		return err
+	}
+	data, err := unix.Mmap(int(mm.fd.Fd()), 0, int(capacity), unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED)
+	if err != nil {
+		return err
+	}
+	mm.data = data
+	return nil
+}
@@ -0,0 +1,97 @@
+package fio
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMMapRW_Write(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "mmap-rw-a.data")
+	defer destoryFile(path)
+
+	mmapIO, err := NewMMapRWIOManager(path, 16)
+	assert.Nil(t, err)
+
+	// 文件预分配到指定的容量
+	stat, err := os.Stat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(16), stat.Size())
+
+	_, err = mmapIO.Write([]byte("key-a"))
+	assert.Nil(t, err)
+	_, err = mmapIO.Write([]byte("key-b"))
+	assert.Nil(t, err)
+	size, err := mmapIO.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(10), size)
+
+	// 超出容量时扩容
+	_, err = mmapIO.Write([]byte("bitcask kv store"))
+	assert.Nil(t, err)
+	size, err = mmapIO.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(26), size)
+
+	b := make([]byte, 16)
+	n, err := mmapIO.Read(b, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, 16, n)
+	assert.Equal(t, []byte("bitcask kv store"), b)
+
+	err = mmapIO.Sync()
+	assert.Nil(t, err)
+
+	// 关闭时截断为实际的数据大小
+	err = mmapIO.Close()
+	assert.Nil(t, err)
+	stat, err = os.Stat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(26), stat.Size())
+}
+
+func TestMMapRW_Read(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "mmap-rw-b.data")
+	defer destoryFile(path)
+
+	fio, err := NewFileIOManager(path)
+	assert.Nil(t, err)
+	_, err = fio.Write([]byte("aabbcc"))
+	assert.Nil(t, err)
+	assert.Nil(t, fio.Close())
+
+	mmapIO, err := NewMMapRWIOManager(path, 64)
+	assert.Nil(t, err)
+	defer mmapIO.Close()
+
+	b1 := make([]byte, 4)
+	n1, err := mmapIO.Read(b1, 4)
+	assert.Equal(t, 2, n1)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, []byte("cc"), b1[:n1])
+
+	// 重新设置数据大小后从该位置追加写入
+	err = mmapIO.SetSize(4)
+	assert.Nil(t, err)
+	_, err = mmapIO.Write([]byte("dd"))
+	assert.Nil(t, err)
+	n2, err := mmapIO.Read(b1, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, 4, n2)
+	assert.Equal(t, []byte("bbdd"), b1)
+
+	// 截断之后可以读取，不能再写入
+	err = mmapIO.Shrink()
+	assert.Nil(t, err)
+	stat, err := os.Stat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(6), stat.Size())
+	n3, err := mmapIO.Read(b1, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 4, n3)
+	assert.Equal(t, []byte("aabb"), b1)
+	_, err = mmapIO.Write([]byte("ee"))
+	assert.Equal(t, ErrMMapReadOnly, err)
+}
@@ -0,0 +1,122 @@
+package fio
+
+import (
+	"errors"
+	"io"
+	"os"
+	"sync"
+)
+
+var ErrPreallocIOReadOnly = errors.New("the prealloc io manager is read only")
+
+// PreallocIO 预分配磁盘空间的文件 IO，追加写入时不需要扩展文件大小，持久化时不需要更新文件元数据
+// 文件末尾未写入的区域全部为零，关闭时截断为实际写入的数据大小
+type PreallocIO struct {
+	fd     *os.File
+	size   int64 // 实际写入的数据大小
+	shrunk bool  // 文件已经截断为实际的数据大小，不能再写入
+	lock   *sync.RWMutex
+}
+
+// NewPreallocIOManager 初始化预分配空间的文件 IO，capacity 为预分配的文件大小
+func NewPreallocIOManager(fileName string, capacity int64) (*PreallocIO, error) {
+	fd, err := os.OpenFile(fileName, os.O_CREATE|os.O_RDWR, DataFilePerm)
+	if err != nil {
+		return nil, err
+	}
+	stat, err := fd.Stat()
+	if err != nil {
+		_ = fd.Close()
+		return nil, err
+	}
+	size := stat.Size()
+	if capacity > size {
+		if err := fallocate(fd, capacity); err != nil {
+			_ = fd.Close()
+			return nil, err
+		}
+	}
+	return &PreallocIO{fd: fd, size: size, lock: new(sync.RWMutex)}, nil
+}
+
+// Read 从文件的给定位置读取对应的数据，不会读取到预分配的空白区域
+func (pio *PreallocIO) Read(b []byte, offset int64) (int, error) {
+	pio.lock.RLock()
+	defer pio.lock.RUnlock()
+	if offset >= pio.size {
+		return 0, io.EOF
+	}
+	buf := b
+	if offset+int64(len(b)) > pio.size {
+		buf = b[:pio.size-offset]
+	}
+	n, err := pio.fd.ReadAt(buf, offset)
+	if err == nil && n < len(b) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// Write 从实际的数据大小处追加写入，超出预分配的容量时文件自动扩展
+func (pio *PreallocIO) Write(b []byte) (int, error) {
+	pio.lock.Lock()
+	defer pio.lock.Unlock()
+	if pio.shrunk {
+		return 0, ErrPreallocIOReadOnly
+	}
+	n, err := pio.fd.WriteAt(b, pio.size)
+	pio.size += int64(n)
+	return n, err
+}
+
+// Sync 持久化到磁盘中
+func (pio *PreallocIO) Sync() error {
+	return fdatasync(pio.fd)
+}
+
+// SyncRange 提交给定范围的数据回写，不等待回写完成，之后的 Sync 需要等待的数据更少
+func (pio *PreallocIO) SyncRange(offset, n int64) error {
+	return syncFileRange(pio.fd, offset, n)
+}
+
+// Close 截断预分配的空白区域，并关闭文件
+func (pio *PreallocIO) Close() error {
+	pio.lock.Lock()
+	defer pio.lock.Unlock()
+	if err := pio.fd.Truncate(pio.size); err != nil {
+		_ = pio.fd.Close()
+		return err
+	}
+	return pio.fd.Close()
+}
+
+// Size 获取到实际写入的数据大小
+func (pio *PreallocIO) Size() (int64, error) {
+	pio.lock.RLock()
+	defer pio.lock.RUnlock()
+	return pio.size, nil
+}
+
+// SetSize 设置实际的数据大小，之后从这个位置继续追加写入
+// 预分配的文件异常退出后，末尾会有未写入的空白区域，加载数据文件之后需要重新设置
+func (pio *PreallocIO) SetSize(size int64) error {
+	pio.lock.Lock()
+	defer pio.lock.Unlock()
+	if size < 0 {
+		return errors.New("invalid prealloc data size")
+	}
+	pio.size = size
+	return nil
+}
+
+// Shrink 将文件截断为实际的数据大小，之后不能再写入
+// 用于活跃文件写满转换为旧的数据文件时
+func (pio *PreallocIO) Shrink() error {
+	pio.lock.Lock()
+	defer pio.lock.Unlock()
+	if err := pio.fd.Truncate(pio.size); err != nil {
+		return err
+	}
+	pio.shrunk = true
+	return nil
+}
@@ -0,0 +1,88 @@
+package fio
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPreallocIO_Write(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "prealloc-a.data")
+	defer destoryFile(path)
+
+	pio, err := NewPreallocIOManager(path, 16)
+	assert.Nil(t, err)
+
+	// 文件预分配到指定的容量
+	stat, err := os.Stat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(16), stat.Size())
+
+	_, err = pio.Write([]byte("key-a"))
+	assert.Nil(t, err)
+	_, err = pio.Write([]byte("key-b"))
+	assert.Nil(t, err)
+	size, err := pio.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(10), size)
+	assert.Nil(t, pio.SyncRange(0, size))
+	assert.Nil(t, pio.Sync())
+
+	// 超出容量时文件自动扩展
+	_, err = pio.Write([]byte("bitcask kv store"))
+	assert.Nil(t, err)
+	size, err = pio.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(26), size)
+
+	// 不会读取到预分配的空白区域
+	b := make([]byte, 20)
+	n, err := pio.Read(b, 10)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, 16, n)
+	assert.Equal(t, []byte("bitcask kv store"), b[:n])
+
+	assert.Nil(t, pio.Close())
+	stat, err = os.Stat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(26), stat.Size())
+}
+
+func TestPreallocIO_Shrink(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "prealloc-b.data")
+	defer destoryFile(path)
+
+	pio, err := NewPreallocIOManager(path, 1024)
+	assert.Nil(t, err)
+	_, err = pio.Write([]byte("key-a"))
+	assert.Nil(t, err)
+
+	err = pio.Shrink()
+	assert.Nil(t, err)
+	stat, err := os.Stat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(5), stat.Size())
+
+	// 截断之后仍然可以读取，不能再写入
+	b := make([]byte, 5)
+	_, err = pio.Read(b, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("key-a"), b)
+	_, err = pio.Write([]byte("key-b"))
+	assert.Equal(t, ErrPreallocIOReadOnly, err)
+	assert.Nil(t, pio.Close())
+
+	// 重新打开时从设置的位置继续写入
+	pio2, err := NewPreallocIOManager(path, 1024)
+	assert.Nil(t, err)
+	err = pio2.SetSize(3)
+	assert.Nil(t, err)
+	_, err = pio2.Write([]byte("-b"))
+	assert.Nil(t, err)
+	_, err = pio2.Read(b, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("key-b"), b)
+	assert.Nil(t, pio2.Close())
+}
@@ -0,0 +1,28 @@
+//go:build linux
+
+package fio
+
+import (
+	"errors"
+	"golang.org/x/sys/unix"
+	"os"
+)
+
+// fallocate 为文件分配 size 大小的磁盘空间，文件系统不支持时退回到 Truncate
+func fallocate(fd *os.File, size int64) error {
+	err := unix.Fallocate(int(fd.Fd()), 0, 0, size)
+	if errors.Is(err, unix.EOPNOTSUPP) || errors.Is(err, unix.ENOSYS) {
+		return fd.Truncate(size)
+	}
+	return err
+}
+
+// syncFileRange 提交文件中给定范围的脏页回写，不等待回写完成
+func syncFileRange(fd *os.File, offset, n int64) error {
+	return unix.SyncFileRange(int(fd.Fd()), offset, n, unix.SYNC_FILE_RANGE_WRITE)
+}
+
+// fdatasync 持久化文件数据，文件大小没有变化时不需要更新元数据
+func fdatasync(fd *os.File) error {
+	return unix.Fdatasync(int(fd.Fd()))
+}
@@ -0,0 +1,19 @@
+//go:build !linux
+
+package fio
+
+import "os"
+
+// fallocate 当前平台直接将文件扩展到 size 大小
+func fallocate(fd *os.File, size int64) error {
+	return fd.Truncate(size)
+}
+
+// syncFileRange 当前平台不支持部分范围回写，直接忽略
+func syncFileRange(*os.File, int64, int64) error {
+	return nil
+}
+
+func fdatasync(fd *os.File) error {
+	return fd.Sync()
+}
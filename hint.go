@@ -0,0 +1,77 @@
+package bitcask_db
+
+import (
+	"bitcask-db/data"
+)
+
+// 每个数据文件对应一个 hint 文件，活跃文件转换为旧文件以及关闭数据库时写入
+// 启动时优先从 hint 文件中加载索引，不需要读取完整的数据文件
+
+// appendHintRecord 暂存活跃文件中一条记录的索引信息
+func (db *DB) appendHintRecord(logRecord *data.LogRecord, pos *data.LogRecordPos) {
+	if !db.activeHintValid {
+		return
+	}
+	db.activeHints = append(db.activeHints, data.EncodeHintRecord(logRecord.Keyspace, logRecord.Key, logRecord.Type, pos)...)
+}
+
+// resetActiveHints 清空暂存的索引信息，valid 表示后续暂存的信息是否能完整覆盖活跃文件
+func (db *DB) resetActiveHints(valid bool) {
+	db.activeHints = nil
+	db.activeHintValid = valid
+}
+
+// writeActiveFileHint 将活跃文件暂存的索引信息写入对应的 hint 文件
+func (db *DB) writeActiveFileHint() error {
+	if db.activeFile == nil || !db.activeHintValid || db.activeFile.WriteOffset == 0 {
+		return nil
+	}
+	return data.WriteDataHintFile(db.fs, db.options.DirPath, db.activeFile.FileId, db.activeHints, db.activeFile.WriteOffset)
+}
+
+// readIndexRecordsFromHint 从数据文件对应的 hint 文件中读取索引记录
+// hint 文件不存在、不完整或者和数据文件大小不一致时返回 nil，由调用方回退到读取数据文件
+func (db *DB) readIndexRecordsFromHint(dataFile *data.DataFile) *dataFileRecords {
+	hintFileName := data.GetHintFileName(db.options.DirPath, dataFile.FileId)
+	if _, err := db.fs.Stat(hintFileName); err != nil {
+		return nil
+	}
+	dataSize, err := dataFile.IoManager.Size()
+	if err != nil {
+		return nil
+	}
+	hintFile, err := data.OpenDataHintFile(db.fs, db.options.DirPath, dataFile.FileId)
+	if err != nil {
+		return nil
+	}
+	defer func() {
+		_ = hintFile.Close()
+	}()
+
+	result := &dataFileRecords{}
+	var offset int64 = 0
+	for {
+		logRecord, size, err := hintFile.ReadLogRecord(offset)
+		if err != nil {
+			// 没有读到结束记录就到了文件末尾，说明 hint 文件不完整
+			return nil
+		}
+		offset += size
+		// key 为空的是结束记录，保存了写入 hint 文件时数据文件的大小
+		if len(logRecord.Key) == 0 {
+			if data.DecodeLogRecordPos(logRecord.Value).Offset != dataSize {
+				return nil
+			}
+			result.offset = dataSize
+			return result
+		}
+		realKey, seqNo := parseLogRecordKey(logRecord.Key)
+		result.records = append(result.records, &indexRecord{
+			key:      realKey,
+			seqNo:    seqNo,
+			typ:      logRecord.Type,
+			pos:      data.DecodeLogRecordPos(logRecord.Value),
+			keyspace: logRecord.Keyspace,
+		})
+	}
+}
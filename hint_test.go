@@ -0,0 +1,84 @@
+package bitcask_db
+
+import (
+	"bitcask-db/data"
+	"bitcask-db/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestDB_DataFileHint(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-db-data-hint")
+	opts.DirPath = dir
+	opts.DataFileSize = 1024 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 20000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 1000; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	for i := 20000; i < 21000; i++ {
+		err := wb.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, wb.Commit())
+
+	// 旧的数据文件在转换时已经写入了 hint 文件
+	for fid := range db.olderFiles {
+		_, err := os.Stat(data.GetHintFileName(dir, fid))
+		assert.Nil(t, err)
+	}
+	activeFid := db.activeFile.FileId
+	_, err = os.Stat(data.GetHintFileName(dir, activeFid))
+	assert.True(t, os.IsNotExist(err))
+
+	val, err := db.Get(utils.GetTestKey(20500))
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+	_, err = os.Stat(data.GetHintFileName(dir, activeFid))
+	assert.Nil(t, err)
+
+	// 从 hint 文件加载索引
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	for fid := range db2.olderFiles {
+		assert.NotNil(t, db2.readIndexRecordsFromHint(db2.olderFiles[fid]))
+	}
+	assert.Equal(t, 20000, len(db2.ListKeys()))
+	val2, err := db2.Get(utils.GetTestKey(20500))
+	assert.Nil(t, err)
+	assert.Equal(t, val, val2)
+
+	// 活跃文件继续写入后，之前的 hint 文件失效
+	err = db2.Put(utils.GetTestKey(1), []byte("new value"))
+	assert.Nil(t, err)
+	assert.Nil(t, db2.readIndexRecordsFromHint(db2.activeFile))
+	assert.Nil(t, db2.activeFile.Sync())
+
+	// 损坏的 hint 文件会回退到读取数据文件
+	err = os.Truncate(data.GetHintFileName(dir, 0), 10)
+	assert.Nil(t, err)
+	assert.Nil(t, db2.readIndexRecordsFromHint(db2.olderFiles[0]))
+	err = db2.fileLock.Unlock()
+	assert.Nil(t, err)
+
+	db3, err := Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 20001, len(db3.ListKeys()))
+	val3, err := db3.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("new value"), val3)
+	err = db3.Close()
+	assert.Nil(t, err)
+}
@@ -0,0 +1,243 @@
+package bitcask_db
+
+import (
+	"bitcask-db/data"
+	"bitcask-db/index"
+	"bytes"
+	"sync/atomic"
+	"time"
+)
+
+// 开启 Options.HistoryVersions 或者 Options.HistoryRetention 之后，默认 keyspace 中写入的记录保存版本号和之前版本的位置
+// 同一个 key 的所有版本组成一条版本链，Merge 时只保留满足保留策略的版本
+// 删除 key 时写入一个删除版本，之前的版本仍然保留，重新写入 key 之后新的版本接在删除版本之后
+// 版本号使用单独的计数器，和事务序列号无关；范围删除为范围内的每个 key 写入使用同一个版本号的删除版本
+
+// Version key 的一个版本
+type Version struct {
+	Seq       uint64    // 写入这个版本时的版本号，保留历史版本之前写入的数据为 0
+	Timestamp time.Time // 写入这个版本的时间，保留历史版本之前写入的数据为零值
+	Deleted   bool      // 这个版本删除了 key
+	Value     []byte
+}
+
+// Seq 获取当前最新的版本号，之后可以使用 GetAt 读取这个时间点的数据
+func (db *DB) Seq() uint64 {
+	return atomic.LoadUint64(&db.versionSeq)
+}
+
+// History 获取 key 所有保留的版本，最新的版本在前面
+// 已经删除的 key 同样返回之前保留的版本，第一个版本为删除版本
+func (db *DB) History(key []byte) ([]*Version, error) {
+	if len(key) == 0 {
+		return nil, ErrKeyIsEmpty
+	}
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	logRecordPos := db.index.Get(key)
+	if logRecordPos == nil {
+		logRecordPos = db.deletedVersions.Get(key)
+	}
+	if logRecordPos == nil {
+		return nil, ErrKeyNotFound
+	}
+	versions, err := db.readVersions(key, logRecordPos)
+	if err != nil {
+		return nil, err
+	}
+	// 不是保留历史版本的记录，只有当前的值
+	if len(versions) == 0 {
+		value, err := db.getValueByPosition(logRecordPos)
+		if err != nil {
+			return nil, err
+		}
+		return []*Version{{Value: value}}, nil
+	}
+	// 删除之前的版本都已经不再保留
+	if len(versions) == 1 && versions[0].Deleted {
+		return nil, ErrKeyNotFound
+	}
+	history := make([]*Version, len(versions))
+	for i, version := range versions {
+		history[i] = &Version{
+			Seq:       version.Seq,
+			Timestamp: time.Unix(0, version.Timestamp),
+			Deleted:   version.Deleted,
+		}
+		if !version.Deleted {
+			history[i].Value = version.Value
+		}
+	}
+	return history, nil
+}
+
+// GetAt 读取 key 在版本号 seq 时的值，这个时间点 key 已经删除或者版本已经不再保留时返回 ErrKeyNotFound
+func (db *DB) GetAt(key []byte, seq uint64) ([]byte, error) {
+	history, err := db.History(key)
+	if err != nil {
+		return nil, err
+	}
+	for _, version := range history {
+		if version.Seq <= seq {
+			if version.Deleted {
+				return nil, ErrKeyNotFound
+			}
+			return version.Value, nil
+		}
+	}
+	return nil, ErrKeyNotFound
+}
+
+// historyEnabled 是否保留历史版本
+func (db *DB) historyEnabled() bool {
+	return db.options.HistoryVersions > 0 || db.options.HistoryRetention > 0
+}
+
+// isVersionedRecord 记录的 value 中是否带有之前版本的位置
+func isVersionedRecord(typ data.LogRecordType) bool {
+	return typ == data.LogRecordVersioned || typ == data.LogRecordTxnVersioned ||
+		typ == data.LogRecordHistory || typ == data.LogRecordVersionedDeleted
+}
+
+// readVersions 从 pos 开始沿着版本链读取满足保留策略的版本，最新的版本在前面
+// pos 处不是保留历史版本的记录时返回空
+func (db *DB) readVersions(key []byte, pos *data.LogRecordPos) ([]*data.VersionedValue, error) {
+	var versions []*data.VersionedValue
+	now := time.Now().UnixNano()
+	for pos != nil {
+		logRecord, err := db.readLogRecord(pos)
+		if err != nil {
+			return nil, err
+		}
+		realKey, _ := parseLogRecordKey(logRecord.Key)
+		if !isVersionedRecord(logRecord.Type) || !bytes.Equal(realKey, key) {
+			break
+		}
+		// 之前的版本从被新版本覆盖时开始计算保留时长
+		if n := len(versions); n > 0 && !db.versionRetained(n, now-versions[n-1].Timestamp) {
+			break
+		}
+		version := data.DecodeVersionedValue(logRecord.Value)
+		versions = append(versions, version)
+		pos = version.Prev
+	}
+	return versions, nil
+}
+
+// versionRetained 第 n 个之前的版本是否满足保留策略，age 为被覆盖之后经过的时长
+func (db *DB) versionRetained(n int, age int64) bool {
+	if db.options.HistoryVersions > 0 && uint(n) > db.options.HistoryVersions {
+		return false
+	}
+	if db.options.HistoryRetention > 0 && age > int64(db.options.HistoryRetention) {
+		return false
+	}
+	return true
+}
+
+// putVersion 写入新的版本，记录之前版本的位置
+// 在访问此方法前必须持有互斥锁
+func (db *DB) putVersion(key, value []byte) error {
+	pos, err := db.appendVersion(key, value, false, atomic.AddUint64(&db.versionSeq, 1))
+	if err != nil {
+		return err
+	}
+	if oldPos := db.applyIndex([]*index.BatchEntry{{Key: key, Pos: pos}}, [][]byte{value})[0]; oldPos != nil {
+		db.reclaimableSize += int64(oldPos.Size)
+	}
+	db.deletedVersions.Delete(key)
+	return nil
+}
+
+// deleteVersion 使用版本号 seq 写入删除 key 的版本，之前的版本仍然保留
+// 在访问此方法前必须持有互斥锁
+func (db *DB) deleteVersion(key []byte, seq uint64) error {
+	pos, err := db.appendVersion(key, nil, true, seq)
+	if err != nil {
+		return err
+	}
+	db.reclaimableSize += int64(pos.Size)
+	oldPos := db.applyIndex([]*index.BatchEntry{{Key: key}}, nil)[0]
+	if oldPos == nil {
+		return ErrIndexUpdateFailed
+	}
+	db.reclaimableSize += int64(oldPos.Size)
+	db.deletedVersions.Put(key, pos)
+	return nil
+}
+
+// appendVersion 使用版本号 seq 追加写入一个版本
+// 在访问此方法前必须持有互斥锁
+func (db *DB) appendVersion(key, value []byte, deleted bool, seq uint64) (*data.LogRecordPos, error) {
+	prev, err := db.historyPrev(key)
+	if err != nil {
+		return nil, err
+	}
+	recordType := data.LogRecordVersioned
+	if deleted {
+		recordType = data.LogRecordVersionedDeleted
+	}
+	return db.appendLogRecord(&data.LogRecord{
+		Key: logRecordKeyWithSeqNo(key, nonTransactionSeqNo),
+		Value: data.EncodeVersionedValue(&data.VersionedValue{
+			Seq:       seq,
+			Timestamp: time.Now().UnixNano(),
+			Deleted:   deleted,
+			Prev:      prev,
+			Value:     value,
+		}),
+		Type: recordType,
+	})
+}
+
+// historyPrev 获取新版本需要记录的之前版本的位置，key 已经删除时为删除版本的位置
+// 之前的版本会被 merge 重写时，先将保留的版本复制到活跃文件中，避免 merge 之后位置失效
+// 在访问此方法前必须持有互斥锁
+func (db *DB) historyPrev(key []byte) (*data.LogRecordPos, error) {
+	prev := db.index.Get(key)
+	if prev == nil {
+		prev = db.deletedVersions.Get(key)
+	}
+	if prev == nil || prev.Fid >= db.operandFloor {
+		return prev, nil
+	}
+	versions, err := db.readVersions(key, prev)
+	if err != nil {
+		return nil, err
+	}
+	// 写入新版本之后，当前的版本也成为之前的版本
+	if limit := db.options.HistoryVersions; limit > 0 && uint(len(versions)) > limit {
+		versions = versions[:limit]
+	}
+	return db.writeVersions(key, versions, func(logRecord *data.LogRecord) (*data.LogRecordPos, error) {
+		return db.appendLogRecord(logRecord)
+	})
+}
+
+// writeVersions 按照从旧到新的顺序写入历史版本的副本，返回最新的副本的位置
+func (db *DB) writeVersions(key []byte, versions []*data.VersionedValue,
+	appendLogRecord func(*data.LogRecord) (*data.LogRecordPos, error)) (*data.LogRecordPos, error) {
+	var prev *data.LogRecordPos
+	for i := len(versions) - 1; i >= 0; i-- {
+		version := *versions[i]
+		version.Prev = prev
+		pos, err := appendLogRecord(&data.LogRecord{
+			Key:   logRecordKeyWithSeqNo(key, nonTransactionSeqNo),
+			Value: data.EncodeVersionedValue(&version),
+			Type:  data.LogRecordHistory,
+		})
+		if err != nil {
+			return nil, err
+		}
+		prev = pos
+	}
+	return prev, nil
+}
+
+// recordValue 获取记录中用户写入的 value
+func recordValue(logRecord *data.LogRecord) []byte {
+	if isVersionedRecord(logRecord.Type) {
+		return data.DecodeVersionedValue(logRecord.Value).Value
+	}
+	return logRecord.Value
+}
@@ -0,0 +1,265 @@
+package bitcask_db
+
+import (
+	"bitcask-db/utils"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestDB_History(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-db-history")
+	opts.DirPath = dir
+	opts.HistoryVersions = 3
+	db, err := Open(opts)
+	assert.Nil(t, err)
+
+	key := utils.GetTestKey(1)
+	var seqs []uint64
+	for i := 0; i < 6; i++ {
+		assert.Nil(t, db.Put(key, []byte(fmt.Sprintf("v%d", i))))
+		seqs = append(seqs, db.Seq())
+	}
+	val, err := db.Get(key)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v5"), val)
+
+	// 当前的版本和之前的 3 个版本
+	history, err := db.History(key)
+	assert.Nil(t, err)
+	assert.Equal(t, 4, len(history))
+	for i, version := range history {
+		assert.Equal(t, []byte(fmt.Sprintf("v%d", 5-i)), version.Value)
+		assert.Equal(t, seqs[5-i], version.Seq)
+	}
+	val, err = db.GetAt(key, seqs[3])
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v3"), val)
+	_, err = db.GetAt(key, seqs[1])
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// 事务中写入的版本使用新的版本号
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put(key, []byte("txn")))
+	assert.Nil(t, wb.Commit())
+	val, err = db.GetAt(key, seqs[5])
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v5"), val)
+	val, err = db.GetAt(key, db.Seq())
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("txn"), val)
+	assert.Nil(t, db.Close())
+
+	// 重启之后历史版本和序列号仍然有效
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	assert.True(t, db2.Seq() > seqs[5])
+	history, err = db2.History(key)
+	assert.Nil(t, err)
+	assert.Equal(t, 4, len(history))
+	assert.Equal(t, []byte("txn"), history[0].Value)
+	assert.Equal(t, []byte("v3"), history[3].Value)
+
+	// 删除 key 之后仍然保留之前的版本
+	txnSeq := db2.Seq()
+	assert.Nil(t, db2.Delete(key))
+	_, err = db2.Get(key)
+	assert.Equal(t, ErrKeyNotFound, err)
+	history, err = db2.History(key)
+	assert.Nil(t, err)
+	assert.Equal(t, 4, len(history))
+	assert.True(t, history[0].Deleted)
+	assert.Equal(t, []byte("txn"), history[1].Value)
+	_, err = db2.GetAt(key, db2.Seq())
+	assert.Equal(t, ErrKeyNotFound, err)
+	assert.Nil(t, db2.Put(key, []byte("new")))
+	history, err = db2.History(key)
+	assert.Nil(t, err)
+	assert.Equal(t, 4, len(history))
+	assert.Equal(t, []byte("new"), history[0].Value)
+	assert.True(t, history[1].Deleted)
+	val, err = db2.GetAt(key, txnSeq)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("txn"), val)
+}
+
+func TestDB_HistoryDelete(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-db-history-delete")
+	opts.DirPath = dir
+	opts.DataFileSize = 8 * 1024
+	opts.DataFileMergeRatio = 0
+	opts.HistoryVersions = 3
+	db, err := Open(opts)
+	assert.Nil(t, err)
+
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), []byte(fmt.Sprintf("%d-0", i))))
+	}
+	seq := db.Seq()
+	// 事务中删除 key 同样保留之前的版本
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	for i := 0; i < 50; i++ {
+		assert.Nil(t, wb.Delete(utils.GetTestKey(i)))
+	}
+	assert.Nil(t, wb.Commit())
+	for i := 50; i < 100; i++ {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+	assert.Equal(t, uint(0), db.Stat().KeyNum)
+
+	// 其他 keyspace 中的事务不使用版本号
+	logs, err := db.CreateKeyspace("logs")
+	assert.Nil(t, err)
+	deletedSeq := db.Seq()
+	wb = db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.PutIn(logs, []byte("k"), []byte("v")))
+	assert.Nil(t, wb.Commit())
+	assert.Equal(t, deletedSeq, db.Seq())
+
+	// 删除之后重新写入的 key 仍然可以读取删除之前的版本
+	for i := 0; i < 100; i += 2 {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), []byte(fmt.Sprintf("%d-1", i))))
+	}
+	assert.Nil(t, db.Merge())
+	assert.Nil(t, db.Close())
+
+	// merge 之后重启，删除的 key 的历史版本和版本号仍然有效
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	assert.Equal(t, uint(50), db2.Stat().KeyNum)
+	assert.True(t, db2.Seq() > deletedSeq)
+	for i := 0; i < 100; i++ {
+		val, err := db2.GetAt(utils.GetTestKey(i), seq)
+		assert.Nil(t, err)
+		assert.Equal(t, []byte(fmt.Sprintf("%d-0", i)), val)
+		_, err = db2.GetAt(utils.GetTestKey(i), deletedSeq)
+		assert.Equal(t, ErrKeyNotFound, err)
+	}
+	history, err := db2.History(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(history))
+	assert.True(t, history[0].Deleted)
+	history, err = db2.History(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(history))
+	assert.Equal(t, []byte("2-1"), history[0].Value)
+
+	// 重启之后删除的 key 重新写入，新的版本接在删除版本之后
+	assert.Nil(t, db2.Put(utils.GetTestKey(1), []byte("1-2")))
+	val, err := db2.GetAt(utils.GetTestKey(1), seq)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("1-0"), val)
+}
+
+func TestDB_HistoryRetention(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-db-history-retention")
+	opts.DirPath = dir
+	opts.HistoryRetention = 50 * time.Millisecond
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	key := utils.GetTestKey(1)
+	assert.Nil(t, db.Put(key, []byte("v0")))
+	assert.Nil(t, db.Put(key, []byte("v1")))
+	time.Sleep(100 * time.Millisecond)
+	assert.Nil(t, db.Put(key, []byte("v2")))
+	assert.Nil(t, db.Put(key, []byte("v3")))
+
+	// v0 被覆盖之后已经超过了保留时长
+	history, err := db.History(key)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(history))
+	assert.Equal(t, []byte("v1"), history[2].Value)
+}
+
+func TestDB_HistoryMerge(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-db-history-merge")
+	opts.DirPath = dir
+	opts.DataFileSize = 8 * 1024
+	opts.DataFileMergeRatio = 0
+	opts.HistoryVersions = 2
+	db, err := Open(opts)
+	assert.Nil(t, err)
+
+	for round := 0; round < 5; round++ {
+		for i := 0; i < 200; i++ {
+			assert.Nil(t, db.Put(utils.GetTestKey(i), []byte(fmt.Sprintf("%d-%d", i, round))))
+		}
+	}
+	seq := db.Seq()
+	assert.Nil(t, db.Merge())
+	// merge 之后写入的版本，之前的版本在 merge 的文件中
+	assert.Nil(t, db.Put(utils.GetTestKey(0), []byte("0-5")))
+	assert.Nil(t, db.Close())
+
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	assert.True(t, db2.Seq() > seq)
+	history, err := db2.History(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(history))
+	for i, version := range history {
+		assert.Equal(t, []byte(fmt.Sprintf("1-%d", 4-i)), version.Value)
+	}
+	val, err := db2.GetAt(utils.GetTestKey(1), history[1].Seq)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("1-3"), val)
+
+	history, err = db2.History(utils.GetTestKey(0))
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(history))
+	for i, version := range history {
+		assert.Equal(t, []byte(fmt.Sprintf("0-%d", 5-i)), version.Value)
+	}
+}
+
+func TestDB_HistoryDeleteRange(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-db-history-delete-range")
+	opts.DirPath = dir
+	opts.HistoryVersions = 3
+	db, err := Open(opts)
+	assert.Nil(t, err)
+
+	for i := 0; i < 5; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), []byte(fmt.Sprintf("v%d", i))))
+	}
+	seq := db.Seq()
+	// 范围内的每个 key 都写入同一个版本号的删除版本
+	assert.Nil(t, db.DeleteRange(utils.GetTestKey(1), utils.GetTestKey(4)))
+	assert.Equal(t, seq+1, db.Seq())
+	assert.Nil(t, db.Close())
+
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	for i := 1; i < 4; i++ {
+		_, err = db2.Get(utils.GetTestKey(i))
+		assert.Equal(t, ErrKeyNotFound, err)
+		history, err := db2.History(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, 2, len(history))
+		assert.True(t, history[0].Deleted)
+		assert.Equal(t, seq+1, history[0].Seq)
+		_, err = db2.GetAt(utils.GetTestKey(i), seq+1)
+		assert.Equal(t, ErrKeyNotFound, err)
+		val, err := db2.GetAt(utils.GetTestKey(i), seq)
+		assert.Nil(t, err)
+		assert.Equal(t, []byte(fmt.Sprintf("v%d", i)), val)
+	}
+	// 范围之外的 key 不受影响
+	val, err := db2.GetAt(utils.GetTestKey(4), seq+1)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v4"), val)
+	assert.Equal(t, uint(2), db2.Stat().KeyNum)
+}
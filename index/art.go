@@ -57,6 +57,25 @@ func (art *AdaptiveRadixTree) Delete(key []byte) (*data.LogRecordPos, bool) {
 	return oldValue.(*data.LogRecordPos), deleted
 }
 
+// ApplyBatch 批量更新索引，返回每个操作对应的 key 之前的位置信息
+func (art *AdaptiveRadixTree) ApplyBatch(entries []*BatchEntry) []*data.LogRecordPos {
+	oldPositions := make([]*data.LogRecordPos, len(entries))
+	art.lock.Lock()
+	defer art.lock.Unlock()
+	for i, entry := range entries {
+		var oldValue goart.Value
+		if entry.Pos == nil {
+			oldValue, _ = art.tree.Delete(entry.Key)
+		} else {
+			oldValue, _ = art.tree.Insert(entry.Key, entry.Pos)
+		}
+		if oldValue != nil {
+			oldPositions[i] = oldValue.(*data.LogRecordPos)
+		}
+	}
+	return oldPositions
+}
+
 // Size 索引中的数据量
 func (art *AdaptiveRadixTree) Size() int {
 	art.lock.RLock()
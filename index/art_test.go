@@ -81,3 +81,24 @@ func TestAdaptiveRadixTree_Iterator(t *testing.T) {
 	}
 
 }
+
+func TestAdaptiveRadixTree_ApplyBatch(t *testing.T) {
+	art := NewART()
+	art.Put([]byte("key-1"), &data.LogRecordPos{Fid: 1, Offset: 10})
+	art.Put([]byte("key-2"), &data.LogRecordPos{Fid: 1, Offset: 20})
+
+	oldPositions := art.ApplyBatch([]*BatchEntry{
+		{Key: []byte("key-1"), Pos: &data.LogRecordPos{Fid: 2, Offset: 10}},
+		{Key: []byte("key-2")},
+		{Key: []byte("key-3"), Pos: &data.LogRecordPos{Fid: 2, Offset: 30}},
+	})
+	assert.Equal(t, 3, len(oldPositions))
+	assert.Equal(t, int64(10), oldPositions[0].Offset)
+	assert.Equal(t, int64(20), oldPositions[1].Offset)
+	assert.Nil(t, oldPositions[2])
+
+	assert.Equal(t, uint32(2), art.Get([]byte("key-1")).Fid)
+	assert.Nil(t, art.Get([]byte("key-2")))
+	assert.Equal(t, int64(30), art.Get([]byte("key-3")).Offset)
+	assert.Equal(t, 2, art.Size())
+}
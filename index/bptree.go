@@ -10,9 +10,13 @@ import (
 // https://github.com/etcd-io/bbolt
 // go.etcd.io/bbolt
 
-const bptreeIndexFileName = "bptree-index"
+const BPTreeIndexFileName = "bptree-index"
 
-var indexBucketName = []byte("bitcask-index")
+var (
+	indexBucketName = []byte("bitcask-index")
+	metaBucketName  = []byte("bitcask-meta")
+	checkpointKey   = []byte("checkpoint")
+)
 
 // BPlusTree B+ 数索引
 // 主要封装了 go.etcd.io/bbolt
@@ -24,13 +28,16 @@ type BPlusTree struct {
 func NewBPlusTree(dirPath string, syncWrites bool) *BPlusTree {
 	opts := bbolt.DefaultOptions
 	opts.NoSync = !syncWrites
-	bptree, err := bbolt.Open(filepath.Join(dirPath, bptreeIndexFileName), 0644, opts)
+	bptree, err := bbolt.Open(filepath.Join(dirPath, BPTreeIndexFileName), 0644, opts)
 	if err != nil {
 		panic("failed to open bptree at startup")
 	}
 	// 创建对应的 bucket
 	if err := bptree.Update(func(tx *bbolt.Tx) error {
-		_, err = tx.CreateBucketIfNotExists(indexBucketName)
+		if _, err := tx.CreateBucketIfNotExists(indexBucketName); err != nil {
+			return err
+		}
+		_, err = tx.CreateBucketIfNotExists(metaBucketName)
 		return err
 	}); err != nil {
 		panic("failed to create bucket in bptree")
@@ -93,6 +100,56 @@ func (bpt *BPlusTree) Delete(key []byte) (*data.LogRecordPos, bool) {
 
 }
 
+// ApplyBatch 批量更新索引，所有操作在同一个 bbolt 事务中完成
+func (bpt *BPlusTree) ApplyBatch(entries []*BatchEntry) []*data.LogRecordPos {
+	return bpt.ApplyBatchWithCheckpoint(entries, nil)
+}
+
+// ApplyBatchWithCheckpoint 批量更新索引，并在同一个 bbolt 事务中记录已经应用到的数据位置
+func (bpt *BPlusTree) ApplyBatchWithCheckpoint(entries []*BatchEntry, checkpoint *data.LogRecordPos) []*data.LogRecordPos {
+	oldPositions := make([]*data.LogRecordPos, len(entries))
+	if err := bpt.tree.Update(func(tx *bbolt.Tx) error {
+		if checkpoint != nil {
+			if err := tx.Bucket(metaBucketName).Put(checkpointKey, data.EncodeLogRecordPos(checkpoint)); err != nil {
+				return err
+			}
+		}
+		bucket := tx.Bucket(indexBucketName)
+		for i, entry := range entries {
+			if oldVal := bucket.Get(entry.Key); len(oldVal) != 0 {
+				oldPositions[i] = data.DecodeLogRecordPos(oldVal)
+			}
+			var err error
+			if entry.Pos == nil {
+				err = bucket.Delete(entry.Key)
+			} else {
+				err = bucket.Put(entry.Key, data.EncodeLogRecordPos(entry.Pos))
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		panic("failed to apply batch in bptree")
+	}
+	return oldPositions
+}
+
+// Checkpoint 获取已经应用到的数据位置
+func (bpt *BPlusTree) Checkpoint() *data.LogRecordPos {
+	var checkpoint *data.LogRecordPos
+	if err := bpt.tree.View(func(tx *bbolt.Tx) error {
+		if value := tx.Bucket(metaBucketName).Get(checkpointKey); len(value) != 0 {
+			checkpoint = data.DecodeLogRecordPos(value)
+		}
+		return nil
+	}); err != nil {
+		panic("failed to get checkpoint in bptree")
+	}
+	return checkpoint
+}
+
 // Size 索引中的数据量
 func (bpt *BPlusTree) Size() int {
 	var size int
@@ -111,3 +111,29 @@ func TestBPlusTree_Iterator(t *testing.T) {
 		t.Log(string(iter.Key()))
 	}
 }
+
+func TestBPlusTree_ApplyBatch(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "bptree_test_batch")
+	_ = os.MkdirAll(path, os.ModePerm)
+	defer func() {
+		_ = os.RemoveAll(path)
+	}()
+	tree := NewBPlusTree(path, false)
+	tree.Put([]byte("aac"), &data.LogRecordPos{Fid: 1, Offset: 10})
+	tree.Put([]byte("abc"), &data.LogRecordPos{Fid: 1, Offset: 20})
+
+	oldPositions := tree.ApplyBatch([]*BatchEntry{
+		{Key: []byte("aac"), Pos: &data.LogRecordPos{Fid: 2, Offset: 10}},
+		{Key: []byte("abc")},
+		{Key: []byte("acc"), Pos: &data.LogRecordPos{Fid: 2, Offset: 30}},
+	})
+	assert.Equal(t, 3, len(oldPositions))
+	assert.Equal(t, int64(10), oldPositions[0].Offset)
+	assert.Equal(t, int64(20), oldPositions[1].Offset)
+	assert.Nil(t, oldPositions[2])
+
+	assert.Equal(t, uint32(2), tree.Get([]byte("aac")).Fid)
+	assert.Nil(t, tree.Get([]byte("abc")))
+	assert.Equal(t, int64(30), tree.Get([]byte("acc")).Offset)
+	assert.Equal(t, 2, tree.Size())
+}
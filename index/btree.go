@@ -37,6 +37,8 @@ func (bt *BTree) Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos {
 
 func (bt *BTree) Get(key []byte) *data.LogRecordPos {
 	it := &Item{key: key}
+	bt.lock.RLock()
+	defer bt.lock.RUnlock()
 	btreeItem := bt.tree.Get(it)
 	if btreeItem == nil {
 		return nil
@@ -57,7 +59,27 @@ func (bt *BTree) Delete(key []byte) (*data.LogRecordPos, bool) {
 	return oldItem.(*Item).pos, true
 }
 
+func (bt *BTree) ApplyBatch(entries []*BatchEntry) []*data.LogRecordPos {
+	oldPositions := make([]*data.LogRecordPos, len(entries))
+	bt.lock.Lock()
+	defer bt.lock.Unlock()
+	for i, entry := range entries {
+		var oldItem btree.Item
+		if entry.Pos == nil {
+			oldItem = bt.tree.Delete(&Item{key: entry.Key})
+		} else {
+			oldItem = bt.tree.ReplaceOrInsert(&Item{key: entry.Key, pos: entry.Pos})
+		}
+		if oldItem != nil {
+			oldPositions[i] = oldItem.(*Item).pos
+		}
+	}
+	return oldPositions
+}
+
 func (bt *BTree) Size() int {
+	bt.lock.RLock()
+	defer bt.lock.RUnlock()
 	return bt.tree.Len()
 }
 
@@ -8,28 +8,28 @@ import (
 
 func TestBTree_Put(t *testing.T) {
 	bt := NewBTree()
-	res1 := bt.Put(nil, &data.LogRecordPos{1, 100})
+	res1 := bt.Put(nil, &data.LogRecordPos{Fid: 1, Offset: 100})
 	assert.Nil(t, res1)
 
-	res2 := bt.Put([]byte("a"), &data.LogRecordPos{1, 2})
+	res2 := bt.Put([]byte("a"), &data.LogRecordPos{Fid: 1, Offset: 2})
 	assert.Nil(t, res2)
 
-	res3 := bt.Put([]byte("a"), &data.LogRecordPos{2, 12})
+	res3 := bt.Put([]byte("a"), &data.LogRecordPos{Fid: 2, Offset: 12})
 	assert.Equal(t, res3.Fid, uint32(1))
 	assert.Equal(t, res3.Offset, int64(2))
 }
 
 func TestBTree_Get(t *testing.T) {
 	bt := NewBTree()
-	res1 := bt.Put(nil, &data.LogRecordPos{1, 100})
+	res1 := bt.Put(nil, &data.LogRecordPos{Fid: 1, Offset: 100})
 	assert.Nil(t, res1)
 	pos1 := bt.Get(nil)
 	assert.Equal(t, uint32(1), pos1.Fid)
 	assert.Equal(t, int64(100), pos1.Offset)
 
-	res2 := bt.Put([]byte("a"), &data.LogRecordPos{1, 2})
+	res2 := bt.Put([]byte("a"), &data.LogRecordPos{Fid: 1, Offset: 2})
 	assert.Nil(t, res2)
-	res3 := bt.Put([]byte("a"), &data.LogRecordPos{1, 3})
+	res3 := bt.Put([]byte("a"), &data.LogRecordPos{Fid: 1, Offset: 3})
 	assert.Equal(t, res3.Fid, uint32(1))
 	assert.Equal(t, res3.Offset, int64(2))
 
@@ -41,14 +41,14 @@ func TestBTree_Get(t *testing.T) {
 
 func TestBTree_Delete(t *testing.T) {
 	bt := NewBTree()
-	res1 := bt.Put(nil, &data.LogRecordPos{1, 100})
+	res1 := bt.Put(nil, &data.LogRecordPos{Fid: 1, Offset: 100})
 	assert.Nil(t, res1)
 	res2, ok1 := bt.Delete(nil)
 	assert.True(t, ok1)
 	assert.Equal(t, res2.Fid, uint32(1))
 	assert.Equal(t, res2.Offset, int64(100))
 
-	res3 := bt.Put([]byte("a"), &data.LogRecordPos{1, 3})
+	res3 := bt.Put([]byte("a"), &data.LogRecordPos{Fid: 1, Offset: 3})
 	assert.Nil(t, res3)
 	res4, ok2 := bt.Delete([]byte("a"))
 	assert.True(t, ok2)
@@ -63,7 +63,7 @@ func TestBTree_Iterator(t *testing.T) {
 	assert.Equal(t, false, iter1.Valid())
 
 	// Btree 有数据
-	bt1.Put([]byte("ccde"), &data.LogRecordPos{1, 10})
+	bt1.Put([]byte("ccde"), &data.LogRecordPos{Fid: 1, Offset: 10})
 	iter2 := bt1.Iterator(false)
 	assert.Equal(t, true, iter2.Valid())
 	t.Log(iter2.Key())
@@ -75,9 +75,9 @@ func TestBTree_Iterator(t *testing.T) {
 	assert.Equal(t, false, iter2.Valid())
 
 	// 有多条数据
-	bt1.Put([]byte("acee"), &data.LogRecordPos{1, 10})
-	bt1.Put([]byte("eede"), &data.LogRecordPos{1, 10})
-	bt1.Put([]byte("bbcd"), &data.LogRecordPos{1, 10})
+	bt1.Put([]byte("acee"), &data.LogRecordPos{Fid: 1, Offset: 10})
+	bt1.Put([]byte("eede"), &data.LogRecordPos{Fid: 1, Offset: 10})
+	bt1.Put([]byte("bbcd"), &data.LogRecordPos{Fid: 1, Offset: 10})
 	iter3 := bt1.Iterator(false)
 	for iter3.Rewind(); iter3.Valid(); iter3.Next() {
 		//t.Log(string(iter3.Key()))
@@ -107,3 +107,24 @@ func TestBTree_Iterator(t *testing.T) {
 	}
 
 }
+
+func TestBTree_ApplyBatch(t *testing.T) {
+	bt := NewBTree()
+	bt.Put([]byte("a"), &data.LogRecordPos{Fid: 1, Offset: 10})
+	bt.Put([]byte("b"), &data.LogRecordPos{Fid: 1, Offset: 20})
+
+	oldPositions := bt.ApplyBatch([]*BatchEntry{
+		{Key: []byte("a"), Pos: &data.LogRecordPos{Fid: 2, Offset: 10}},
+		{Key: []byte("b")},
+		{Key: []byte("c"), Pos: &data.LogRecordPos{Fid: 2, Offset: 30}},
+	})
+	assert.Equal(t, 3, len(oldPositions))
+	assert.Equal(t, int64(10), oldPositions[0].Offset)
+	assert.Equal(t, int64(20), oldPositions[1].Offset)
+	assert.Nil(t, oldPositions[2])
+
+	assert.Equal(t, uint32(2), bt.Get([]byte("a")).Fid)
+	assert.Nil(t, bt.Get([]byte("b")))
+	assert.Equal(t, int64(30), bt.Get([]byte("c")).Offset)
+	assert.Equal(t, 2, bt.Size())
+}
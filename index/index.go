@@ -17,6 +17,9 @@ type Index interface {
 	// Delete 根据 key 删除对应的索引位置信息
 	Delete(key []byte) (*data.LogRecordPos, bool)
 
+	// ApplyBatch 批量更新索引，返回每个操作对应的 key 之前的位置信息
+	ApplyBatch(entries []*BatchEntry) []*data.LogRecordPos
+
 	// Size 索引中的数据量
 	Size() int
 
@@ -27,6 +30,24 @@ type Index interface {
 	Close() error
 }
 
+// BatchEntry 批量更新索引中的一个操作，Pos 为空时表示删除 key
+type BatchEntry struct {
+	Key []byte
+	Pos *data.LogRecordPos
+}
+
+// CheckpointIndex 持久化到磁盘上的索引，更新索引的同时在同一个事务中记录已经应用到的数据位置
+// 启动时只需要从这个位置开始重放数据文件，就能让索引和数据文件保持一致
+type CheckpointIndex interface {
+	Index
+
+	// ApplyBatchWithCheckpoint 批量更新索引，并记录数据文件中已经应用到的位置
+	ApplyBatchWithCheckpoint(entries []*BatchEntry, checkpoint *data.LogRecordPos) []*data.LogRecordPos
+
+	// Checkpoint 获取已经应用到的数据位置，没有记录时返回 nil
+	Checkpoint() *data.LogRecordPos
+}
+
 type IndexType = int8
 
 const (
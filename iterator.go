@@ -3,6 +3,7 @@ package bitcask_db
 import (
 	"bitcask-db/index"
 	"bytes"
+	"context"
 )
 
 // Iterator 迭代器
@@ -10,6 +11,7 @@ type Iterator struct {
 	indexIter index.Iterator
 	db        *DB
 	Options   IteratorOptions
+	ctx       context.Context // 为空时迭代不会被取消
 }
 
 func (db *DB) NewIterator(opts IteratorOptions) *Iterator {
@@ -21,6 +23,16 @@ func (db *DB) NewIterator(opts IteratorOptions) *Iterator {
 	}
 }
 
+// NewIteratorContext 初始化迭代器，ctx 取消之后迭代结束，Value 返回 ctx.Err()
+func (db *DB) NewIteratorContext(ctx context.Context, opts IteratorOptions) (*Iterator, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	it := db.NewIterator(opts)
+	it.ctx = ctx
+	return it, nil
+}
+
 // Rewind 重新回到迭代器的起点，即第一个数据
 func (it *Iterator) Rewind() {
 	it.indexIter.Rewind()
@@ -41,16 +53,33 @@ func (it *Iterator) Next() {
 
 // Valid 是否有效，既是否已经遍历完了所有的 key，用于退出遍历
 func (it *Iterator) Valid() bool {
+	if it.Err() != nil {
+		return false
+	}
 	return it.indexIter.Valid()
 }
 
+// Err 迭代器的 ctx 被取消时返回 ctx.Err()
+func (it *Iterator) Err() error {
+	if it.ctx == nil {
+		return nil
+	}
+	return it.ctx.Err()
+}
+
 // Key 当前遍历位置的 Key 数据
 func (it *Iterator) Key() []byte { return it.indexIter.Key() }
 
 // Value 当前遍历位置的 Value 数据
 func (it *Iterator) Value() ([]byte, error) {
+	if it.ctx != nil {
+		if err := it.db.rLockContext(it.ctx); err != nil {
+			return nil, err
+		}
+	} else {
+		it.db.mu.RLock()
+	}
 	defer it.db.mu.RUnlock()
-	it.db.mu.RLock()
 	logRecordPos := it.indexIter.Value()
 	return it.db.getValueByPosition(logRecordPos)
 }
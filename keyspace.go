@@ -0,0 +1,369 @@
+package bitcask_db
+
+import (
+	"bitcask-db/data"
+	"bitcask-db/index"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Keyspace 数据库中的一个命名空间，拥有独立的内存索引和 merge 统计信息
+// 数据和默认的 keyspace 写入同一组数据文件，记录中保存 keyspace id 用于区分
+// 因为数据文件是共享的，merge 总是重写所有 keyspace 的数据，Keyspace.Merge 只是按照这个 keyspace 的统计信息触发 merge
+type Keyspace struct {
+	db              *DB
+	id              uint32
+	name            string
+	index           index.Index
+	dataSize        int64 // 索引中有效数据的大小
+	reclaimableSize int64 // 这个 keyspace 中可以进行 merge 回收的数据量
+	dropped         bool
+}
+
+// KeyspaceStat keyspace 的相关统计信息
+type KeyspaceStat struct {
+	KeyNum          uint  // key 的总数量
+	DataSize        int64 // 有效数据的大小
+	ReclaimableSize int64 // 可以进行 merge 回收的数据量，以字节为单位
+}
+
+// CreateKeyspace 创建新的 keyspace，名称已经存在时返回 ErrKeyspaceExists
+// B+ 树索引存储在磁盘上，每个数据目录只有一个索引文件，使用 BPlusTree 索引时返回 ErrKeyspaceUnsupported
+func (db *DB) CreateKeyspace(name string) (*Keyspace, error) {
+	if name == "" {
+		return nil, ErrKeyspaceNameIsEmpty
+	}
+	if db.options.IndexType == BPlusTree {
+		return nil, ErrKeyspaceUnsupported
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if _, ok := db.keyspaces[name]; ok {
+		return nil, ErrKeyspaceExists
+	}
+	ks := &Keyspace{
+		db:    db,
+		id:    db.nextKeyspaceId,
+		name:  name,
+		index: index.NewIndexer(db.options.IndexType, "", false),
+	}
+	db.keyspaces[name] = ks
+	db.keyspaceIds[ks.id] = ks
+	db.nextKeyspaceId++
+	if err := db.saveKeyspaces(); err != nil {
+		delete(db.keyspaces, name)
+		delete(db.keyspaceIds, ks.id)
+		db.nextKeyspaceId--
+		return nil, err
+	}
+	return ks, nil
+}
+
+// Keyspace 获取已经存在的 keyspace
+func (db *DB) Keyspace(name string) (*Keyspace, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	ks, ok := db.keyspaces[name]
+	if !ok {
+		return nil, ErrKeyspaceNotFound
+	}
+	return ks, nil
+}
+
+// ListKeyspaces 获取所有 keyspace 的名称，按名称排序
+func (db *DB) ListKeyspaces() []string {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	names := make([]string, 0, len(db.keyspaces))
+	for name := range db.keyspaces {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// DropKeyspace 删除 keyspace 及其中所有的数据
+// 不需要为每个 key 写入删除记录，只从 keyspace 信息中移除，数据在 merge 时被清理
+func (db *DB) DropKeyspace(name string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	ks, ok := db.keyspaces[name]
+	if !ok {
+		return ErrKeyspaceNotFound
+	}
+	delete(db.keyspaces, name)
+	delete(db.keyspaceIds, ks.id)
+	if err := db.saveKeyspaces(); err != nil {
+		db.keyspaces[name] = ks
+		db.keyspaceIds[ks.id] = ks
+		return err
+	}
+	// keyspace 中的所有数据都变为无效数据
+	db.reclaimableSize += ks.dataSize
+	ks.dropped = true
+	ks.dataSize = 0
+	ks.reclaimableSize = 0
+	_ = ks.index.Close()
+	ks.index = index.NewIndexer(db.options.IndexType, "", false)
+	return nil
+}
+
+// Name keyspace 的名称
+func (ks *Keyspace) Name() string {
+	return ks.name
+}
+
+// Put 写入 key value 数据到 keyspace 中
+func (ks *Keyspace) Put(key, value []byte) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	ks.db.mu.Lock()
+	defer ks.db.mu.Unlock()
+	if ks.dropped {
+		return ErrKeyspaceNotFound
+	}
+	logRecord := &data.LogRecord{
+		Value:    value,
+		Type:     data.LogRecordNormal,
+		Keyspace: ks.id,
+	}
+	if ks.db.isBlobValue(value) {
+		var err error
+		if logRecord, err = ks.db.blobLogRecord(ks.id, key, value); err != nil {
+			return err
+		}
+	}
+	logRecord.Key = logRecordKeyWithSeqNo(key, nonTransactionSeqNo)
+	pos, err := ks.db.appendLogRecord(logRecord)
+	if err != nil {
+		return err
+	}
+	ks.applyIndex([]*index.BatchEntry{{Key: key, Pos: pos}})
+	return nil
+}
+
+// Get 根据 key 读取 keyspace 中的数据
+func (ks *Keyspace) Get(key []byte) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, ErrKeyIsEmpty
+	}
+	ks.db.mu.RLock()
+	defer ks.db.mu.RUnlock()
+	if ks.dropped {
+		return nil, ErrKeyspaceNotFound
+	}
+	logRecordPos := ks.index.Get(key)
+	if logRecordPos == nil {
+		return nil, ErrKeyNotFound
+	}
+	return ks.db.getValueByPosition(logRecordPos)
+}
+
+// Delete 根据 key 删除 keyspace 中的数据
+func (ks *Keyspace) Delete(key []byte) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	ks.db.mu.Lock()
+	defer ks.db.mu.Unlock()
+	if ks.dropped {
+		return ErrKeyspaceNotFound
+	}
+	if ks.index.Get(key) == nil {
+		return nil
+	}
+	logRecord := &data.LogRecord{
+		Key:      logRecordKeyWithSeqNo(key, nonTransactionSeqNo),
+		Type:     data.LogRecordDeleted,
+		Keyspace: ks.id,
+	}
+	pos, err := ks.db.appendLogRecord(logRecord)
+	if err != nil {
+		return err
+	}
+	ks.addReclaimable(int64(pos.Size))
+	ks.applyIndex([]*index.BatchEntry{{Key: key}})
+	return nil
+}
+
+// NewIterator 初始化 keyspace 的迭代器
+func (ks *Keyspace) NewIterator(opts IteratorOptions) *Iterator {
+	ks.db.mu.RLock()
+	defer ks.db.mu.RUnlock()
+	return &Iterator{
+		indexIter: ks.index.Iterator(opts.Reverse),
+		db:        ks.db,
+		Options:   opts,
+	}
+}
+
+// ListKeys 获取 keyspace 中所有的 key
+func (ks *Keyspace) ListKeys() [][]byte {
+	ks.db.mu.RLock()
+	defer ks.db.mu.RUnlock()
+	iterator := ks.index.Iterator(false)
+	defer iterator.Close()
+	keys := make([][]byte, 0, ks.index.Size())
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		keys = append(keys, iterator.Key())
+	}
+	return keys
+}
+
+// Fold 获取 keyspace 中所有的数据，并执行用户指定的操作，函数返回 false 时终止遍历
+func (ks *Keyspace) Fold(fn func(key, value []byte) bool) error {
+	ks.db.mu.RLock()
+	defer ks.db.mu.RUnlock()
+	iterator := ks.index.Iterator(false)
+	defer iterator.Close()
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		value, err := ks.db.getValueByPosition(iterator.Value())
+		if err != nil {
+			return err
+		}
+		if !fn(iterator.Key(), value) {
+			break
+		}
+	}
+	return nil
+}
+
+// Stat 返回 keyspace 的相关统计信息
+func (ks *Keyspace) Stat() *KeyspaceStat {
+	ks.db.mu.RLock()
+	defer ks.db.mu.RUnlock()
+	return &KeyspaceStat{
+		KeyNum:          uint(ks.index.Size()),
+		DataSize:        ks.dataSize,
+		ReclaimableSize: ks.reclaimableSize,
+	}
+}
+
+// Merge keyspace 中无效数据的比例达到 DataFileMergeRatio 时进行 merge
+// 不需要等到整个数据库的无效数据达到阈值，merge 同样会清理其他 keyspace 中的无效数据
+func (ks *Keyspace) Merge() error {
+	return ks.db.merge(func(int64) error {
+		if ks.dropped {
+			return ErrKeyspaceNotFound
+		}
+		totalSize := ks.dataSize + ks.reclaimableSize
+		if totalSize == 0 || float32(ks.reclaimableSize)/float32(totalSize) < ks.db.options.DataFileMergeRatio {
+			return ErrMergeRatioUnreached
+		}
+		return nil
+	})
+}
+
+// applyIndex 更新 keyspace 的索引和统计信息，调用时需要持有 db.mu
+func (ks *Keyspace) applyIndex(entries []*index.BatchEntry) {
+	for _, entry := range entries {
+		if entry.Pos != nil {
+			ks.dataSize += int64(entry.Pos.Size)
+		}
+	}
+	for _, oldPos := range ks.index.ApplyBatch(entries) {
+		if oldPos != nil {
+			ks.dataSize -= int64(oldPos.Size)
+			ks.addReclaimable(int64(oldPos.Size))
+		}
+	}
+}
+
+// addReclaimable 同时累加 keyspace 和整个数据库的无效数据量
+func (ks *Keyspace) addReclaimable(size int64) {
+	ks.reclaimableSize += size
+	ks.db.reclaimableSize += size
+}
+
+// loadKeyspaceRecord 启动时将非默认 keyspace 的记录更新到对应的索引中
+// keyspace 已经被删除时记录直接计入无效数据
+func (db *DB) loadKeyspaceRecord(keyspace uint32, key []byte, typ data.LogRecordType, pos *data.LogRecordPos) {
+	ks, ok := db.keyspaceIds[keyspace]
+	if !ok {
+		db.reclaimableSize += int64(pos.Size)
+		return
+	}
+	if typ == data.LogRecordDeleted {
+		ks.addReclaimable(int64(pos.Size))
+		ks.applyIndex([]*index.BatchEntry{{Key: key}})
+		return
+	}
+	ks.applyIndex([]*index.BatchEntry{{Key: key, Pos: pos}})
+}
+
+// loadKeyspaces 从 keyspace 信息文件中加载所有的 keyspace
+// 每个 keyspace 一条记录，key 为名称，value 为 id；key 为空的记录保存下一个可用的 id
+func (db *DB) loadKeyspaces() error {
+	db.nextKeyspaceId = 1
+	fileName := filepath.Join(db.options.DirPath, data.KeyspaceFileName)
+	if _, err := db.fs.Stat(fileName); os.IsNotExist(err) {
+		return nil
+	}
+	keyspaceFile, err := data.OpenKeyspaceFile(db.fs, db.options.DirPath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = keyspaceFile.Close()
+	}()
+
+	var offset int64 = 0
+	for {
+		record, size, err := keyspaceFile.ReadLogRecord(offset)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return err
+		}
+		offset += size
+		id, _ := binary.Uvarint(record.Value)
+		if len(record.Key) == 0 {
+			db.nextKeyspaceId = uint32(id)
+			continue
+		}
+		ks := &Keyspace{
+			db:    db,
+			id:    uint32(id),
+			name:  string(record.Key),
+			index: index.NewIndexer(db.options.IndexType, "", false),
+		}
+		db.keyspaces[ks.name] = ks
+		db.keyspaceIds[ks.id] = ks
+	}
+	if len(db.keyspaces) > 0 && db.options.IndexType == BPlusTree {
+		return ErrKeyspaceUnsupported
+	}
+	return nil
+}
+
+// saveKeyspaces 重写 keyspace 信息文件，调用时需要持有 db.mu
+func (db *DB) saveKeyspaces() error {
+	var records []byte
+	for name, ks := range db.keyspaces {
+		encRecord, _ := data.EncodeLogRecord(&data.LogRecord{
+			Key:   []byte(name),
+			Value: binary.AppendUvarint(nil, uint64(ks.id)),
+		})
+		records = append(records, encRecord...)
+	}
+	encRecord, _ := data.EncodeLogRecord(&data.LogRecord{
+		Value: binary.AppendUvarint(nil, uint64(db.nextKeyspaceId)),
+	})
+	records = append(records, encRecord...)
+	return data.WriteKeyspaceFile(db.fs, db.options.DirPath, records)
+}
+
+// keyspaceIndexes 获取所有 keyspace 当前的索引，调用时需要持有 db.mu
+func (db *DB) keyspaceIndexes() map[uint32]index.Index {
+	indexes := make(map[uint32]index.Index, len(db.keyspaceIds)+1)
+	indexes[0] = db.index
+	for id, ks := range db.keyspaceIds {
+		indexes[id] = ks.index
+	}
+	return indexes
+}
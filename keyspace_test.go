@@ -0,0 +1,229 @@
+package bitcask_db
+
+import (
+	"bitcask-db/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestDB_Keyspace(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-db-keyspace")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	users, err := db.CreateKeyspace("users")
+	assert.Nil(t, err)
+	_, err = db.CreateKeyspace("users")
+	assert.Equal(t, ErrKeyspaceExists, err)
+	_, err = db.CreateKeyspace("")
+	assert.Equal(t, ErrKeyspaceNameIsEmpty, err)
+	orders, err := db.CreateKeyspace("orders")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"orders", "users"}, db.ListKeyspaces())
+
+	// 相同的 key 在不同的 keyspace 之间互不影响
+	key := utils.GetTestKey(1)
+	assert.Nil(t, db.Put(key, []byte("default")))
+	assert.Nil(t, users.Put(key, []byte("users")))
+	val, err := orders.Get(key)
+	assert.Equal(t, ErrKeyNotFound, err)
+	assert.Nil(t, val)
+	val, err = users.Get(key)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("users"), val)
+	val, err = db.Get(key)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("default"), val)
+
+	assert.Nil(t, users.Delete(key))
+	_, err = users.Get(key)
+	assert.Equal(t, ErrKeyNotFound, err)
+	val, err = db.Get(key)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("default"), val)
+
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, orders.Put(utils.GetTestKey(i), utils.RandomValue(16)))
+	}
+	assert.Equal(t, 10, len(orders.ListKeys()))
+	assert.Equal(t, uint(10), orders.Stat().KeyNum)
+	assert.Equal(t, uint(1), db.Stat().KeyNum)
+	iter := orders.NewIterator(DefaultIteratorOptions)
+	var count int
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		_, err := iter.Value()
+		assert.Nil(t, err)
+		count++
+	}
+	iter.Close()
+	assert.Equal(t, 10, count)
+
+	ks, err := db.Keyspace("orders")
+	assert.Nil(t, err)
+	assert.Equal(t, orders, ks)
+	_, err = db.Keyspace("not-exist")
+	assert.Equal(t, ErrKeyspaceNotFound, err)
+}
+
+func TestDB_KeyspaceRestart(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-db-keyspace-restart")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	db, err := Open(opts)
+	assert.Nil(t, err)
+
+	users, err := db.CreateKeyspace("users")
+	assert.Nil(t, err)
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, users.Put(utils.GetTestKey(i), utils.RandomValue(64)))
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(64)))
+	}
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, users.Delete(utils.GetTestKey(i)))
+	}
+	// 在同一个事务中写入多个 keyspace
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.PutIn(users, []byte("txn"), []byte("users")))
+	assert.Nil(t, wb.Put([]byte("txn"), []byte("default")))
+	assert.Nil(t, wb.DeleteIn(users, utils.GetTestKey(100)))
+	assert.Nil(t, wb.Commit())
+	stat := users.Stat()
+	assert.Nil(t, db.Close())
+
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	users2, err := db2.Keyspace("users")
+	assert.Nil(t, err)
+	assert.Equal(t, stat, users2.Stat())
+	assert.Equal(t, uint(900), users2.Stat().KeyNum)
+	assert.Equal(t, uint(1001), db2.Stat().KeyNum)
+	val, err := users2.Get([]byte("txn"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("users"), val)
+	val, err = db2.Get([]byte("txn"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("default"), val)
+	_, err = users2.Get(utils.GetTestKey(100))
+	assert.Equal(t, ErrKeyNotFound, err)
+	_, err = users2.Get(utils.GetTestKey(500))
+	assert.Nil(t, err)
+}
+
+func TestDB_DropKeyspace(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-db-drop-keyspace")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	assert.Nil(t, err)
+
+	users, err := db.CreateKeyspace("users")
+	assert.Nil(t, err)
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, users.Put(utils.GetTestKey(i), utils.RandomValue(64)))
+	}
+	assert.Nil(t, db.Put([]byte("default"), []byte("value")))
+	reclaimable := db.Stat().ReclaimableSize
+	assert.Nil(t, db.DropKeyspace("users"))
+	assert.True(t, db.Stat().ReclaimableSize > reclaimable)
+	assert.Equal(t, ErrKeyspaceNotFound, db.DropKeyspace("users"))
+	_, err = users.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyspaceNotFound, err)
+	assert.Equal(t, ErrKeyspaceNotFound, users.Put(utils.GetTestKey(1), []byte("value")))
+
+	// 同名的 keyspace 重新创建之后，之前的数据不会再出现
+	users, err = db.CreateKeyspace("users")
+	assert.Nil(t, err)
+	assert.Nil(t, users.Put(utils.GetTestKey(1), []byte("new")))
+	assert.Equal(t, uint(1), users.Stat().KeyNum)
+
+	// 已经删除的 keyspace 不能再提交事务
+	orders, err := db.CreateKeyspace("orders")
+	assert.Nil(t, err)
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.PutIn(orders, []byte("k"), []byte("v")))
+	assert.Nil(t, db.DropKeyspace("orders"))
+	assert.Equal(t, ErrKeyspaceNotFound, wb.Commit())
+
+	assert.Nil(t, db.Merge())
+	assert.Nil(t, db.Close())
+
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"users"}, db2.ListKeyspaces())
+	users2, err := db2.Keyspace("users")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(users2.ListKeys()))
+	val, err := users2.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("new"), val)
+	val, err = db2.Get([]byte("default"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value"), val)
+	// 删除的 keyspace 的数据在 merge 时被清理
+	assert.True(t, db2.Stat().DiskSize < 64*1024)
+}
+
+func TestKeyspace_Merge(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-db-keyspace-merge")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	db, err := Open(opts)
+	assert.Nil(t, err)
+
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(1024)))
+	}
+	logs, err := db.CreateKeyspace("logs")
+	assert.Nil(t, err)
+	for n := 0; n < 3; n++ {
+		for i := 0; i < 100; i++ {
+			assert.Nil(t, logs.Put(utils.GetTestKey(i), utils.RandomValue(128)))
+		}
+	}
+	orders, err := db.CreateKeyspace("orders")
+	assert.Nil(t, err)
+	assert.Nil(t, orders.Put([]byte("k"), []byte("v")))
+
+	// 整个数据库的无效数据没有达到阈值，只有 logs 中的无效数据达到了阈值
+	assert.Equal(t, ErrMergeRatioUnreached, db.Merge())
+	assert.Equal(t, ErrMergeRatioUnreached, orders.Merge())
+	assert.Nil(t, logs.Merge())
+	assert.Nil(t, db.DropKeyspace("orders"))
+	assert.Equal(t, ErrKeyspaceNotFound, orders.Merge())
+	assert.Nil(t, db.Close())
+
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	logs2, err := db2.Keyspace("logs")
+	assert.Nil(t, err)
+	assert.Equal(t, uint(100), logs2.Stat().KeyNum)
+	assert.Equal(t, int64(0), logs2.Stat().ReclaimableSize)
+	assert.Equal(t, uint(1000), db2.Stat().KeyNum)
+}
+
+func TestDB_KeyspaceBPlusTree(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-db-keyspace-bptree")
+	opts.DirPath = dir
+	opts.IndexType = BPlusTree
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	// B+ 树索引不支持 keyspace
+	_, err = db.CreateKeyspace("users")
+	assert.Equal(t, ErrKeyspaceUnsupported, err)
+	_, err = db.Keyspace("users")
+	assert.Equal(t, ErrKeyspaceNotFound, err)
+}
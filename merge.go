@@ -2,22 +2,36 @@ package bitcask_db
 
 import (
 	"bitcask-db/data"
-	"bitcask-db/utils"
+	"bitcask-db/index"
 	"io"
 	"os"
 	"path"
 	"path/filepath"
 	"sort"
 	"strconv"
+	"sync/atomic"
 )
 
 const (
 	mergeDirName     = "-merge"
 	mergeFinishedKey = "merge.finished"
+	versionSeqKey    = "version.seq"
+	indexBatchSize   = 10000 // 批量更新索引时每一批的数量
 )
 
 // Merge 清理无效数据，生成 Hint 文件
 func (db *DB) Merge() error {
+	return db.merge(func(totalSize int64) error {
+		if float32(db.reclaimableSize)/float32(totalSize) < db.options.DataFileMergeRatio {
+			return ErrMergeRatioUnreached
+		}
+		return nil
+	})
+}
+
+// merge 清理无效数据，checkRatio 判断无效数据的比例是否达到了 merge 的阈值，totalSize 为数据目录的大小
+// 调用 checkRatio 时持有 db.mu
+func (db *DB) merge(checkRatio func(totalSize int64) error) (err error) {
 	// 如果数据库为空，直接返回
 	if db.activeFile == nil {
 		return nil
@@ -31,17 +45,17 @@ func (db *DB) Merge() error {
 	}
 
 	// 查看可以 merge 的数据量是否达到了阈值
-	totalSize, err := utils.DirSize(db.options.DirPath)
+	totalSize, err := db.fs.DirSize(db.options.DirPath)
 	if err != nil {
 		db.mu.Unlock()
 		return err
 	}
-	if float32(db.reclaimableSize)/float32(totalSize) < db.options.DataFileMergeRatio {
+	if err := checkRatio(totalSize); err != nil {
 		db.mu.Unlock()
-		return ErrMergeRatioUnreached
+		return err
 	}
 	// 查看剩余的空间容量是否可以容纳 merge 之后的数据量
-	availableDiskSize, err := utils.AvailableDiskSize()
+	availableDiskSize, err := db.fs.AvailableDiskSize()
 	if err != nil {
 		db.mu.Unlock()
 		return err
@@ -56,23 +70,32 @@ func (db *DB) Merge() error {
 		db.isMerging = false
 	}()
 
-	// 持久化当前活跃文件
-	if err := db.activeFile.Sync(); err != nil {
+	// 持久化当前活跃文件，转换为旧的数据文件，并打开新的活跃文件
+	if err := db.rotateActiveFile(); err != nil {
 		db.mu.Unlock()
 		return err
 	}
-
-	// 将当前活跃文件转换为旧的数据文件
-	db.olderFiles[db.activeFile.FileId] = db.activeFile
-
-	// 打开新的活跃文件
-	if err := db.setActiveDataFile(); err != nil {
-		db.mu.Unlock()
-		return nil
-	}
 	// 记录最近没有参与 merge 的文件 ID
 
 	nonMergeFileId := db.activeFile.FileId
+	// 参与 merge 的文件中记录的位置会被重写，之后的操作数记录不能再引用这些记录
+	// 写入 merge 完成标识之前失败时，这些文件不会被替换，恢复之前的限制
+	operandFloor := db.operandFloor
+	db.operandFloor = nonMergeFileId
+	mergeFinishing := false
+	defer func() {
+		if err != nil && !mergeFinishing {
+			db.mu.Lock()
+			db.operandFloor = operandFloor
+			db.mu.Unlock()
+		}
+	}()
+	// 参与 merge 的记录 key 中不再保留序列号，需要单独记录
+	mergeSeqNo := atomic.LoadUint64(&db.seqNo)
+	mergeVersionSeq := atomic.LoadUint64(&db.versionSeq)
+
+	// 取出所有 keyspace 的索引，用于判断记录是否有效，之后删除的 keyspace 不影响这次 merge
+	indexes := db.keyspaceIndexes()
 
 	// 取出所有需要 merge 的文件
 	var mergeFiles []*data.DataFile
@@ -86,31 +109,39 @@ func (db *DB) Merge() error {
 	})
 	mergePath := db.getMergePath()
 	// 如果目录存在，说明发生过 merge ，将其删除
-	if _, err := os.Stat(mergePath); err == nil {
-		if err = os.RemoveAll(mergePath); err != nil {
+	if _, err := db.fs.Stat(mergePath); err == nil {
+		if err = db.fs.RemoveAll(mergePath); err != nil {
 			return err
 		}
 	}
 
 	// 新建一个 merge path 目录
-	if err := os.MkdirAll(mergePath, os.ModePerm); err != nil {
+	if err := db.fs.MkdirAll(mergePath); err != nil {
 		return err
 	}
 	// 打开一个新的临时 bitcask 实例
 	mergeOptions := db.options
 	mergeOptions.DirPath = mergePath
 	mergeOptions.SyncWrites = false
+	mergeOptions.IndexSnapshotOnClose = false
 
 	mergeDB, err := Open(mergeOptions)
 	if err != nil {
 		return err
 	}
+	// merge 结束或者失败时关闭临时实例，释放文件锁
+	defer func() {
+		_ = mergeDB.Close()
+	}()
 
 	// 打开 hint 文件存储索引
-	hintFile, err := data.OpenHintFile(mergePath)
+	hintFile, err := data.OpenHintFile(db.fs, mergePath)
 	if err != nil {
 		return err
 	}
+	defer func() {
+		_ = hintFile.Close()
+	}()
 
 	// 遍历处理每个数据文件
 	for _, dataFile := range mergeFiles {
@@ -125,20 +156,57 @@ func (db *DB) Merge() error {
 			}
 			// 解析获取实际的key
 			realKey, _ := parseLogRecordKey(logRecord.Key)
-			logRecordPos := db.index.Get(realKey)
+			// 已经删除的 keyspace 中的记录都是无效的
+			var logRecordPos *data.LogRecordPos
+			if keyspaceIndex, ok := indexes[logRecord.Keyspace]; ok {
+				logRecordPos = keyspaceIndex.Get(realKey)
+			}
+			// 删除之后保留的历史版本同样需要重写
+			if logRecordPos == nil && logRecord.Type == data.LogRecordVersionedDeleted && db.historyEnabled() {
+				logRecordPos = db.deletedVersions.Get(realKey)
+			}
 			// 和内存中的索引位置进行比较，如果有效则重写
 			if logRecordPos != nil &&
 				logRecordPos.Fid == dataFile.FileId &&
 				logRecordPos.Offset == offset {
-				// 清楚事务标记
-				logRecord.Key = logRecordKeyWithSeqNo(realKey, nonTransactionSeqNo)
-				pos, err := mergeDB.appendLogRecord(logRecord)
+				var pos *data.LogRecordPos
+				// 重写之后的记录保留原来的版本号
+				logRecord.Version = recordVersion(logRecord, logRecordPos)
+				if db.historyEnabled() && isVersionedRecord(logRecord.Type) {
+					// 保留的历史版本和最新的版本一起重写
+					pos, err = db.mergeVersions(mergeDB, realKey, logRecordPos, logRecord.Version)
+				} else {
+					// 清楚事务标记
+					logRecord.Key = logRecordKeyWithSeqNo(realKey, nonTransactionSeqNo)
+					// 操作数记录和之前的值合并为一条普通记录
+					if logRecord.Type == data.LogRecordMerge {
+						value, err := db.foldOperands(logRecord)
+						if err != nil {
+							return err
+						}
+						logRecord.Value = value
+						logRecord.Type = data.LogRecordNormal
+					}
+					// 不再保留历史版本时去掉之前版本的位置
+					if isVersionedRecord(logRecord.Type) {
+						logRecord.Value = recordValue(logRecord)
+						logRecord.Type = data.LogRecordNormal
+					}
+					// 分块和分块清单一起重写
+					if logRecord.Type == data.LogRecordStream {
+						pos, err = db.mergeStream(mergeDB, logRecord)
+					} else {
+						pos, err = mergeDB.appendLogRecord(logRecord)
+					}
+				}
 				if err != nil {
 					return err
 				}
-				// 将当前位置索引写到 Hint 文件
-				if err := hintFile.WriteHintRecord(realKey, pos); err != nil {
-					return err
+				// 将当前位置索引写到 Hint 文件，删除版本之前的版本都不再保留时不需要写入
+				if pos != nil {
+					if err := hintFile.WriteHintRecord(logRecord.Keyspace, realKey, logRecord.Type, pos); err != nil {
+						return err
+					}
 				}
 			}
 			// 增加 offset
@@ -153,10 +221,14 @@ func (db *DB) Merge() error {
 		return err
 	}
 	// 写标识 merge 完成
-	mergeFinishedFile, err := data.OpenMergeFinishedFile(mergePath)
+	mergeFinishing = true
+	mergeFinishedFile, err := data.OpenMergeFinishedFile(db.fs, mergePath)
 	if err != nil {
 		return err
 	}
+	defer func() {
+		_ = mergeFinishedFile.Close()
+	}()
 	mergeFinRecord := &data.LogRecord{
 		Key:   []byte(mergeFinishedKey),
 		Value: []byte(strconv.Itoa(int(nonMergeFileId))),
@@ -165,6 +237,22 @@ func (db *DB) Merge() error {
 	if err := mergeFinishedFile.Write(encRecord); err != nil {
 		return err
 	}
+	seqNoRecord := &data.LogRecord{
+		Key:   []byte(seqNokey),
+		Value: []byte(strconv.FormatUint(mergeSeqNo, 10)),
+	}
+	encRecord, _ = data.EncodeLogRecord(seqNoRecord)
+	if err := mergeFinishedFile.Write(encRecord); err != nil {
+		return err
+	}
+	versionSeqRecord := &data.LogRecord{
+		Key:   []byte(versionSeqKey),
+		Value: []byte(strconv.FormatUint(mergeVersionSeq, 10)),
+	}
+	encRecord, _ = data.EncodeLogRecord(versionSeqRecord)
+	if err := mergeFinishedFile.Write(encRecord); err != nil {
+		return err
+	}
 
 	if err := mergeFinishedFile.Sync(); err != nil {
 		return err
@@ -184,33 +272,33 @@ func (db *DB) getMergePath() string {
 func (db *DB) loadMergeFiles() error {
 	mergePath := db.getMergePath()
 	// merge 目录不存在的话直接返回
-	if _, err := os.Stat(mergePath); os.IsNotExist(err) {
+	if _, err := db.fs.Stat(mergePath); os.IsNotExist(err) {
 		return nil
 	}
 	defer func() {
-		_ = os.Remove(mergePath)
+		_ = db.fs.Remove(mergePath)
 	}()
 
-	dirEntries, err := os.ReadDir(mergePath)
+	fileNames, err := db.fs.ReadDir(mergePath)
 	if err != nil {
 		return err
 	}
 	// 查找标识 merge 完成的文件，判断 merge 是否处理完了
 	var mergFinished bool
 	var mergeFileNames []string
-	for _, entry := range dirEntries {
-		if entry.Name() == data.MergeFinishedFileName {
+	for _, fileName := range fileNames {
+		if fileName == data.MergeFinishedFileName {
 			mergFinished = true
 		}
 
-		if entry.Name() == data.SeqNoFileName {
+		if fileName == data.SeqNoFileName {
 			continue
 		}
-		if entry.Name() == fileLockName {
+		if fileName == fileLockName {
 			continue
 		}
 
-		mergeFileNames = append(mergeFileNames, entry.Name())
+		mergeFileNames = append(mergeFileNames, fileName)
 	}
 	// merge 没有完成则直接返回
 	if !mergFinished {
@@ -226,27 +314,42 @@ func (db *DB) loadMergeFiles() error {
 	var fileId uint32 = 0
 	for ; fileId < nonMergeFileId; fileId++ {
 		fileName := data.GetDataFileName(db.options.DirPath, fileId)
-		if _, err := os.Stat(fileName); err == nil {
-			if err := os.Remove(fileName); err != nil {
+		if _, err := db.fs.Stat(fileName); err == nil {
+			if err := db.fs.Remove(fileName); err != nil {
+				return err
+			}
+		}
+		// 旧数据文件对应的 hint 文件也一并删除
+		hintFileName := data.GetHintFileName(db.options.DirPath, fileId)
+		if _, err := db.fs.Stat(hintFileName); err == nil {
+			if err := db.fs.Remove(hintFileName); err != nil {
 				return err
 			}
 		}
 
 	}
+	// 索引快照中的位置信息已经失效
+	snapshotFileName := filepath.Join(db.options.DirPath, data.IndexSnapshotFileName)
+	if _, err := db.fs.Stat(snapshotFileName); err == nil {
+		if err := db.fs.Remove(snapshotFileName); err != nil {
+			return err
+		}
+	}
 	// 将新的数据文件移动到数据目录中
 	for _, fileName := range mergeFileNames {
 		srcPath := filepath.Join(mergePath, fileName)
 		destPath := filepath.Join(db.options.DirPath, fileName)
-		if err := os.Rename(srcPath, destPath); err != nil {
+		if err := db.fs.Rename(srcPath, destPath); err != nil {
 			return err
 		}
 	}
+	db.mergeLoaded = true
 
 	return nil
 }
 
 func (db *DB) getNonMergeFileId(dirPath string) (uint32, error) {
-	mergeFinishedFile, err := data.OpenMergeFinishedFile(dirPath)
+	mergeFinishedFile, err := data.OpenMergeFinishedFile(db.fs, dirPath)
 	if err != nil {
 		return 0, err
 	}
@@ -261,21 +364,79 @@ func (db *DB) getNonMergeFileId(dirPath string) (uint32, error) {
 	return uint32(nonMergeFileId), nil
 }
 
+// getMergeSeqNo 获取 merge 开始时的事务序列号和版本号，之前版本的 merge 没有记录时返回 0
+func (db *DB) getMergeSeqNo(dirPath string) (uint64, uint64, error) {
+	mergeFinishedFile, err := data.OpenMergeFinishedFile(db.fs, dirPath)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer func() {
+		_ = mergeFinishedFile.Close()
+	}()
+	// 第一条记录之后依次是事务序列号和版本号
+	var seqs [2]uint64
+	_, offset, err := mergeFinishedFile.ReadLogRecord(0)
+	if err != nil {
+		return 0, 0, err
+	}
+	for i := range seqs {
+		record, size, err := mergeFinishedFile.ReadLogRecord(offset)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, 0, err
+		}
+		if seqs[i], err = strconv.ParseUint(string(record.Value), 10, 64); err != nil {
+			return 0, 0, err
+		}
+		offset += size
+	}
+	return seqs[0], seqs[1], nil
+}
+
+// mergeVersions 将 key 保留的历史版本和最新的版本按照从旧到新的顺序写入 merge 的数据文件
+// 最新的版本是删除版本并且之前的版本都不再保留时不写入，返回空
+func (db *DB) mergeVersions(mergeDB *DB, key []byte, pos *data.LogRecordPos, version uint64) (*data.LogRecordPos, error) {
+	versions, err := db.readVersions(key, pos)
+	if err != nil {
+		return nil, err
+	}
+	latest := versions[0]
+	if latest.Deleted && len(versions) == 1 {
+		return nil, nil
+	}
+	if latest.Prev, err = db.writeVersions(key, versions[1:], mergeDB.appendLogRecord); err != nil {
+		return nil, err
+	}
+	recordType := data.LogRecordVersioned
+	if latest.Deleted {
+		recordType = data.LogRecordVersionedDeleted
+	}
+	return mergeDB.appendLogRecord(&data.LogRecord{
+		Key:     logRecordKeyWithSeqNo(key, nonTransactionSeqNo),
+		Value:   data.EncodeVersionedValue(latest),
+		Type:    recordType,
+		Version: version,
+	})
+}
+
 // loadIndexFromHintFile 从 hint 文件中加载索引
 func (db *DB) loadIndexFromHintFile() error {
 	// 查看 hint 索引文件是否存在
 	hintFileName := filepath.Join(db.options.DirPath, data.HintFileName)
-	if _, err := os.Stat(hintFileName); os.IsNotExist(err) {
+	if _, err := db.fs.Stat(hintFileName); os.IsNotExist(err) {
 		return nil
 	}
 	// 打开 hint 索引文件
-	hintFile, err := data.OpenHintFile(db.options.DirPath)
+	hintFile, err := data.OpenHintFile(db.fs, db.options.DirPath)
 	if err != nil {
 		return err
 	}
 
-	// 依次取出日志记录构造索引
+	// 依次取出日志记录构造索引，分批更新到索引中
 	var offset int64 = 0
+	entries := make([]*index.BatchEntry, 0, indexBatchSize)
 	for {
 		logRecord, size, err := hintFile.ReadLogRecord(offset)
 		if err != nil {
@@ -286,8 +447,31 @@ func (db *DB) loadIndexFromHintFile() error {
 		}
 		// 解码拿到位置信息
 		pos := data.DecodeLogRecordPos(logRecord.Value)
-		db.index.Put(logRecord.Key, pos)
 		offset += size
+		// 范围删除标记只作用于之前写入的数据
+		if logRecord.Type == data.LogRecordRangeDeleted {
+			db.index.ApplyBatch(entries)
+			entries = append(entries[:0], db.rangeEntries(data.DecodeKeyRange(logRecord.Key))...)
+			continue
+		}
+		// 其他 keyspace 的记录更新到各自的索引中
+		if logRecord.Keyspace != 0 {
+			db.loadKeyspaceRecord(logRecord.Keyspace, logRecord.Key, data.LogRecordNormal, pos)
+			continue
+		}
+		// 删除之后保留的历史版本
+		if logRecord.Type == data.LogRecordVersionedDeleted {
+			if db.historyEnabled() {
+				db.deletedVersions.Put(logRecord.Key, pos)
+			}
+			continue
+		}
+		entries = append(entries, &index.BatchEntry{Key: logRecord.Key, Pos: pos})
+		if len(entries) == indexBatchSize {
+			db.index.ApplyBatch(entries)
+			entries = entries[:0]
+		}
 	}
+	db.index.ApplyBatch(entries)
 	return nil
 }
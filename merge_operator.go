@@ -0,0 +1,187 @@
+package bitcask_db
+
+import (
+	"bitcask-db/data"
+	"bitcask-db/index"
+	"encoding/binary"
+)
+
+// maxMergeOperands 一个 key 最多连续的操作数记录数量，超出时合并为一条普通记录，避免读取时合并的链太长
+const maxMergeOperands = 64
+
+// MergeOperator 合并操作符，MergeValue 写入的操作数在读取以及 merge 时合并到之前的值上
+// 合并不能有副作用，同一个操作数可能被合并多次
+type MergeOperator interface {
+	// Merge 将操作数合并到已有的值上，key 不存在时 existing 为 nil，返回合并之后的值
+	Merge(key, existing, operand []byte) ([]byte, error)
+}
+
+// Int64AddOperator 将操作数加到已有的值上，值和操作数都是 8 字节大端编码的 int64，key 不存在时从 0 开始累加
+type Int64AddOperator struct{}
+
+func (Int64AddOperator) Merge(_, existing, operand []byte) ([]byte, error) {
+	delta, err := DecodeInt64(operand)
+	if err != nil {
+		return nil, err
+	}
+	var value int64
+	if existing != nil {
+		if value, err = DecodeInt64(existing); err != nil {
+			return nil, err
+		}
+	}
+	return EncodeInt64(value + delta), nil
+}
+
+// EncodeInt64 将 int64 编码为 Int64AddOperator 使用的值或操作数
+func EncodeInt64(n int64) []byte {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, uint64(n))
+	return buf
+}
+
+// DecodeInt64 解码 Int64AddOperator 使用的值或操作数
+func DecodeInt64(buf []byte) (int64, error) {
+	if len(buf) != 8 {
+		return 0, ErrInvalidMergeOperand
+	}
+	return int64(binary.BigEndian.Uint64(buf)), nil
+}
+
+// AppendOperator 将操作数追加到已有的值后面，Separator 不为空时在两者之间插入分隔符
+type AppendOperator struct {
+	Separator []byte
+}
+
+func (op AppendOperator) Merge(_, existing, operand []byte) ([]byte, error) {
+	if existing == nil {
+		return append([]byte{}, operand...), nil
+	}
+	value := make([]byte, 0, len(existing)+len(op.Separator)+len(operand))
+	value = append(value, existing...)
+	value = append(value, op.Separator...)
+	return append(value, operand...), nil
+}
+
+// MergeValue 写入操作数，读取时使用 Options.MergeOperator 合并到之前的值上
+// 只追加一条操作数记录，不需要读取之前的值，merge 时所有的操作数合并为一条普通记录
+// 保留历史版本或者配置了二级索引时需要合并之后的值，读取之前的值合并之后按照 Put 写入
+func (db *DB) MergeValue(key, operand []byte) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	operator := db.options.MergeOperator
+	if operator == nil {
+		return ErrMergeOperatorNotSet
+	}
+	// 提前校验操作数，避免写入之后这个 key 无法读取
+	if _, err := operator.Merge(key, nil, operand); err != nil {
+		return err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	prev := db.index.Get(key)
+	// 历史版本和二级索引都需要合并之后的值，直接合并之后写入
+	if db.historyEnabled() || len(db.secondaries) > 0 {
+		return db.mergeNow(key, prev, operand)
+	}
+	var depth uint32 = 1
+	if prev != nil {
+		// 之前的记录会被 merge 重写，直接合并为一条普通记录
+		if prev.Fid < db.operandFloor {
+			return db.mergeNow(key, prev, operand)
+		}
+		prevRecord, err := db.readLogRecord(prev)
+		if err != nil {
+			return err
+		}
+		if prevRecord.Type == data.LogRecordMerge {
+			prevDepth, _, _ := data.DecodeMergeOperand(prevRecord.Value)
+			if prevDepth >= maxMergeOperands {
+				return db.mergeNow(key, prev, operand)
+			}
+			depth = prevDepth + 1
+		}
+	}
+
+	logRecord := &data.LogRecord{
+		Key:   logRecordKeyWithSeqNo(key, nonTransactionSeqNo),
+		Value: data.EncodeMergeOperand(depth, prev, operand),
+		Type:  data.LogRecordMerge,
+	}
+	pos, err := db.appendLogRecord(logRecord)
+	if err != nil {
+		return err
+	}
+	// 之前的记录仍然在操作数链中，新的操作数记录的位置大小已经包含它，覆盖或者合并时才计入无效数据
+	// 没有配置二级索引，不需要合并之后的值
+	db.applyIndex([]*index.BatchEntry{{Key: key, Pos: pos}}, nil)
+	return nil
+}
+
+// mergeNow 读取之前的值，合并操作数之后写入一条普通记录，prev 为空时 key 不存在
+// 在访问此方法前必须持有互斥锁
+func (db *DB) mergeNow(key []byte, prev *data.LogRecordPos, operand []byte) error {
+	var existing []byte
+	if prev != nil {
+		var err error
+		if existing, err = db.getValueByPosition(prev); err != nil {
+			return err
+		}
+	}
+	value, err := db.options.MergeOperator.Merge(key, existing, operand)
+	if err != nil {
+		return err
+	}
+	return db.put(key, value)
+}
+
+// foldOperands 沿着操作数记录的链找到之前的值，按照写入的顺序依次合并所有的操作数
+func (db *DB) foldOperands(logRecord *data.LogRecord) ([]byte, error) {
+	operator := db.options.MergeOperator
+	if operator == nil {
+		return nil, ErrMergeOperatorNotSet
+	}
+	realKey, _ := parseLogRecordKey(logRecord.Key)
+
+	var operands [][]byte
+	record := logRecord
+	for record != nil && record.Type == data.LogRecordMerge {
+		_, prev, operand := data.DecodeMergeOperand(record.Value)
+		operands = append(operands, operand)
+		if prev == nil {
+			record = nil
+			continue
+		}
+		var err error
+		if record, err = db.readLogRecord(prev); err != nil {
+			return nil, err
+		}
+	}
+
+	var value []byte
+	if record != nil && (record.Type == data.LogRecordNormal || isVersionedRecord(record.Type)) {
+		value = recordValue(record)
+	}
+	if record != nil && record.Type == data.LogRecordStream {
+		var err error
+		if value, err = db.readStreamValue(record); err != nil {
+			return nil, err
+		}
+	}
+	if record != nil && record.Type == data.LogRecordBlob {
+		var err error
+		if value, err = db.readBlobValue(record); err != nil {
+			return nil, err
+		}
+	}
+	for i := len(operands) - 1; i >= 0; i-- {
+		merged, err := operator.Merge(realKey, value, operands[i])
+		if err != nil {
+			return nil, err
+		}
+		value = merged
+	}
+	return value, nil
+}
@@ -0,0 +1,210 @@
+package bitcask_db
+
+import (
+	"bitcask-db/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestDB_MergeValue(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-db-merge-value")
+	opts.DirPath = dir
+	opts.MergeOperator = Int64AddOperator{}
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	// key 不存在时从 0 开始累加
+	key := []byte("counter")
+	for i := 0; i < 100; i++ {
+		err := db.MergeValue(key, EncodeInt64(2))
+		assert.Nil(t, err)
+	}
+	val, err := db.Get(key)
+	assert.Nil(t, err)
+	n, err := DecodeInt64(val)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(200), n)
+
+	// 合并到普通记录上
+	err = db.Put(key, EncodeInt64(10))
+	assert.Nil(t, err)
+	err = db.MergeValue(key, EncodeInt64(-3))
+	assert.Nil(t, err)
+	val, err = db.Get(key)
+	assert.Nil(t, err)
+	n, _ = DecodeInt64(val)
+	assert.Equal(t, int64(7), n)
+
+	// 删除之后重新开始累加
+	err = db.Delete(key)
+	assert.Nil(t, err)
+	err = db.MergeValue(key, EncodeInt64(5))
+	assert.Nil(t, err)
+	val, err = db.Get(key)
+	assert.Nil(t, err)
+	n, _ = DecodeInt64(val)
+	assert.Equal(t, int64(5), n)
+
+	err = db.MergeValue(key, []byte("invalid"))
+	assert.Equal(t, ErrInvalidMergeOperand, err)
+
+	// 重启之后从数据文件中加载操作数记录
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	val, err = db2.Get(key)
+	assert.Nil(t, err)
+	n, _ = DecodeInt64(val)
+	assert.Equal(t, int64(5), n)
+	err = db2.Close()
+	assert.Nil(t, err)
+}
+
+func TestDB_MergeValueAppend(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-db-merge-value-append")
+	opts.DirPath = dir
+	opts.MergeOperator = AppendOperator{Separator: []byte(",")}
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	key := []byte("list")
+	err = db.MergeValue(key, []byte("a"))
+	assert.Nil(t, err)
+	err = db.MergeValue(key, []byte("b"))
+	assert.Nil(t, err)
+	err = db.MergeValue(key, []byte("c"))
+	assert.Nil(t, err)
+	val, err := db.Get(key)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("a,b,c"), val)
+
+	// 遍历时同样读取合并之后的值
+	var values [][]byte
+	err = db.Fold(func(key, value []byte) bool {
+		values = append(values, value)
+		return true
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("a,b,c")}, values)
+
+	// 没有设置合并操作符
+	opts2 := DefaultOptions
+	dir2, _ := os.MkdirTemp("", "bitcask-db-merge-value-unset")
+	opts2.DirPath = dir2
+	db2, err := Open(opts2)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	err = db2.MergeValue(key, []byte("a"))
+	assert.Equal(t, ErrMergeOperatorNotSet, err)
+}
+
+func TestDB_MergeValueCollapse(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-db-merge-value-collapse")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	opts.DataFileMergeRatio = 0
+	opts.MergeOperator = Int64AddOperator{}
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	// 操作数链超过最大长度时合并为普通记录
+	for i := 0; i < 3*maxMergeOperands; i++ {
+		for j := 0; j < 10; j++ {
+			err := db.MergeValue(utils.GetTestKey(j), EncodeInt64(1))
+			assert.Nil(t, err)
+		}
+		err := db.Put(utils.GetTestKey(100+i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+	assert.True(t, len(db.olderFiles) > 0)
+
+	err = db.Merge()
+	assert.Nil(t, err)
+	// merge 之后写入的操作数不能引用参与 merge 的记录
+	for j := 0; j < 10; j++ {
+		err := db.MergeValue(utils.GetTestKey(j), EncodeInt64(1))
+		assert.Nil(t, err)
+	}
+	for j := 0; j < 10; j++ {
+		val, err := db.Get(utils.GetTestKey(j))
+		assert.Nil(t, err)
+		n, _ := DecodeInt64(val)
+		assert.Equal(t, int64(3*maxMergeOperands+1), n)
+	}
+
+	// 重启之后加载 merge 的数据
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	for j := 0; j < 10; j++ {
+		err := db2.MergeValue(utils.GetTestKey(j), EncodeInt64(1))
+		assert.Nil(t, err)
+		val, err := db2.Get(utils.GetTestKey(j))
+		assert.Nil(t, err)
+		n, _ := DecodeInt64(val)
+		assert.Equal(t, int64(3*maxMergeOperands+2), n)
+	}
+	err = db2.Close()
+	assert.Nil(t, err)
+}
+
+func TestDB_MergeValueReclaimable(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-db-merge-value-reclaimable")
+	opts.DirPath = dir
+	opts.MergeOperator = Int64AddOperator{}
+	db, err := Open(opts)
+	assert.Nil(t, err)
+
+	// 操作数链中的记录仍然有效
+	key := []byte("counter")
+	assert.Nil(t, db.Put(key, EncodeInt64(1)))
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, db.MergeValue(key, EncodeInt64(1)))
+	}
+	assert.Equal(t, int64(0), db.Stat().ReclaimableSize)
+	chainSize := int64(db.index.Get(key).Size)
+
+	// 覆盖之后整个操作数链都是无效数据，重启之后得到同样的结果
+	assert.Nil(t, db.Put(key, EncodeInt64(100)))
+	assert.Equal(t, chainSize, db.Stat().ReclaimableSize)
+	assert.Nil(t, db.Close())
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	assert.Equal(t, chainSize, db2.Stat().ReclaimableSize)
+}
+
+func TestDB_MergeValueHistory(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-db-merge-value-history")
+	opts.DirPath = dir
+	opts.MergeOperator = Int64AddOperator{}
+	opts.HistoryVersions = 10
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	// 保留历史版本时每个操作数都写入一个新的版本
+	key := []byte("counter")
+	assert.Nil(t, db.MergeValue(key, EncodeInt64(1)))
+	seq := db.Seq()
+	assert.Nil(t, db.MergeValue(key, EncodeInt64(2)))
+	history, err := db.History(key)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(history))
+	assert.Equal(t, EncodeInt64(3), history[0].Value)
+	assert.Equal(t, EncodeInt64(1), history[1].Value)
+	val, err := db.GetAt(key, seq)
+	assert.Nil(t, err)
+	assert.Equal(t, EncodeInt64(1), val)
+}
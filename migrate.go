@@ -0,0 +1,100 @@
+package bitcask_db
+
+import (
+	"bitcask-db/data"
+	"bitcask-db/fio"
+	"bitcask-db/index"
+	"path/filepath"
+)
+
+// MigrateIndexType 将数据目录从 options.IndexType 转换为 target 索引类型
+// 转换为 B+ 树索引时，根据数据文件构建 bptree-index 文件并保存事务序列号
+// 从 B+ 树索引转换为内存索引时，删除 bptree-index 和 seq-no 文件，启动时从数据文件重建索引
+// 转换期间数据目录不能被其他进程打开
+func MigrateIndexType(options Options, target IndexerType) error {
+	if err := checkOptions(options); err != nil {
+		return err
+	}
+	if target != BTree && target != ART && target != BPlusTree {
+		return ErrUnsupportedIndexType
+	}
+	if options.IndexType == target {
+		return nil
+	}
+	// 内存索引之间的转换不需要修改磁盘上的文件
+	if options.IndexType != BPlusTree && target != BPlusTree {
+		return nil
+	}
+
+	if target == BPlusTree {
+		// 使用原来的内存索引打开，从数据文件中加载索引
+		db, err := Open(options)
+		if err != nil {
+			return err
+		}
+		if err := db.buildBPlusTreeIndex(); err != nil {
+			_ = db.Close()
+			return err
+		}
+		return db.Close()
+	}
+
+	// 直接使用目标索引类型打开，从数据文件中重建索引
+	options.IndexType = target
+	db, err := Open(options)
+	if err != nil {
+		return err
+	}
+	if err := db.Close(); err != nil {
+		return err
+	}
+	// 内存索引不再需要 B+ 树索引文件，事务序列号也可以从数据文件中获取
+	return removeBPlusTreeFiles(db.fs, options.DirPath)
+}
+
+// buildBPlusTreeIndex 根据当前的内存索引构建 B+ 树索引文件，并保存事务序列号
+func (db *DB) buildBPlusTreeIndex() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	// B+ 树索引只能存储默认 keyspace 的数据
+	if len(db.keyspaces) > 0 {
+		return ErrUnsupportedIndexType
+	}
+
+	// 删除之前残留的文件
+	if err := removeBPlusTreeFiles(db.fs, db.options.DirPath); err != nil {
+		return err
+	}
+
+	bptree := index.NewBPlusTree(db.options.DirPath, true)
+	// 索引已经包含了所有的数据，记录数据文件末尾的位置，启动时不需要从头重放数据文件
+	var checkpoint *data.LogRecordPos
+	if db.activeFile != nil {
+		checkpoint = &data.LogRecordPos{Fid: db.activeFile.FileId, Offset: db.activeFile.WriteOffset}
+	}
+	iterator := db.index.Iterator(false)
+	entries := make([]*index.BatchEntry, 0, indexBatchSize)
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		entries = append(entries, &index.BatchEntry{Key: iterator.Key(), Pos: iterator.Value()})
+		if len(entries) == indexBatchSize {
+			bptree.ApplyBatchWithCheckpoint(entries, checkpoint)
+			entries = entries[:0]
+		}
+	}
+	bptree.ApplyBatchWithCheckpoint(entries, checkpoint)
+	iterator.Close()
+	if err := bptree.Close(); err != nil {
+		return err
+	}
+	return db.saveSeqNo()
+}
+
+// removeBPlusTreeFiles 删除 B+ 树索引需要的 bptree-index 和 seq-no 文件
+func removeBPlusTreeFiles(fs fio.FileSystem, dirPath string) error {
+	for _, fileName := range []string{index.BPTreeIndexFileName, data.SeqNoFileName} {
+		if err := fs.RemoveAll(filepath.Join(dirPath, fileName)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
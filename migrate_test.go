@@ -0,0 +1,111 @@
+package bitcask_db
+
+import (
+	"bitcask-db/data"
+	"bitcask-db/index"
+	"bitcask-db/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMigrateIndexType(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-db-migrate")
+	opts.DirPath = dir
+	opts.DataFileSize = 1024 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 10000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 1000; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put(utils.GetTestKey(10000), []byte("value in batch")))
+	assert.Nil(t, wb.Commit())
+	seqNo := db.seqNo
+	err = db.Close()
+	assert.Nil(t, err)
+
+	// 不支持的索引类型
+	err = MigrateIndexType(opts, 100)
+	assert.Equal(t, ErrUnsupportedIndexType, err)
+
+	// BTree 转换为 B+ 树
+	err = MigrateIndexType(opts, BPlusTree)
+	assert.Nil(t, err)
+	_, err = os.Stat(filepath.Join(dir, index.BPTreeIndexFileName))
+	assert.Nil(t, err)
+
+	bptreeOpts := opts
+	bptreeOpts.IndexType = BPlusTree
+	db2, err := Open(bptreeOpts)
+	assert.Nil(t, err)
+	assert.Equal(t, seqNo, db2.seqNo)
+	assert.Equal(t, uint(9001), db2.Stat().KeyNum)
+	_, err = db2.Get(utils.GetTestKey(10))
+	assert.Equal(t, ErrKeyNotFound, err)
+	val, err := db2.Get(utils.GetTestKey(10000))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value in batch"), val)
+
+	// 转换之后可以正常使用事务
+	wb2 := db2.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb2.Put(utils.GetTestKey(10001), []byte("value in bptree")))
+	assert.Nil(t, wb2.Delete(utils.GetTestKey(5000)))
+	assert.Nil(t, wb2.Commit())
+	err = db2.Close()
+	assert.Nil(t, err)
+
+	// B+ 树转换为 ART
+	err = MigrateIndexType(bptreeOpts, ART)
+	assert.Nil(t, err)
+	_, err = os.Stat(filepath.Join(dir, index.BPTreeIndexFileName))
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(dir, data.SeqNoFileName))
+	assert.True(t, os.IsNotExist(err))
+
+	artOpts := opts
+	artOpts.IndexType = ART
+	db3, err := Open(artOpts)
+	assert.Nil(t, err)
+	assert.Equal(t, 9001, len(db3.ListKeys()))
+	assert.Equal(t, seqNo+1, db3.seqNo)
+	val, err = db3.Get(utils.GetTestKey(10001))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value in bptree"), val)
+	_, err = db3.Get(utils.GetTestKey(5000))
+	assert.Equal(t, ErrKeyNotFound, err)
+	err = db3.Close()
+	assert.Nil(t, err)
+}
+
+func TestMigrateIndexType_ReclaimableSize(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-db-migrate-reclaimable")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(64)))
+	}
+	reclaimableSize := db.Stat().ReclaimableSize
+	assert.Nil(t, db.Close())
+
+	assert.Nil(t, MigrateIndexType(opts, BPlusTree))
+	// 转换时记录了数据文件末尾的位置，启动时不会从头重放数据文件
+	opts.IndexType = BPlusTree
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	assert.Equal(t, uint(1000), db2.Stat().KeyNum)
+	assert.Equal(t, reclaimableSize, db2.Stat().ReclaimableSize)
+}
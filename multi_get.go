@@ -0,0 +1,65 @@
+package bitcask_db
+
+import (
+	"bitcask-db/data"
+	"sort"
+)
+
+// MultiGet 批量读取数据，在同一个读锁中查找所有 key 的索引，按照数据在文件中的位置顺序读取
+// 返回的 values 和 errs 与 keys 一一对应，key 不存在时对应的错误为 ErrKeyNotFound
+func (db *DB) MultiGet(keys [][]byte) ([][]byte, []error) {
+	values := make([][]byte, len(keys))
+	errs := make([]error, len(keys))
+
+	type readPos struct {
+		i   int
+		pos *data.LogRecordPos
+	}
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	positions := make([]readPos, 0, len(keys))
+	for i, key := range keys {
+		if len(key) == 0 {
+			errs[i] = ErrKeyIsEmpty
+			continue
+		}
+		pos := db.index.Get(key)
+		if pos == nil {
+			errs[i] = ErrKeyNotFound
+			continue
+		}
+		positions = append(positions, readPos{i: i, pos: pos})
+	}
+
+	// 按照文件 id 和偏移排序，尽量顺序读取
+	sort.Slice(positions, func(i, j int) bool {
+		a, b := positions[i].pos, positions[j].pos
+		if a.Fid != b.Fid {
+			return a.Fid < b.Fid
+		}
+		return a.Offset < b.Offset
+	})
+	for _, p := range positions {
+		values[p.i], errs[p.i] = db.getValueByPosition(p.pos)
+	}
+	return values, errs
+}
+
+// Exists 判断 key 是否存在，只查找内存索引，不读取数据文件
+func (db *DB) Exists(key []byte) bool {
+	if len(key) == 0 {
+		return false
+	}
+	return db.index.Get(key) != nil
+}
+
+// MultiExists 批量判断 key 是否存在，返回的结果与 keys 一一对应
+func (db *DB) MultiExists(keys [][]byte) []bool {
+	exists := make([]bool, len(keys))
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	for i, key := range keys {
+		exists[i] = len(key) > 0 && db.index.Get(key) != nil
+	}
+	return exists
+}
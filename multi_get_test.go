@@ -0,0 +1,75 @@
+package bitcask_db
+
+import (
+	"bitcask-db/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestDB_MultiGet(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-db-multi-get")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	values := make(map[int][]byte)
+	for i := 0; i < 2000; i++ {
+		values[i] = utils.RandomValue(64)
+		err := db.Put(utils.GetTestKey(i), values[i])
+		assert.Nil(t, err)
+	}
+	assert.True(t, len(db.olderFiles) > 0)
+	err = db.Delete(utils.GetTestKey(10))
+	assert.Nil(t, err)
+
+	// 乱序的 key 按照原来的顺序返回
+	ids := []int{1999, 3, 10, 1000, 3, 5000}
+	keys := make([][]byte, 0, len(ids)+1)
+	for _, id := range ids {
+		keys = append(keys, utils.GetTestKey(id))
+	}
+	keys = append(keys, nil)
+	vals, errs := db.MultiGet(keys)
+	assert.Equal(t, len(keys), len(vals))
+	assert.Equal(t, len(keys), len(errs))
+	for i, id := range ids {
+		if id == 10 || id == 5000 {
+			assert.Equal(t, ErrKeyNotFound, errs[i])
+			assert.Nil(t, vals[i])
+			continue
+		}
+		assert.Nil(t, errs[i])
+		assert.Equal(t, values[id], vals[i])
+	}
+	assert.Equal(t, ErrKeyIsEmpty, errs[len(keys)-1])
+
+	vals, errs = db.MultiGet(nil)
+	assert.Equal(t, 0, len(vals))
+	assert.Equal(t, 0, len(errs))
+}
+
+func TestDB_MultiExists(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-db-multi-exists")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	err = db.Put(utils.GetTestKey(1), utils.RandomValue(24))
+	assert.Nil(t, err)
+	err = db.Put(utils.GetTestKey(2), utils.RandomValue(24))
+	assert.Nil(t, err)
+	err = db.Delete(utils.GetTestKey(2))
+	assert.Nil(t, err)
+
+	assert.True(t, db.Exists(utils.GetTestKey(1)))
+	assert.False(t, db.Exists(utils.GetTestKey(2)))
+	assert.False(t, db.Exists(nil))
+	exists := db.MultiExists([][]byte{utils.GetTestKey(1), utils.GetTestKey(2), utils.GetTestKey(3), nil})
+	assert.Equal(t, []bool{true, false, false, false}, exists)
+}
@@ -1,6 +1,11 @@
 package bitcask_db
 
-import "os"
+import (
+	"bitcask-db/fio"
+	"os"
+	"runtime"
+	"time"
+)
 
 type Options struct {
 	// 数据库目录
@@ -22,6 +27,73 @@ type Options struct {
 
 	// 数据文件合并的阈值
 	DataFileMergeRatio float32
+
+	// 启动时并发解析数据文件、构建索引的协程数量，小于等于 1 时顺序加载
+	IndexLoadWorkers int
+
+	// 关闭数据库时是否保存索引快照，下次启动时直接加载快照
+	IndexSnapshotOnClose bool
+
+	// value 缓存的最大字节数，为 0 时不使用缓存
+	// 缓存按照记录所在的文件 id 和偏移查找，运行期间同一个位置上的记录不会改变，不需要主动清除缓存：
+	// 新的数据文件 id 总是递增，merge 的数据文件在下次启动时才替换旧的文件，BlobGC 为重写的 value 写入新的位置记录
+	// 不再被引用的缓存按照 LRU 淘汰
+	ValueCacheSize int64
+
+	// 活跃文件写缓冲的字节数，为 0 时每条记录直接写入文件
+	// 缓冲区中的数据在 Sync、达到 BytesPerSync、文件转换以及关闭数据库时写入文件
+	WriteBufferSize int
+
+	// 活跃文件是否使用可读写的 MMap，文件预分配到 DataFileSize 大小，旧的数据文件保持 MMap 读取
+	// 不能和 WriteBufferSize 同时使用
+	MMapReadWrite bool
+
+	// 数据目录所在的文件系统，为空时使用操作系统的文件系统
+	// 使用 fio.NewMemFileSystem() 时数据库完全运行在内存中，不能和 BPlusTree 索引同时使用
+	FileSystem fio.FileSystem
+
+	// 最多同时打开的旧数据文件数量，超出时关闭最近最少使用的文件，为 0 时不限制
+	MaxOpenFiles int
+
+	// 数据文件是否使用 O_DIRECT 读写，绕过操作系统的页缓存，只在 Linux 上生效，不支持时回退到标准文件 IO
+	// 不能和 MMapReadWrite 同时使用
+	DirectIO bool
+
+	// 新的活跃文件是否预分配 DataFileSize 大小的磁盘空间，追加写入时不需要扩展文件，持久化的耗时更平稳
+	// 不能和 MMapReadWrite、DirectIO 同时使用
+	Preallocate bool
+
+	// 累计写到多少字节后提交这部分数据的回写，不等待回写完成，为 0 时不提交
+	// 在两次 BytesPerSync 持久化之间分摊刷盘的压力，只在 Linux 上生效
+	BytesPerRangeSync uint
+
+	// MergeValue 使用的合并操作符，为空时不能使用 MergeValue
+	// 同一个数据目录每次打开时需要使用相同的合并操作符
+	MergeOperator MergeOperator
+
+	// 每个 key 保留的历史版本数量，为 0 时不限制数量
+	// HistoryVersions 和 HistoryRetention 都为 0 时不保留历史版本，保留历史版本时不能使用 B+ 树索引
+	HistoryVersions uint
+
+	// 历史版本被新版本覆盖之后保留的时长，为 0 时不限制时长
+	HistoryRetention time.Duration
+
+	// PutStream 每个分块的最大字节数，为 0 时使用 1MB
+	StreamChunkSize int64
+
+	// 大于等于这个字节数的 value 单独写入 blob 文件，数据文件中只记录 value 的位置，为 0 时不使用 blob 文件
+	// merge 时不需要重写 blob 文件中的 value，blob 文件通过 BlobGC 单独回收，保留历史版本时不使用 blob 文件
+	// 每个写入 blob 文件的 value 都会先持久化，再写入数据文件中的位置
+	BlobThreshold int
+
+	// blob 文件的大小
+	BlobFileSize int64
+
+	// blob 文件中无效数据的比例达到这个阈值时 BlobGC 才会回收这个文件
+	BlobGCRatio float32
+
+	// 按照名称注册的二级索引，默认 keyspace 中的数据写入和删除时同步更新，启动时重新构建
+	SecondaryIndexes map[string]SecondaryExtractor
 }
 
 type IndexerType = int8
@@ -37,13 +109,31 @@ const (
 )
 
 var DefaultOptions = Options{
-	DirPath:            os.TempDir(),
-	DataFileSize:       256 * 1024 * 1024, //256M
-	SyncWrites:         false,
-	BytesPerSync:       0,
-	IndexType:          BTree,
-	MMapAtStartup:      true,
-	DataFileMergeRatio: 0.5,
+	DirPath:              os.TempDir(),
+	DataFileSize:         256 * 1024 * 1024, //256M
+	SyncWrites:           false,
+	BytesPerSync:         0,
+	IndexType:            BTree,
+	MMapAtStartup:        true,
+	DataFileMergeRatio:   0.5,
+	IndexLoadWorkers:     runtime.NumCPU(),
+	IndexSnapshotOnClose: false,
+	ValueCacheSize:       0,
+	WriteBufferSize:      0,
+	MMapReadWrite:        false,
+	FileSystem:           fio.DefaultFileSystem,
+	MaxOpenFiles:         0,
+	DirectIO:             false,
+	Preallocate:          false,
+	BytesPerRangeSync:    0,
+	MergeOperator:        nil,
+	HistoryVersions:      0,
+	HistoryRetention:     0,
+	StreamChunkSize:      1024 * 1024,
+	BlobThreshold:        0,
+	BlobFileSize:         256 * 1024 * 1024, // 256M
+	BlobGCRatio:          0.5,
+	SecondaryIndexes:     nil,
 }
 
 // IteratorOptions 索引迭代器配置项
@@ -0,0 +1,136 @@
+package bitcask_db
+
+import (
+	"bitcask-db/index"
+	"bytes"
+	"github.com/google/btree"
+)
+
+// 二级索引只在内存中维护默认 keyspace 的数据，启动时根据 Options.SecondaryIndexes 重新构建
+// 和主索引在同一次持有 db.mu 期间更新，读取到的二级索引总是和主索引一致
+
+// SecondaryExtractor 从 key 和 value 中提取二级索引的 key，一条数据可以对应多个二级 key，返回空时不加入索引
+// 提取不能有副作用，同一条数据可能被提取多次
+type SecondaryExtractor func(key, value []byte) [][]byte
+
+// secondaryIndex 一个二级索引，按照二级 key 和主 key 排序
+type secondaryIndex struct {
+	extract SecondaryExtractor
+	tree    *btree.BTree
+	entries map[string][][]byte // 主 key 当前对应的二级 key，更新和删除时从树中移除
+}
+
+// secondaryItem 二级索引中的一项
+type secondaryItem struct {
+	secondary []byte
+	key       []byte
+}
+
+func (si *secondaryItem) Less(than btree.Item) bool {
+	other := than.(*secondaryItem)
+	if c := bytes.Compare(si.secondary, other.secondary); c != 0 {
+		return c < 0
+	}
+	return bytes.Compare(si.key, other.key) < 0
+}
+
+// LookupBySecondary 查找二级 key 等于 value 的所有数据的 key，按照 key 排序
+func (db *DB) LookupBySecondary(indexName string, value []byte) ([][]byte, error) {
+	var keys [][]byte
+	err := db.ScanSecondary(indexName, value, nil, func(secondary, key []byte) bool {
+		if !bytes.Equal(secondary, value) {
+			return false
+		}
+		keys = append(keys, key)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// ScanSecondary 按照二级 key 的顺序遍历 [start, end) 范围内的二级索引，end 为空时遍历 start 之后所有的二级 key
+// fn 返回 false 时结束遍历
+func (db *DB) ScanSecondary(indexName string, start, end []byte, fn func(secondary, key []byte) bool) error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	si, ok := db.secondaries[indexName]
+	if !ok {
+		return ErrSecondaryIndexNotFound
+	}
+	si.tree.AscendGreaterOrEqual(&secondaryItem{secondary: start}, func(item btree.Item) bool {
+		it := item.(*secondaryItem)
+		if len(end) > 0 && bytes.Compare(it.secondary, end) >= 0 {
+			return false
+		}
+		return fn(it.secondary, it.key)
+	})
+	return nil
+}
+
+// loadSecondaryIndexes 启动时遍历所有的数据，构建二级索引
+func (db *DB) loadSecondaryIndexes() error {
+	if len(db.options.SecondaryIndexes) == 0 {
+		return nil
+	}
+	for name, extract := range db.options.SecondaryIndexes {
+		db.secondaries[name] = &secondaryIndex{
+			extract: extract,
+			tree:    btree.New(32),
+			entries: make(map[string][][]byte),
+		}
+	}
+	iterator := db.index.Iterator(false)
+	defer iterator.Close()
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		value, err := db.getValueByPosition(iterator.Value())
+		if err != nil {
+			return err
+		}
+		for _, si := range db.secondaries {
+			si.put(iterator.Key(), value)
+		}
+	}
+	return nil
+}
+
+// applySecondary 主索引更新之后同步更新二级索引，Pos 为空时表示删除 key
+// values 为写入路径中已有的 value，不需要再从数据文件中读取
+// 在访问此方法前必须持有互斥锁
+func (db *DB) applySecondary(entries []*index.BatchEntry, values [][]byte) {
+	if len(db.secondaries) == 0 {
+		return
+	}
+	for i, entry := range entries {
+		for _, si := range db.secondaries {
+			si.remove(entry.Key)
+			if entry.Pos != nil {
+				si.put(entry.Key, values[i])
+			}
+		}
+	}
+}
+
+// put 提取 key value 的二级 key 并加入索引
+func (si *secondaryIndex) put(key, value []byte) {
+	var secondaries [][]byte
+	for _, secondary := range si.extract(key, value) {
+		// 提取出的二级 key 可能引用 value 的内存
+		secondary = append([]byte(nil), secondary...)
+		if si.tree.ReplaceOrInsert(&secondaryItem{secondary: secondary, key: key}) == nil {
+			secondaries = append(secondaries, secondary)
+		}
+	}
+	if len(secondaries) > 0 {
+		si.entries[string(key)] = secondaries
+	}
+}
+
+// remove 移除 key 对应的所有二级 key
+func (si *secondaryIndex) remove(key []byte) {
+	for _, secondary := range si.entries[string(key)] {
+		si.tree.Delete(&secondaryItem{secondary: secondary, key: key})
+	}
+	delete(si.entries, string(key))
+}
@@ -0,0 +1,115 @@
+package bitcask_db
+
+import (
+	"bitcask-db/utils"
+	"bytes"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+// emailExtractor value 的格式为 name|email，提取 email 作为二级 key
+func emailExtractor(_, value []byte) [][]byte {
+	i := bytes.IndexByte(value, '|')
+	if i < 0 {
+		return nil
+	}
+	return [][]byte{value[i+1:]}
+}
+
+func TestDB_SecondaryIndex(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-db-secondary")
+	opts.DirPath = dir
+	opts.SecondaryIndexes = map[string]SecondaryExtractor{"email": emailExtractor}
+	db, err := Open(opts)
+	assert.Nil(t, err)
+
+	for i := 0; i < 10; i++ {
+		value := fmt.Sprintf("user-%d|user%d@example.com", i, i%5)
+		assert.Nil(t, db.Put(utils.GetTestKey(i), []byte(value)))
+	}
+	assert.Nil(t, db.Put(utils.GetTestKey(10), []byte("no email")))
+
+	keys, err := db.LookupBySecondary("email", []byte("user1@example.com"))
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{utils.GetTestKey(1), utils.GetTestKey(6)}, keys)
+	_, err = db.LookupBySecondary("name", []byte("user-1"))
+	assert.Equal(t, ErrSecondaryIndexNotFound, err)
+
+	// 更新和删除之后旧的二级 key 不再有效
+	assert.Nil(t, db.Put(utils.GetTestKey(1), []byte("user-1|new@example.com")))
+	assert.Nil(t, db.Delete(utils.GetTestKey(6)))
+	keys, err = db.LookupBySecondary("email", []byte("user1@example.com"))
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(keys))
+	keys, err = db.LookupBySecondary("email", []byte("new@example.com"))
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{utils.GetTestKey(1)}, keys)
+
+	// 事务提交时一起更新
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put(utils.GetTestKey(11), []byte("user-11|user2@example.com")))
+	assert.Nil(t, wb.Delete(utils.GetTestKey(2)))
+	keys, err = db.LookupBySecondary("email", []byte("user2@example.com"))
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{utils.GetTestKey(2), utils.GetTestKey(7)}, keys)
+	assert.Nil(t, wb.Commit())
+	keys, err = db.LookupBySecondary("email", []byte("user2@example.com"))
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{utils.GetTestKey(7), utils.GetTestKey(11)}, keys)
+
+	// 范围遍历二级 key
+	var secondaries []string
+	err = db.ScanSecondary("email", []byte("user2"), []byte("user4"), func(secondary, key []byte) bool {
+		secondaries = append(secondaries, string(secondary))
+		return true
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"user2@example.com", "user2@example.com", "user3@example.com", "user3@example.com"}, secondaries)
+	assert.Nil(t, db.Close())
+
+	// 启动时重新构建
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	keys, err = db2.LookupBySecondary("email", []byte("user2@example.com"))
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{utils.GetTestKey(7), utils.GetTestKey(11)}, keys)
+	keys, err = db2.LookupBySecondary("email", []byte("new@example.com"))
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{utils.GetTestKey(1)}, keys)
+	assert.Nil(t, db2.DeletePrefix([]byte("bitcask")))
+	keys, err = db2.LookupBySecondary("email", []byte("new@example.com"))
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(keys))
+}
+
+func TestDB_SecondaryIndexWritePaths(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-db-secondary-paths")
+	opts.DirPath = dir
+	opts.SecondaryIndexes = map[string]SecondaryExtractor{"email": emailExtractor}
+	opts.MergeOperator = AppendOperator{}
+	opts.BlobThreshold = 64
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	// 操作数合并之后的值
+	assert.Nil(t, db.MergeValue(utils.GetTestKey(1), []byte("user-1|")))
+	assert.Nil(t, db.MergeValue(utils.GetTestKey(1), []byte("merge@example.com")))
+	// 分块写入不会保留完整的值，配置了二级索引时不能使用
+	assert.Equal(t, ErrStreamWithSecondary, db.PutStream(utils.GetTestKey(2), bytes.NewReader([]byte("user-2|stream@example.com"))))
+	assert.Nil(t, db.Put(utils.GetTestKey(2), []byte("user-2|stream@example.com")))
+	// 写入 blob 文件的值
+	blobValue := append(bytes.Repeat([]byte("x"), 100), []byte("|blob@example.com")...)
+	assert.Nil(t, db.Put(utils.GetTestKey(3), blobValue))
+
+	for i, email := range []string{"merge@example.com", "stream@example.com", "blob@example.com"} {
+		keys, err := db.LookupBySecondary("email", []byte(email))
+		assert.Nil(t, err)
+		assert.Equal(t, [][]byte{utils.GetTestKey(i + 1)}, keys)
+	}
+}
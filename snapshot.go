@@ -0,0 +1,229 @@
+package bitcask_db
+
+import (
+	"bitcask-db/data"
+	"bitcask-db/index"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// 索引快照，将整个内存索引序列化到文件中
+// 启动时直接加载快照，只需要重放快照覆盖位置之后写入的数据
+
+const (
+	snapshotMetaKey     = "index-snapshot-meta"
+	snapshotWriteBuffer = 4 * 1024 * 1024
+)
+
+var errInvalidIndexSnapshot = errors.New("invalid index snapshot")
+
+// indexSnapshot 某一时刻的内存索引，以及它覆盖到的数据位置
+type indexSnapshot struct {
+	pos             *data.LogRecordPos // 快照覆盖到的数据位置，之后写入的数据需要重放
+	seqNo           uint64             // 事务序列号
+	reclaimableSize int64              // 可以回收的数据量
+	iterator        index.Iterator     // 索引数据
+}
+
+// SnapshotIndex 将当前的内存索引保存为快照文件
+// B+ 树索引本身已经持久化到磁盘，不需要快照
+func (db *DB) SnapshotIndex() error {
+	if db.options.IndexType == BPlusTree {
+		return nil
+	}
+	// 只在获取索引数据时加锁，写文件的过程中不阻塞读写
+	db.mu.Lock()
+	snapshot := db.newIndexSnapshot()
+	db.mu.Unlock()
+	return db.writeIndexSnapshot(snapshot)
+}
+
+// newIndexSnapshot 获取当前的索引快照，调用时需要持有 db.mu
+func (db *DB) newIndexSnapshot() *indexSnapshot {
+	if db.activeFile == nil || db.options.IndexType == BPlusTree {
+		return nil
+	}
+	return &indexSnapshot{
+		pos:             &data.LogRecordPos{Fid: db.activeFile.FileId, Offset: db.activeFile.WriteOffset},
+		seqNo:           db.seqNo,
+		reclaimableSize: db.reclaimableSize,
+		iterator:        db.index.Iterator(false),
+	}
+}
+
+// writeIndexSnapshot 将索引快照写入文件
+// 第一条记录保存快照的元数据，最后一条 key 为空的记录保存所有记录的校验值和数量
+func (db *DB) writeIndexSnapshot(snapshot *indexSnapshot) error {
+	if snapshot == nil {
+		return nil
+	}
+	defer snapshot.iterator.Close()
+
+	db.snapshotLock.Lock()
+	defer db.snapshotLock.Unlock()
+
+	tmpFile, err := data.OpenIndexSnapshotTmpFile(db.fs, db.options.DirPath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tmpFile.Close()
+	}()
+
+	var crc uint32
+	var count uint64
+	buf := make([]byte, 0, snapshotWriteBuffer)
+	appendRecord := func(key, value []byte) error {
+		crc = crc32.Update(crc, crc32.IEEETable, key)
+		crc = crc32.Update(crc, crc32.IEEETable, value)
+		count++
+		encRecord, _ := data.EncodeLogRecord(&data.LogRecord{Key: key, Value: value})
+		buf = append(buf, encRecord...)
+		if len(buf) < snapshotWriteBuffer {
+			return nil
+		}
+		err := tmpFile.Write(buf)
+		buf = buf[:0]
+		return err
+	}
+
+	if err := appendRecord([]byte(snapshotMetaKey), encodeSnapshotMeta(snapshot)); err != nil {
+		return err
+	}
+	iterator := snapshot.iterator
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		if err := appendRecord(iterator.Key(), data.EncodeLogRecordPos(iterator.Value())); err != nil {
+			return err
+		}
+	}
+
+	// 结束记录
+	finValue := make([]byte, 4+binary.MaxVarintLen64)
+	binary.LittleEndian.PutUint32(finValue[:4], crc)
+	n := binary.PutUvarint(finValue[4:], count)
+	finRecord, _ := data.EncodeLogRecord(&data.LogRecord{Value: finValue[:4+n]})
+	buf = append(buf, finRecord...)
+	if err := tmpFile.Write(buf); err != nil {
+		return err
+	}
+	if err := tmpFile.Sync(); err != nil {
+		return err
+	}
+	return db.fs.Rename(filepath.Join(db.options.DirPath, data.IndexSnapshotFileName+".tmp"),
+		filepath.Join(db.options.DirPath, data.IndexSnapshotFileName))
+}
+
+// loadIndexFromSnapshot 从索引快照中加载索引，返回快照覆盖到的数据位置
+// 快照不存在或者无效时返回 nil，需要从头加载索引
+func (db *DB) loadIndexFromSnapshot() (*data.LogRecordPos, error) {
+	fileName := filepath.Join(db.options.DirPath, data.IndexSnapshotFileName)
+	if _, err := db.fs.Stat(fileName); os.IsNotExist(err) {
+		return nil, nil
+	}
+	snapshotFile, err := data.OpenIndexSnapshotFile(db.fs, db.options.DirPath)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = snapshotFile.Close()
+	}()
+
+	snapshot, err := db.readIndexSnapshot(snapshotFile)
+	if err == errInvalidIndexSnapshot || err == data.ErrInvalidCRC {
+		// 快照无效，丢弃已经加载的部分，从头加载索引
+		db.index = index.NewIndexer(db.options.IndexType, db.options.DirPath, db.options.SyncWrites)
+		return nil, db.fs.Remove(fileName)
+	}
+	if err != nil {
+		return nil, err
+	}
+	db.seqNo = snapshot.seqNo
+	db.reclaimableSize = snapshot.reclaimableSize
+	return snapshot.pos, nil
+}
+
+// readIndexSnapshot 读取快照文件并加载到内存索引中
+func (db *DB) readIndexSnapshot(snapshotFile *data.DataFile) (*indexSnapshot, error) {
+	var snapshot *indexSnapshot
+	var crc uint32
+	var count uint64
+	var offset int64 = 0
+	entries := make([]*index.BatchEntry, 0, indexBatchSize)
+	for {
+		logRecord, size, err := snapshotFile.ReadLogRecord(offset)
+		if err != nil {
+			// 没有读到结束记录，快照不完整
+			if err == io.EOF {
+				return nil, errInvalidIndexSnapshot
+			}
+			return nil, err
+		}
+		offset += size
+
+		// 结束记录，校验记录数量和校验值
+		if len(logRecord.Key) == 0 {
+			if snapshot == nil || len(logRecord.Value) < 4 {
+				return nil, errInvalidIndexSnapshot
+			}
+			expectCount, _ := binary.Uvarint(logRecord.Value[4:])
+			if binary.LittleEndian.Uint32(logRecord.Value[:4]) != crc || expectCount != count {
+				return nil, errInvalidIndexSnapshot
+			}
+			db.index.ApplyBatch(entries)
+			return snapshot, nil
+		}
+
+		crc = crc32.Update(crc, crc32.IEEETable, logRecord.Key)
+		crc = crc32.Update(crc, crc32.IEEETable, logRecord.Value)
+		count++
+
+		// 第一条记录是元数据
+		if snapshot == nil {
+			if string(logRecord.Key) != snapshotMetaKey {
+				return nil, errInvalidIndexSnapshot
+			}
+			snapshot = decodeSnapshotMeta(logRecord.Value)
+			if !db.dataPosValid(snapshot.pos) {
+				return nil, errInvalidIndexSnapshot
+			}
+			continue
+		}
+		entries = append(entries, &index.BatchEntry{Key: logRecord.Key, Pos: data.DecodeLogRecordPos(logRecord.Value)})
+		if len(entries) == indexBatchSize {
+			db.index.ApplyBatch(entries)
+			entries = entries[:0]
+		}
+	}
+}
+
+// encodeSnapshotMeta 编码快照元数据
+func encodeSnapshotMeta(snapshot *indexSnapshot) []byte {
+	buf := make([]byte, binary.MaxVarintLen32+binary.MaxVarintLen64*3)
+	var index = 0
+	index += binary.PutVarint(buf[index:], int64(snapshot.pos.Fid))
+	index += binary.PutVarint(buf[index:], snapshot.pos.Offset)
+	index += binary.PutUvarint(buf[index:], snapshot.seqNo)
+	index += binary.PutVarint(buf[index:], snapshot.reclaimableSize)
+	return buf[:index]
+}
+
+// decodeSnapshotMeta 解码快照元数据
+func decodeSnapshotMeta(buf []byte) *indexSnapshot {
+	var index = 0
+	fileId, n := binary.Varint(buf[index:])
+	index += n
+	offset, n := binary.Varint(buf[index:])
+	index += n
+	seqNo, n := binary.Uvarint(buf[index:])
+	index += n
+	reclaimableSize, _ := binary.Varint(buf[index:])
+	return &indexSnapshot{
+		pos:             &data.LogRecordPos{Fid: uint32(fileId), Offset: offset},
+		seqNo:           seqNo,
+		reclaimableSize: reclaimableSize,
+	}
+}
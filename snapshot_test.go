@@ -0,0 +1,106 @@
+package bitcask_db
+
+import (
+	"bitcask-db/data"
+	"bitcask-db/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDB_SnapshotIndex(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-db-snapshot")
+	opts.DirPath = dir
+	opts.DataFileSize = 1024 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// 数据库为空
+	err = db.SnapshotIndex()
+	assert.Nil(t, err)
+
+	for i := 0; i < 10000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+	err = db.SnapshotIndex()
+	assert.Nil(t, err)
+	_, err = os.Stat(filepath.Join(dir, data.IndexSnapshotFileName))
+	assert.Nil(t, err)
+
+	// 快照之后写入的数据需要重放
+	for i := 0; i < 2000; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	for i := 10000; i < 11000; i++ {
+		err := wb.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, wb.Commit())
+	seqNo := db.seqNo
+	reclaimableSize := db.reclaimableSize
+	err = db.Close()
+	assert.Nil(t, err)
+
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 9000, len(db2.ListKeys()))
+	_, err = db2.Get(utils.GetTestKey(100))
+	assert.Equal(t, ErrKeyNotFound, err)
+	val, err := db2.Get(utils.GetTestKey(10500))
+	assert.Nil(t, err)
+	assert.NotNil(t, val)
+	assert.Equal(t, seqNo, db2.seqNo)
+	assert.Equal(t, reclaimableSize, db2.reclaimableSize)
+	err = db2.Close()
+	assert.Nil(t, err)
+}
+
+func TestDB_SnapshotIndexOnClose(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-db-snapshot-close")
+	opts.DirPath = dir
+	opts.IndexSnapshotOnClose = true
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 10000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+	err = db.Close()
+	assert.Nil(t, err)
+
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	pos, err := db2.loadIndexFromSnapshot()
+	assert.Nil(t, err)
+	assert.Equal(t, db2.activeFile.WriteOffset, pos.Offset)
+	assert.Equal(t, 10000, len(db2.ListKeys()))
+	err = db2.Close()
+	assert.Nil(t, err)
+
+	// 快照损坏时从数据文件加载索引
+	snapshotFile := filepath.Join(dir, data.IndexSnapshotFileName)
+	stat, err := os.Stat(snapshotFile)
+	assert.Nil(t, err)
+	err = os.Truncate(snapshotFile, stat.Size()-10)
+	assert.Nil(t, err)
+
+	db3, err := Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 10000, len(db3.ListKeys()))
+	val, err := db3.Get(utils.GetTestKey(99))
+	assert.Nil(t, err)
+	assert.NotNil(t, val)
+	err = db3.Close()
+	assert.Nil(t, err)
+}
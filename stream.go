@@ -0,0 +1,210 @@
+package bitcask_db
+
+import (
+	"bitcask-db/data"
+	"bitcask-db/index"
+	"bytes"
+	"io"
+)
+
+// 大的 value 拆分为多个分块记录写入，最后写入一条分块清单记录并更新索引
+// 分块可以分布在多个数据文件中，清单写入之前数据库异常退出时已经写入的分块不会生效
+
+const defaultStreamChunkSize = 1024 * 1024
+
+// PutStream 从 r 中读取数据作为 key 的 value，按照 Options.StreamChunkSize 拆分为多个分块写入
+// 读取分块时不持有 db.mu，写入期间其他的读写操作不会被阻塞
+// 分块清单的索引位置大小包含所有分块的大小，覆盖或者删除之后分块占用的空间同样计入 ReclaimableSize
+// 位置大小为 uint32，超过 4GB 的 value 最多计入 4GB
+// 二级索引需要完整的 value，配置了 Options.SecondaryIndexes 时返回 ErrStreamWithSecondary
+func (db *DB) PutStream(key []byte, r io.Reader) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	if len(db.secondaries) > 0 {
+		return ErrStreamWithSecondary
+	}
+	chunkSize := db.options.StreamChunkSize
+	if chunkSize <= 0 {
+		chunkSize = defaultStreamChunkSize
+	}
+	buf := make([]byte, chunkSize)
+	var chunks []*data.LogRecordPos
+	var size int64
+	for {
+		n, readErr := io.ReadFull(r, buf)
+		if n > 0 {
+			pos, err := db.appendChunk(key, buf[:n])
+			if err != nil {
+				db.discardChunks(chunks)
+				return err
+			}
+			chunks = append(chunks, pos)
+			size += int64(n)
+		}
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			break
+		}
+		if readErr != nil {
+			db.discardChunks(chunks)
+			return readErr
+		}
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	// 写入分块之后开始的 merge 只保留索引引用的记录，会丢弃这些分块，将它们复制到活跃文件中
+	// merge 失败时之前的文件不会被替换，分块直接使用，不需要复制
+	for i, chunk := range chunks {
+		if chunk.Fid >= db.operandFloor {
+			continue
+		}
+		logRecord, err := db.readLogRecord(chunk)
+		if err != nil {
+			return err
+		}
+		if chunks[i], err = db.appendLogRecord(logRecord); err != nil {
+			return err
+		}
+		db.reclaimableSize += int64(chunk.Size)
+	}
+	logRecord := &data.LogRecord{
+		Key:   logRecordKeyWithSeqNo(key, nonTransactionSeqNo),
+		Value: data.EncodeStreamManifest(size, chunks),
+		Type:  data.LogRecordStream,
+	}
+	pos, err := db.appendLogRecord(logRecord)
+	if err != nil {
+		return err
+	}
+	// 没有配置二级索引，不需要完整的 value
+	if oldPos := db.applyIndex([]*index.BatchEntry{{Key: key, Pos: pos}}, nil)[0]; oldPos != nil {
+		db.reclaimableSize += int64(oldPos.Size)
+	}
+	return nil
+}
+
+// GetStream 读取 key 的 value，分块在读取时才从数据文件中加载，同一时间只有一个分块在内存中
+// 不是 PutStream 写入的 value 一次性读取
+func (db *DB) GetStream(key []byte) (io.ReadCloser, error) {
+	if len(key) == 0 {
+		return nil, ErrKeyIsEmpty
+	}
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	logRecordPos := db.index.Get(key)
+	if logRecordPos == nil {
+		return nil, ErrKeyNotFound
+	}
+	logRecord, err := db.readLogRecord(logRecordPos)
+	if err != nil {
+		return nil, err
+	}
+	if logRecord.Type != data.LogRecordStream {
+		value, err := db.getValueByPosition(logRecordPos)
+		if err != nil {
+			return nil, err
+		}
+		return io.NopCloser(bytes.NewReader(value)), nil
+	}
+	_, chunks := data.DecodeStreamManifest(logRecord.Value)
+	return &streamReader{db: db, chunks: chunks}, nil
+}
+
+// appendChunk 追加写入一个分块
+func (db *DB) appendChunk(key, chunk []byte) (*data.LogRecordPos, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.appendLogRecord(&data.LogRecord{
+		Key:   logRecordKeyWithSeqNo(key, nonTransactionSeqNo),
+		Value: chunk,
+		Type:  data.LogRecordChunk,
+	})
+}
+
+// discardChunks 写入失败时已经写入的分块都是无效数据
+func (db *DB) discardChunks(chunks []*data.LogRecordPos) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	for _, chunk := range chunks {
+		db.reclaimableSize += int64(chunk.Size)
+	}
+}
+
+// streamChunksSize 分块清单中所有分块记录的大小
+func streamChunksSize(manifest []byte) int64 {
+	var size int64
+	_, chunks := data.DecodeStreamManifest(manifest)
+	for _, chunk := range chunks {
+		size += int64(chunk.Size)
+	}
+	return size
+}
+
+// readStreamValue 读取分块清单中所有的分块，拼接为完整的 value
+func (db *DB) readStreamValue(manifest *data.LogRecord) ([]byte, error) {
+	size, chunks := data.DecodeStreamManifest(manifest.Value)
+	value := make([]byte, 0, size)
+	for _, chunk := range chunks {
+		logRecord, err := db.readLogRecord(chunk)
+		if err != nil {
+			return nil, err
+		}
+		value = append(value, logRecord.Value...)
+	}
+	return value, nil
+}
+
+// mergeStream 将分块和分块清单写入 merge 的数据文件
+func (db *DB) mergeStream(mergeDB *DB, manifest *data.LogRecord) (*data.LogRecordPos, error) {
+	size, chunks := data.DecodeStreamManifest(manifest.Value)
+	merged := make([]*data.LogRecordPos, len(chunks))
+	for i, chunk := range chunks {
+		logRecord, err := db.readLogRecord(chunk)
+		if err != nil {
+			return nil, err
+		}
+		if merged[i], err = mergeDB.appendLogRecord(logRecord); err != nil {
+			return nil, err
+		}
+	}
+	manifest.Value = data.EncodeStreamManifest(size, merged)
+	return mergeDB.appendLogRecord(manifest)
+}
+
+// streamReader GetStream 返回的 reader，依次读取每个分块
+type streamReader struct {
+	db     *DB
+	chunks []*data.LogRecordPos // 还没有读取的分块
+	buf    []byte               // 当前分块中还没有返回的数据
+	closed bool
+}
+
+func (sr *streamReader) Read(p []byte) (int, error) {
+	if sr.closed {
+		return 0, io.ErrClosedPipe
+	}
+	for len(sr.buf) == 0 {
+		if len(sr.chunks) == 0 {
+			return 0, io.EOF
+		}
+		sr.db.mu.RLock()
+		logRecord, err := sr.db.readLogRecord(sr.chunks[0])
+		sr.db.mu.RUnlock()
+		if err != nil {
+			return 0, err
+		}
+		sr.buf = logRecord.Value
+		sr.chunks = sr.chunks[1:]
+	}
+	n := copy(p, sr.buf)
+	sr.buf = sr.buf[n:]
+	return n, nil
+}
+
+func (sr *streamReader) Close() error {
+	sr.closed = true
+	sr.buf = nil
+	sr.chunks = nil
+	return nil
+}
@@ -0,0 +1,173 @@
+package bitcask_db
+
+import (
+	"bitcask-db/data"
+	"bitcask-db/utils"
+	"bytes"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"math"
+	"os"
+	"testing"
+)
+
+// failingReader 读取 n 个字节之后返回错误
+type failingReader struct {
+	r io.Reader
+	n int
+}
+
+func (fr *failingReader) Read(p []byte) (int, error) {
+	if fr.n <= 0 {
+		return 0, errors.New("read failed")
+	}
+	if len(p) > fr.n {
+		p = p[:fr.n]
+	}
+	n, err := fr.r.Read(p)
+	fr.n -= n
+	return n, err
+}
+
+// hookReader 读取 n 个字节之后执行一次 hook
+type hookReader struct {
+	r    io.Reader
+	n    int
+	hook func()
+}
+
+func (hr *hookReader) Read(p []byte) (int, error) {
+	if hr.n <= 0 && hr.hook != nil {
+		hr.hook()
+		hr.hook = nil
+	}
+	n, err := hr.r.Read(p)
+	hr.n -= n
+	return n, err
+}
+
+func TestDB_PutStream(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-db-stream")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.StreamChunkSize = 4 * 1024
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	assert.Nil(t, err)
+
+	key := utils.GetTestKey(1)
+	value := utils.RandomValue(100*1024 + 100)
+	assert.Nil(t, db.PutStream(key, bytes.NewReader(value)))
+	// 分块分布在多个数据文件中
+	assert.True(t, len(db.olderFiles) > 1)
+
+	reader, err := db.GetStream(key)
+	assert.Nil(t, err)
+	streamed, err := io.ReadAll(reader)
+	assert.Nil(t, err)
+	assert.Nil(t, reader.Close())
+	assert.Equal(t, value, streamed)
+	val, err := db.Get(key)
+	assert.Nil(t, err)
+	assert.Equal(t, value, val)
+
+	// 读取失败时之前的值不受影响
+	err = db.PutStream(key, &failingReader{r: bytes.NewReader(utils.RandomValue(20 * 1024)), n: 10 * 1024})
+	assert.NotNil(t, err)
+	val, err = db.Get(key)
+	assert.Nil(t, err)
+	assert.Equal(t, value, val)
+
+	// 空的 value
+	assert.Nil(t, db.PutStream(utils.GetTestKey(2), bytes.NewReader(nil)))
+	val, err = db.Get(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(val))
+
+	// 普通写入的 value 也可以使用 GetStream 读取
+	assert.Nil(t, db.Put(utils.GetTestKey(3), []byte("value")))
+	reader, err = db.GetStream(utils.GetTestKey(3))
+	assert.Nil(t, err)
+	streamed, err = io.ReadAll(reader)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value"), streamed)
+	_, err = db.GetStream(utils.GetTestKey(4))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// 写入分块的过程中进行 merge，之前写入的分块在 merge 的文件中
+	value2 := utils.RandomValue(40 * 1024)
+	reader2 := &hookReader{r: bytes.NewReader(value2), n: 20 * 1024, hook: func() {
+		assert.Nil(t, db.Merge())
+	}}
+	assert.Nil(t, db.PutStream(utils.GetTestKey(5), reader2))
+	assert.Nil(t, reader2.hook)
+	assert.Nil(t, db.Close())
+
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	reader, err = db2.GetStream(key)
+	assert.Nil(t, err)
+	streamed, err = io.ReadAll(reader)
+	assert.Nil(t, err)
+	assert.Equal(t, value, streamed)
+	val, err = db2.Get(utils.GetTestKey(5))
+	assert.Nil(t, err)
+	assert.Equal(t, value2, val)
+	assert.Equal(t, uint(4), db2.Stat().KeyNum)
+}
+
+func TestDB_PutStreamReclaimable(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-db-stream-reclaimable")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.StreamChunkSize = 4 * 1024
+	db, err := Open(opts)
+	assert.Nil(t, err)
+
+	key := utils.GetTestKey(1)
+	assert.Nil(t, db.PutStream(key, bytes.NewReader(utils.RandomValue(40*1024))))
+	assert.Equal(t, int64(0), db.Stat().ReclaimableSize)
+
+	// 覆盖之后之前的分块都是无效数据
+	assert.Nil(t, db.PutStream(key, bytes.NewReader(utils.RandomValue(40*1024))))
+	reclaimable := db.Stat().ReclaimableSize
+	assert.True(t, reclaimable > 40*1024)
+
+	// 重启之后从数据文件和 hint 文件中得到同样的结果
+	assert.Nil(t, db.Close())
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, reclaimable, db2.Stat().ReclaimableSize)
+
+	// 删除之后当前的分块同样是无效数据
+	assert.Nil(t, db2.Delete(key))
+	assert.True(t, db2.Stat().ReclaimableSize > reclaimable+40*1024)
+	assert.Nil(t, db2.Close())
+
+	db3, err := Open(opts)
+	defer destroyDB(db3)
+	assert.Nil(t, err)
+	assert.True(t, db3.Stat().ReclaimableSize > reclaimable+40*1024)
+}
+
+func TestLogRecordPosSize(t *testing.T) {
+	// 超过 uint32 范围的分块总大小取最大值，不会溢出
+	chunks := make([]*data.LogRecordPos, 3)
+	for i := range chunks {
+		chunks[i] = &data.LogRecordPos{Fid: uint32(i), Size: 2 * 1024 * 1024 * 1024}
+	}
+	manifest := &data.LogRecord{Value: data.EncodeStreamManifest(6*1024*1024*1024, chunks), Type: data.LogRecordStream}
+	assert.Equal(t, uint32(math.MaxUint32), logRecordPosSize(manifest, 100))
+	manifest.Value = data.EncodeStreamManifest(1024, chunks[:1])
+	assert.Equal(t, uint32(2*1024*1024*1024+100), logRecordPosSize(manifest, 100))
+
+	// 操作数记录包含之前的操作数链
+	prev := &data.LogRecordPos{Size: math.MaxUint32 - 10}
+	operand := &data.LogRecord{Value: data.EncodeMergeOperand(2, prev, []byte("a")), Type: data.LogRecordMerge}
+	assert.Equal(t, uint32(math.MaxUint32), logRecordPosSize(operand, 100))
+	assert.Equal(t, uint32(100), logRecordPosSize(&data.LogRecord{Type: data.LogRecordNormal}, 100))
+}
@@ -51,7 +51,7 @@ func CopyDir(src, dest string, exclude []string) error {
 		}
 		for _, e := range exclude {
 			matched, err := filepath.Match(e, info.Name())
-			if err == nil {
+			if err != nil {
 				return err
 			}
 			if matched {
@@ -3,6 +3,7 @@ package utils
 import (
 	"github.com/stretchr/testify/assert"
 	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -19,3 +20,28 @@ func TestAvailableDiskSize(t *testing.T) {
 	assert.True(t, size > 0)
 	t.Log(size / 1024 / 1024 / 1024)
 }
+
+func TestCopyDir(t *testing.T) {
+	src, _ := os.MkdirTemp("", "bitcask-db-copy-src")
+	defer os.RemoveAll(src)
+	dest, _ := os.MkdirTemp("", "bitcask-db-copy-dest")
+	defer os.RemoveAll(dest)
+	assert.Nil(t, os.WriteFile(filepath.Join(src, "000000001.data"), []byte("data"), 0644))
+	assert.Nil(t, os.WriteFile(filepath.Join(src, "flock"), []byte("lock"), 0644))
+	assert.Nil(t, os.MkdirAll(filepath.Join(src, "sub"), os.ModePerm))
+	assert.Nil(t, os.WriteFile(filepath.Join(src, "sub", "000000002.data"), []byte("sub"), 0644))
+
+	// 名称匹配 exclude 的文件不拷贝，其他的文件和子目录都拷贝
+	assert.Nil(t, CopyDir(src, dest, []string{"flock"}))
+	data, err := os.ReadFile(filepath.Join(dest, "000000001.data"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("data"), data)
+	data, err = os.ReadFile(filepath.Join(dest, "sub", "000000002.data"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("sub"), data)
+	_, err = os.Stat(filepath.Join(dest, "flock"))
+	assert.True(t, os.IsNotExist(err))
+
+	// 不合法的匹配模式返回错误
+	assert.Equal(t, filepath.ErrBadPattern, CopyDir(src, dest, []string{"["}))
+}